@@ -27,6 +27,8 @@ func main() {
 	rootCmd.AddCommand(commands.NewNewCmd())
 	rootCmd.AddCommand(commands.NewGenerateCmd())
 	rootCmd.AddCommand(commands.NewTokenCmd())
+	rootCmd.AddCommand(commands.NewKeyCmd())
+	rootCmd.AddCommand(commands.NewCertCmd())
 
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
@@ -1,19 +1,46 @@
 package main
 
 import (
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 	"gopkg.in/yaml.v3"
 )
 
 func main() {
+	// The web-service pins the certificate of the auth-service, which is
+	// generated here unless it already exists so that the pin survives
+	// regenerating the peers file.
+	certFile := filepath.Join(".", "auth-service", "server.crt")
+	keyFile := filepath.Join(".", "auth-service", "server.key")
+	if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+		err = host.GenerateCertificate(certFile, keyFile, []string{"192.168.100.2", "localhost", "127.0.0.1"})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	certData, err := os.ReadFile(certFile)
+	if err != nil {
+		panic(err)
+	}
+	block, _ := pem.Decode(certData)
+	if block == nil || block.Type != "CERTIFICATE" {
+		panic(fmt.Sprintf("%s does not contain a PEM encoded certificate", certFile))
+	}
+
 	peers := coattailtypes.PeersFile{
 		Peers: []coattailtypes.PeerDetails{{
-			Address: "192.168.100.2:5243",
-			Token:   api.CreateToken(filepath.Join(".", "auth-service", "secret.key"), "0.0.0.0/0", 7, ""),
+			Address:     "192.168.100.2:5243",
+			Token:       api.CreateToken(filepath.Join(".", "auth-service", "secret.key"), "0.0.0.0/0", 7, ""),
+			Fingerprint: fingerprint.Of(block.Bytes),
 		}},
 	}
 
@@ -9,3 +9,7 @@ import (
 func (c *App) OnStart(ctx context.Context, local *coattailtypes.Peer) {
 
 }
+
+func (c *App) OnStop(ctx context.Context, local *coattailtypes.Peer) {
+
+}
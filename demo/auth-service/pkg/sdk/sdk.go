@@ -4,7 +4,6 @@ package sdk
 import (
     "github.com/nathan-fiscaletti/ct1/pkg/types"
 	"context"
-	"fmt"
 
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
@@ -26,9 +25,5 @@ func (s *Sdk) Authenticate(ctx context.Context, arg types.Request) (types.Respon
         return types.Response{}, err
     }
 
-    if out, ok := res.(types.Response); ok {
-        return out, nil
-    }
-
-    return types.Response{}, fmt.Errorf("unexpected response type: %T", res)
+    return coattailtypes.ConvertValue[types.Response](res)
 }
@@ -11,6 +11,7 @@ require (
 	github.com/invopop/jsonschema v0.12.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	github.com/samber/lo v1.47.0 // indirect
@@ -24,6 +25,7 @@ require (
 )
 
 replace github.com/nathan-fiscaletti/coattail-go => ../../
+
 replace github.com/nathan-fiscaletti/ct1 => ../auth-service/
 
 go 1.23.2
@@ -25,3 +25,7 @@ func (a *App) OnStart(ctx context.Context, local *coattailtypes.Peer) {
 	log.Default().Println("Authenticated: ", response.Authenticated)
 	log.Default().Println("If you see this message, the demo has completed successfully! 🎉")
 }
+
+func (a *App) OnStop(ctx context.Context, local *coattailtypes.Peer) {
+
+}
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/invopop/jsonschema v0.12.0
+	github.com/klauspost/compress v1.17.9
 	github.com/samber/lo v1.47.0
 	github.com/spf13/cobra v1.8.1
 	github.com/vmihailenco/msgpack/v5 v5.4.1
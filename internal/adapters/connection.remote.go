@@ -0,0 +1,312 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+const (
+	// DefaultReconnectBackoff is the default amount of time to wait before
+	// the first attempt to reconnect to a remote peer.
+	DefaultReconnectBackoff = 250 * time.Millisecond
+	// DefaultMaxReconnectBackoff is the default maximum amount of time to
+	// wait between attempts to connect to a remote peer.
+	DefaultMaxReconnectBackoff = 30 * time.Second
+	// DefaultConnectTimeout is the default amount of time that calls to a
+	// remote peer wait for a connection before they fail.
+	DefaultConnectTimeout = 10 * time.Second
+)
+
+// shutdownPollInterval is the interval at which a connection that is being
+// shut down checks whether the calls in flight on it have completed.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// remoteConnection manages a connection to a remote peer. The connection is
+// opened once it is started and is re-established in the background with
+// exponential backoff whenever it is lost.
+type remoteConnection struct {
+	details  coattailtypes.PeerDetails
+	onChange func(context.Context, coattailtypes.ConnectionStateChange)
+
+	mu      sync.Mutex
+	ctx     context.Context
+	handler *packets.Handler
+	state   coattailtypes.ConnectionState
+	closed  bool
+	done    chan struct{}
+}
+
+func newRemoteConnection(details coattailtypes.PeerDetails, onChange func(context.Context, coattailtypes.ConnectionStateChange)) *remoteConnection {
+	return &remoteConnection{
+		details:  details,
+		onChange: onChange,
+		done:     make(chan struct{}),
+	}
+}
+
+// detachedContext carries the values of a context without its deadline or
+// cancellation, so that a connection outlives the call that opened it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// start starts connecting to the peer in the background.
+func (c *remoteConnection) start(ctx context.Context) {
+	c.ctx = ctx
+	go c.run()
+}
+
+// current returns the handler of the connection, or nil if it is not
+// connected.
+func (c *remoteConnection) current() *packets.Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != coattailtypes.ConnectionStateConnected {
+		return nil
+	}
+
+	return c.handler
+}
+
+// connectionState returns the current state of the connection.
+func (c *remoteConnection) connectionState() coattailtypes.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// close closes the connection and stops reconnecting.
+func (c *remoteConnection) close() {
+	if handler := c.stop(); handler != nil {
+		handler.Close()
+	}
+}
+
+// shutdown stops reconnecting, waits for the calls in flight on the
+// connection to complete or ctx to be done, and then says goodbye to the
+// peer and closes the connection.
+func (c *remoteConnection) shutdown(ctx context.Context) {
+	handler := c.stop()
+	if handler == nil {
+		return
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for handler.Pending() > 0 {
+		select {
+		case <-ticker.C:
+		case <-handler.Done():
+			return
+		case <-ctx.Done():
+			handler.Close()
+			return
+		}
+	}
+
+	handler.Shutdown(ctx)
+}
+
+// stop marks the connection as closed and returns its handler, or nil if it
+// is not connected or was already closed.
+func (c *remoteConnection) stop() *packets.Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	close(c.done)
+	return c.handler
+}
+
+// run connects to the peer, and reconnects whenever the connection is lost,
+// until the connection is closed.
+func (c *remoteConnection) run() {
+	for failures := 0; ; {
+		if !c.setState(coattailtypes.ConnectionStateConnecting, nil, nil, 0) {
+			return
+		}
+
+		handler, err := c.connect()
+		if err == nil {
+			failures = 0
+			if !c.setState(coattailtypes.ConnectionStateConnected, handler, nil, 0) {
+				handler.Close()
+				return
+			}
+
+			<-handler.Done()
+			err = packets.ErrConnectionClosed
+		} else {
+			failures++
+		}
+
+		// The first attempt to reconnect after a connection is lost is made
+		// right away.
+		var wait time.Duration
+		if failures > 0 {
+			wait = c.backoff(failures)
+		}
+
+		if !c.setState(coattailtypes.ConnectionStateDisconnected, nil, err, wait) {
+			return
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// backoff returns the amount of time to wait after the provided number of
+// consecutive failed connection attempts. The wait doubles after each
+// failure, up to the maximum, and is randomized so that peers that lost
+// their connections at the same time don't all reconnect at once.
+func (c *remoteConnection) backoff(failures int) time.Duration {
+	wait := c.details.ReconnectBackoff
+	for i := 1; i < failures && wait < c.details.MaxReconnectBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.details.MaxReconnectBackoff {
+		wait = c.details.MaxReconnectBackoff
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// setState records a new state of the connection and reports the change.
+// Returns false if the connection has been closed.
+func (c *remoteConnection) setState(state coattailtypes.ConnectionState, handler *packets.Handler, err error, retryIn time.Duration) bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+
+	c.state = state
+	c.handler = handler
+	ctx := c.ctx
+	c.mu.Unlock()
+
+	if logger, _ := logging.GetLogger(ctx); logger != nil {
+		if err != nil {
+			logger.Printf("connection to %s %s: %s\n", c.details.Address, state, err)
+		} else {
+			logger.Printf("connection to %s %s\n", c.details.Address, state)
+		}
+	}
+
+	if c.onChange != nil {
+		c.onChange(ctx, coattailtypes.ConnectionStateChange{
+			Peer:    c.details,
+			State:   state,
+			Err:     err,
+			RetryIn: retryIn,
+		})
+	}
+
+	return true
+}
+
+// connect opens and authenticates a new connection to the peer.
+func (c *remoteConnection) connect() (*packets.Handler, error) {
+	dialer := net.Dialer{Timeout: c.details.ConnectTimeout}
+	conn, err := dialer.Dial("tcp", c.details.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := clientTLSConfig(c.details)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+
+	// Perform the TLS handshake
+	tlsConn.SetDeadline(time.Now().Add(c.details.ConnectTimeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		tlsConn.Close()
+
+		// Peers were trusted without verification by earlier versions, so
+		// most of them use the self-signed certificate that the service
+		// generated.
+		var authorityErr x509.UnknownAuthorityError
+		if errors.As(err, &authorityErr) {
+			return nil, fmt.Errorf("failed to perform TLS handshake: %w (pin the certificate of the peer by setting fingerprint in peers.yaml to the output of `coattail cert fingerprint` on the peer, or set ca to the certificate authority that issued it)", err)
+		}
+
+		return nil, fmt.Errorf("failed to perform TLS handshake: %w", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	if logger, _ := logging.GetLogger(c.ctx); logger != nil {
+		state := tlsConn.ConnectionState()
+		logger.Printf("TLS Connection established with %s\n", state.ServerName)
+	}
+
+	ctxWithAuthKey := context.WithValue(c.ctx, keys.AuthenticationKey, c.details.Token)
+	handler, err := packets.NewHandler(ctxWithAuthKey, tlsConn, packets.InputRoleClient, packets.HandlerConfig{
+		Codecs:               c.details.Codecs,
+		IdleTimeout:          c.details.IdleTimeout,
+		KeepaliveInterval:    c.details.KeepaliveInterval,
+		Compression:          c.details.Compression,
+		CompressionThreshold: c.details.CompressionThreshold,
+		MaxFrameSize:         c.details.MaxFrameSize,
+		MaxPacketSize:        c.details.MaxPacketSize,
+		AllowBearerTokens:    c.details.AllowBearerToken,
+	})
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	handler.HandlePackets(false)
+
+	// Calls are only sent once the connection has been authenticated, so
+	// that they aren't rejected by the peer.
+	authCtx, cancel := context.WithTimeout(context.Background(), c.details.ConnectTimeout)
+	defer cancel()
+	if err := handler.WaitAuthenticated(authCtx); err != nil {
+		handler.Close()
+		return nil, err
+	}
+
+	return handler, nil
+}
@@ -0,0 +1,59 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func TestPeerUnavailable(t *testing.T) {
+	// Reserve an address that nothing is listening on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	local := &adapters.LocalPeerAdapter{
+		Peers: []coattailtypes.PeerDetails{{
+			Address:          address,
+			ReconnectBackoff: 10 * time.Millisecond,
+			ConnectTimeout:   200 * time.Millisecond,
+		}},
+	}
+
+	ctx := context.Background()
+	peer, err := local.GetPeer(ctx, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = peer.Run(ctx, "Action", nil)
+	if !errors.Is(err, coattailtypes.ErrPeerUnavailable) {
+		t.Fatalf("expected %s, got %v", coattailtypes.ErrPeerUnavailable, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected the call to wait for the connect timeout, returned after %s", elapsed)
+	}
+
+	// The peer is cached, so that it keeps a single connection.
+	again, err := local.GetPeer(ctx, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.PeerAdapter != peer.PeerAdapter {
+		t.Errorf("expected the same adapter for the peer")
+	}
+
+	remote := peer.PeerAdapter.(*adapters.RemotePeerAdapter)
+	if state := remote.ConnectionState(); state == coattailtypes.ConnectionStateConnected {
+		t.Errorf("expected the peer not to be connected")
+	}
+}
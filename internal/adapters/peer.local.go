@@ -2,17 +2,23 @@ package adapters
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/database"
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
 	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
 	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailmodels"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 	"github.com/samber/lo"
@@ -25,10 +31,20 @@ var (
 
 /* ====== Local Peer Initialization ====== */
 
-func InitLocalPeer(host *host.Host) error {
-	peers, err := loadPeers()
+func InitLocalPeer(host *host.Host, app coattailtypes.App, peers []coattailtypes.PeerDetails) error {
+	publisherID, err := newPublisherID()
 	if err != nil {
-		return fmt.Errorf("error loading peers: %s", err)
+		return fmt.Errorf("error generating publisher id: %s", err)
+	}
+
+	adapter := &LocalPeerAdapter{
+		Units:       []coattailtypes.UnitImpl{},
+		Peers:       peers,
+		publisherID: publisherID,
+	}
+
+	if withState, ok := app.(coattailtypes.AppWithConnectionState); ok {
+		adapter.onConnectionStateChange = withState.OnConnectionStateChange
 	}
 
 	host.LocalPeer = coattailtypes.NewPeer(
@@ -36,23 +52,29 @@ func InitLocalPeer(host *host.Host) error {
 			IsLocal: true,
 			Address: host.Config.ServiceConfig.Address.String(),
 		},
-		&LocalPeerAdapter{
-			Units: []coattailtypes.UnitImpl{},
-			Peers: peers,
-		},
+		adapter,
 	)
 	return nil
 }
 
-func loadPeers() ([]coattailtypes.PeerDetails, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
+// newPublisherID returns a random ID that identifies the notifications
+// published by this instance, so that the sequence numbers of the
+// notifications published before a restart are never confused with the ones
+// published after it.
+func newPublisherID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
 	}
 
+	return hex.EncodeToString(id), nil
+}
+
+// LoadPeers reads the remote peers from the provided path. Returns no peers
+// if the file does not exist.
+func LoadPeers(peersFile string) ([]coattailtypes.PeerDetails, error) {
 	result := []coattailtypes.PeerDetails{}
 
-	peersFile := filepath.Join(cwd, "peers.yaml")
 	if _, err := os.Stat(peersFile); os.IsNotExist(err) {
 		return result, nil
 	}
@@ -61,6 +83,7 @@ func loadPeers() ([]coattailtypes.PeerDetails, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	peers := coattailtypes.PeersFile{}
 	err = yaml.NewDecoder(f).Decode(&peers)
@@ -68,6 +91,19 @@ func loadPeers() ([]coattailtypes.PeerDetails, error) {
 		return nil, err
 	}
 
+	for i, peer := range peers.Peers {
+		if peer.Fingerprint != "" {
+			if _, err := fingerprint.Parse(peer.Fingerprint); err != nil {
+				return nil, fmt.Errorf("peer %s: %w", peer.Address, err)
+			}
+		}
+
+		// Certificate authorities are relative to the peers file.
+		if peer.CA != "" && !filepath.IsAbs(peer.CA) {
+			peers.Peers[i].CA = filepath.Join(filepath.Dir(peersFile), peer.CA)
+		}
+	}
+
 	return peers.Peers, nil
 }
 
@@ -75,7 +111,23 @@ func loadPeers() ([]coattailtypes.PeerDetails, error) {
 
 type LocalPeerAdapter struct {
 	Units []coattailtypes.UnitImpl
-	Peers []coattailtypes.PeerDetails
+	// Peers are the remote peers. They are replaced with UpdatePeers while
+	// the host is running.
+	Peers   []coattailtypes.PeerDetails
+	peersMu sync.RWMutex
+
+	publisherID string
+	sequenceMu  sync.Mutex
+	sequences   map[string]uint64
+	skipped     map[string][]uint64
+	sequencers  map[string]*sequencer
+	pruned      time.Time
+
+	// Remote peers are cached so that each one has a single pool of
+	// connections.
+	remotesMu               sync.Mutex
+	remotes                 map[string]*RemotePeerAdapter
+	onConnectionStateChange func(context.Context, coattailtypes.ConnectionStateChange)
 }
 
 /* ====== Units ====== */
@@ -85,7 +137,7 @@ func (i *LocalPeerAdapter) getUnit(hType coattailtypes.UnitType, name string) (c
 		return h.UnitType == hType && h.Name == name
 	})
 	if !ok {
-		return coattailtypes.UnitImpl{}, fmt.Errorf("handler %s not found", name)
+		return coattailtypes.UnitImpl{}, fmt.Errorf("handler %s %w", name, coattailtypes.ErrNotFound)
 	}
 
 	return h, nil
@@ -103,7 +155,28 @@ func (i *LocalPeerAdapter) runUnit(ctx context.Context, arg runUnitArguments) (a
 		return nil, err
 	}
 
-	return h.Execute(ctx, arg.Args)
+	res, err := h.Execute(ctx, arg.Args)
+	if err != nil {
+		return nil, unitError(err)
+	}
+
+	return res, nil
+}
+
+// unitError wraps an error returned by a unit so that it is reported as an
+// application error.
+func unitError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Arguments that can't be converted to the type expected by the unit
+	// are the fault of the caller rather than the unit.
+	if errors.Is(err, coattailtypes.ErrInvalidRequest) {
+		return err
+	}
+
+	return &coattailtypes.ApplicationError{Err: err}
 }
 
 /* ====== Actions ====== */
@@ -120,6 +193,31 @@ func (i *LocalPeerAdapter) Run(ctx context.Context, name string, arg any) (any,
 	})
 }
 
+func (i *LocalPeerAdapter) RunStream(ctx context.Context, name string, arg any) (coattailtypes.ActionStream, error) {
+	if logger, _ := logging.GetLogger(ctx); logger != nil {
+		logger.Printf("streaming action: %s", name)
+	}
+
+	h, err := i.getUnit(coattailtypes.UnitTypeAction, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLocalActionStream(ctx, func(ctx context.Context, send func(any) error) error {
+		if streaming, isStreaming := h.Unit.(coattailtypes.StreamingUnit); isStreaming {
+			return unitError(streaming.ExecuteStream(ctx, arg, send))
+		}
+
+		// Actions that don't stream produce a single result.
+		res, err := h.Execute(ctx, arg)
+		if err != nil {
+			return unitError(err)
+		}
+
+		return send(res)
+	}), nil
+}
+
 func (i *LocalPeerAdapter) Publish(ctx context.Context, name string, data any) error {
 	if logger, _ := logging.GetLogger(ctx); logger != nil {
 		logger.Printf("publishing action: %s", name)
@@ -140,7 +238,7 @@ func (i *LocalPeerAdapter) Publish(ctx context.Context, name string, data any) e
 	}
 
 	if action == nil {
-		return fmt.Errorf("action %s not found", name)
+		return fmt.Errorf("action %s %w", name, coattailtypes.ErrNotFound)
 	}
 
 	var subscriptions []coattailmodels.Subscription
@@ -148,20 +246,88 @@ func (i *LocalPeerAdapter) Publish(ctx context.Context, name string, data any) e
 		return err
 	}
 
+	// Every subscriber is notified, even if notifying one of them fails.
+	var errs []error
 	for _, sub := range subscriptions {
-		peer, err := i.GetPeerBy(ctx, func(details coattailtypes.PeerDetails) bool {
-			return details.Address == sub.Address
-		})
-		if err != nil {
-			return err
+		if err := i.notifySubscriber(ctx, sub, data); err != nil {
+			errs = append(errs, fmt.Errorf("failed to notify %s on %s: %w", sub.Receiver, sub.Address, err))
 		}
+	}
 
-		if err := peer.Notify(ctx, sub.Receiver, data); err != nil {
-			return err
-		}
+	return errors.Join(errs...)
+}
+
+// notifySubscriber sends a published notification to a subscriber.
+func (i *LocalPeerAdapter) notifySubscriber(ctx context.Context, sub coattailmodels.Subscription, data any) error {
+	peer, err := i.GetPeerBy(ctx, func(details coattailtypes.PeerDetails) bool {
+		return details.Address == sub.Address
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	remote, isRemote := peer.PeerAdapter.(*RemotePeerAdapter)
+	if !isRemote {
+		return peer.Notify(ctx, sub.Receiver, data)
+	}
+
+	// Notifications are numbered so that receivers that use ordered delivery
+	// can run them in the order that they were published. The receiver is
+	// told about the notifications that could not be sent so that it doesn't
+	// wait for them.
+	seq, skipped := i.nextSequence(sub.Address, sub.Receiver)
+	err = remote.notify(ctx, packets.NotifyPacket{
+		Receiver:  sub.Receiver,
+		Data:      data,
+		Publisher: i.publisherID,
+		Sequence:  seq,
+		Skipped:   skipped,
+	})
+	if err != nil {
+		i.skipSequence(sub.Address, sub.Receiver, append(skipped, seq))
+	}
+
+	return err
+}
+
+// nextSequence returns the sequence number of the next notification published
+// to a receiver on the peer at the provided address, along with the sequence
+// numbers of the notifications that failed to be sent to it since the last
+// one that was.
+func (i *LocalPeerAdapter) nextSequence(address string, receiver string) (uint64, []uint64) {
+	i.sequenceMu.Lock()
+	defer i.sequenceMu.Unlock()
+
+	if i.sequences == nil {
+		i.sequences = map[string]uint64{}
+	}
+
+	key := address + "/" + receiver
+	skipped := i.skipped[key]
+	delete(i.skipped, key)
+	i.sequences[key]++
+	return i.sequences[key], skipped
+}
+
+// skipSequence records the sequence numbers of notifications that failed to
+// be sent to a receiver on the peer at the provided address, which the next
+// notification sent to it reports as skipped.
+func (i *LocalPeerAdapter) skipSequence(address string, receiver string, seqs []uint64) {
+	i.sequenceMu.Lock()
+	defer i.sequenceMu.Unlock()
+
+	if i.skipped == nil {
+		i.skipped = map[string][]uint64{}
+	}
+
+	// Only the latest notifications are reported once a receiver has been
+	// unreachable for long, and it waits orderedDeliveryTimeout for the
+	// earlier ones.
+	key := address + "/" + receiver
+	i.skipped[key] = append(i.skipped[key], seqs...)
+	if excess := len(i.skipped[key]) - maxSkippedSequences; excess > 0 {
+		i.skipped[key] = i.skipped[key][excess:]
+	}
 }
 
 func (i *LocalPeerAdapter) RunAndPublish(ctx context.Context, name string, arg any) error {
@@ -232,7 +398,7 @@ func (i *LocalPeerAdapter) HasReceiver(ctx context.Context, name string) (bool,
 }
 
 func (i *LocalPeerAdapter) RegisterReceiver(ctx context.Context, unit coattailtypes.Unit) error {
-	name := reflect.TypeOf(unit).Name()
+	name := unit.Name()
 
 	if exists, _ := i.HasReceiver(ctx, name); exists {
 		return fmt.Errorf("receiver %s already exists", name)
@@ -265,37 +431,212 @@ func (i *LocalPeerAdapter) Notify(ctx context.Context, name string, arg any) err
 	return err
 }
 
+// NotifyInOrder notifies a receiver with a notification that was published
+// with a sequence number. Receivers that use ordered delivery run the
+// notifications of each publisher one at a time, in the order of their
+// sequence numbers, without waiting for the skipped notifications that the
+// publisher failed to send. Other receivers run them as they arrive.
+func (i *LocalPeerAdapter) NotifyInOrder(ctx context.Context, name string, arg any, publisher string, seq uint64, skipped []uint64) error {
+	h, err := i.getUnit(coattailtypes.UnitTypeReceiver, name)
+	if err != nil {
+		return err
+	}
+
+	if ordered, isOrdered := h.Unit.(coattailtypes.OrderedUnit); !isOrdered || !ordered.Ordered() {
+		return i.Notify(ctx, name, arg)
+	}
+
+	s := i.sequencer(publisher, name)
+	s.skip(skipped)
+	if err := s.acquire(ctx, seq, orderedDeliveryTimeout); err != nil {
+		return err
+	}
+	defer s.release(seq)
+
+	return i.Notify(ctx, name, arg)
+}
+
+// sequencer returns the sequencer for the notifications sent to a receiver by
+// a publisher. The sequencers that have been idle for sequencerIdleTimeout
+// are removed along the way.
+func (i *LocalPeerAdapter) sequencer(publisher string, receiver string) *sequencer {
+	i.sequenceMu.Lock()
+	defer i.sequenceMu.Unlock()
+
+	if i.sequencers == nil {
+		i.sequencers = map[string]*sequencer{}
+	}
+
+	if time.Since(i.pruned) > sequencerIdleTimeout/10 {
+		for key, s := range i.sequencers {
+			if s.idle(sequencerIdleTimeout) {
+				delete(i.sequencers, key)
+			}
+		}
+		i.pruned = time.Now()
+	}
+
+	key := publisher + "/" + receiver
+	s, ok := i.sequencers[key]
+	if !ok {
+		s = newSequencer()
+		i.sequencers[key] = s
+	}
+	s.touch()
+
+	return s
+}
+
 /* ====== Peers ====== */
 
+// Close closes the connections to every remote peer.
+func (i *LocalPeerAdapter) Close() {
+	i.remotesMu.Lock()
+	defer i.remotesMu.Unlock()
+
+	for address, remote := range i.remotes {
+		remote.pool.close()
+		delete(i.remotes, address)
+	}
+}
+
+// PeersChange describes how the remote peers changed when they were
+// updated, by address.
+type PeersChange struct {
+	Added   []string
+	Removed []string
+	Updated []string
+}
+
+// Empty returns true if no peer changed.
+func (c PeersChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Updated) == 0
+}
+
+func (c PeersChange) String() string {
+	return fmt.Sprintf("%d added %v, %d removed %v, %d updated %v", len(c.Added), c.Added, len(c.Removed), c.Removed, len(c.Updated), c.Updated)
+}
+
+// UpdatePeers replaces the remote peers. Calls to the peers that were
+// removed or whose details changed, such as their token, are no longer sent
+// on their existing connections, which are closed once the calls in flight
+// on them have completed or ctx is done. Peers whose details changed
+// reconnect with their new details when they are next used.
+func (i *LocalPeerAdapter) UpdatePeers(ctx context.Context, peers []coattailtypes.PeerDetails) PeersChange {
+	var (
+		change PeersChange
+		stale  []*RemotePeerAdapter
+	)
+
+	i.peersMu.Lock()
+	previous := map[string]coattailtypes.PeerDetails{}
+	for _, details := range i.Peers {
+		previous[details.Address] = details
+	}
+
+	current := map[string]bool{}
+	for _, details := range peers {
+		current[details.Address] = true
+		if old, ok := previous[details.Address]; !ok {
+			change.Added = append(change.Added, details.Address)
+		} else if !reflect.DeepEqual(old, details) {
+			change.Updated = append(change.Updated, details.Address)
+		}
+	}
+	for _, details := range i.Peers {
+		if !current[details.Address] {
+			change.Removed = append(change.Removed, details.Address)
+		}
+	}
+
+	// The adapters of the peers that changed are dropped from the cache
+	// before the new peers are visible, so that no call uses their old
+	// details.
+	i.remotesMu.Lock()
+	for _, address := range append(append([]string{}, change.Removed...), change.Updated...) {
+		if remote, ok := i.remotes[address]; ok {
+			stale = append(stale, remote)
+			delete(i.remotes, address)
+		}
+	}
+	i.remotesMu.Unlock()
+
+	i.Peers = append([]coattailtypes.PeerDetails{}, peers...)
+	i.peersMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, remote := range stale {
+		wg.Add(1)
+		go func(remote *RemotePeerAdapter) {
+			defer wg.Done()
+			remote.pool.shutdown(ctx)
+		}(remote)
+	}
+	wg.Wait()
+
+	return change
+}
+
+// remotePeer returns the peer with the provided details, creating its adapter
+// on first use.
+func (i *LocalPeerAdapter) remotePeer(details coattailtypes.PeerDetails) *coattailtypes.Peer {
+	i.remotesMu.Lock()
+	defer i.remotesMu.Unlock()
+
+	if i.remotes == nil {
+		i.remotes = map[string]*RemotePeerAdapter{}
+	}
+
+	remote, ok := i.remotes[details.Address]
+	if !ok {
+		remote = newRemotePeerAdapter(details, newRemotePool(details, i.onConnectionStateChange))
+		i.remotes[details.Address] = remote
+	}
+
+	return coattailtypes.NewPeer(details, remote)
+}
+
 func (i *LocalPeerAdapter) GetPeer(ctx context.Context, address string) (*coattailtypes.Peer, error) {
+	i.peersMu.RLock()
+	defer i.peersMu.RUnlock()
+
 	for _, peerDetails := range i.Peers {
 		if peerDetails.Address == address {
-			return coattailtypes.NewPeer(peerDetails, newRemotePeerAdapter(peerDetails)), nil
+			return i.remotePeer(peerDetails), nil
 		}
 	}
 
-	return nil, fmt.Errorf("peer %s not found", address)
+	return nil, fmt.Errorf("peer %s %w", address, coattailtypes.ErrNotFound)
 }
 
 func (i *LocalPeerAdapter) GetPeerBy(ctx context.Context, predicate func(coattailtypes.PeerDetails) bool) (*coattailtypes.Peer, error) {
+	i.peersMu.RLock()
+	defer i.peersMu.RUnlock()
+
 	for _, peerDetails := range i.Peers {
 		if predicate(peerDetails) {
-			return coattailtypes.NewPeer(peerDetails, newRemotePeerAdapter(peerDetails)), nil
+			return i.remotePeer(peerDetails), nil
 		}
 	}
 
-	return nil, fmt.Errorf("peer not found")
+	return nil, fmt.Errorf("peer %w", coattailtypes.ErrNotFound)
 }
 
 func (i *LocalPeerAdapter) HasPeer(ctx context.Context, address string) (bool, error) {
+	i.peersMu.RLock()
+	defer i.peersMu.RUnlock()
+
 	return lo.ContainsBy(i.Peers, func(peerDetails coattailtypes.PeerDetails) bool {
 		return peerDetails.Address == address
 	}), nil
 }
 
 func (i *LocalPeerAdapter) ListPeers(ctx context.Context) ([]*coattailtypes.Peer, error) {
+	i.peersMu.RLock()
+	defer i.peersMu.RUnlock()
+
 	return lo.Map(i.Peers, func(peerDetails coattailtypes.PeerDetails, _ int) *coattailtypes.Peer {
-		return coattailtypes.NewPeer(peerDetails, newRemotePeerAdapter(peerDetails))
+		return i.remotePeer(peerDetails)
 	}), nil
 }
 
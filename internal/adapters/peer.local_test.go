@@ -0,0 +1,101 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func TestUpdatePeers(t *testing.T) {
+	address, cert, actions := fakePeer(t)
+
+	details := coattailtypes.PeerDetails{
+		Address:           address,
+		Fingerprint:       fingerprint.Of(cert.Raw),
+		Token:             "first",
+		KeepaliveInterval: -1,
+		ConnectTimeout:    5 * time.Second,
+	}
+	local := &adapters.LocalPeerAdapter{
+		Peers: []coattailtypes.PeerDetails{details},
+	}
+
+	ctx := context.Background()
+	run := func(name string) chan error {
+		errs := make(chan error, 1)
+		peer, err := local.GetPeer(ctx, address)
+		if err != nil {
+			errs <- err
+			return errs
+		}
+
+		go func() {
+			_, err := peer.Run(ctx, name, nil)
+			errs <- err
+		}()
+		return errs
+	}
+
+	// The call in flight when the token changes completes on the old
+	// connection, while new calls are sent on a new one.
+	first := run("First")
+	held := <-actions
+
+	details.Token = "second"
+	changes := make(chan adapters.PeersChange, 1)
+	go func() {
+		changes <- local.UpdatePeers(ctx, []coattailtypes.PeerDetails{details})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		peers, _ := local.ListPeers(ctx)
+		if peers[0].Token == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the peer to be updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second := run("Second")
+	next := <-actions
+	if next.conn == held.conn {
+		t.Errorf("expected the call to be sent on a new connection")
+	}
+	next.respond()
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changes:
+		t.Fatal("expected the old connection to wait for the call in flight")
+	default:
+	}
+
+	held.respond()
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+
+	change := <-changes
+	if len(change.Updated) != 1 || len(change.Added) != 0 || len(change.Removed) != 0 {
+		t.Errorf("expected the peer to be updated, got %s", change)
+	}
+
+	// Removed peers can no longer be called.
+	change = local.UpdatePeers(ctx, nil)
+	if len(change.Removed) != 1 {
+		t.Errorf("expected the peer to be removed, got %s", change)
+	}
+	if _, err := local.GetPeer(ctx, address); !errors.Is(err, coattailtypes.ErrNotFound) {
+		t.Errorf("expected %v, got %v", coattailtypes.ErrNotFound, err)
+	}
+}
@@ -2,14 +2,11 @@ package adapters
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
-	"net"
+	"time"
 
-	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
-	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
 	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
 	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailmodels"
@@ -26,47 +23,48 @@ var (
 type RemotePeerAdapter struct {
 	details coattailtypes.PeerDetails
 
-	handler *packets.Handler
+	pool *remotePool
 }
 
-func newRemotePeerAdapter(details coattailtypes.PeerDetails) *RemotePeerAdapter {
+func newRemotePeerAdapter(details coattailtypes.PeerDetails, pool *remotePool) *RemotePeerAdapter {
 	return &RemotePeerAdapter{
 		details: details,
+		pool:    pool,
 	}
 }
 
+// getHandler returns the handler of the connection that the next call to the
+// peer should be sent on, waiting for a connection to be established if
+// necessary.
 func (i *RemotePeerAdapter) getHandler(ctx context.Context) (*packets.Handler, error) {
-	if i.handler == nil || !i.handler.IsConnected() {
-		conn, err := net.Dial("tcp", i.details.Address)
-		if err != nil {
-			return nil, err
-		}
-
-		tlsConfig := &tls.Config{
-			// InsecureSkipVerify skips certificate validation (not recommended in production)
-			// Set this to true only for testing or if you're using a self-signed certificate
-			InsecureSkipVerify: true,
-		}
-
-		tlsConn := tls.Client(conn, tlsConfig)
-
-		// Perform the TLS handshake
-		err = tlsConn.Handshake()
-		if err != nil {
-			return nil, fmt.Errorf("failed to perform TLS handshake: %w", err)
-		}
-
-		if logger, _ := logging.GetLogger(ctx); logger != nil {
-			state := tlsConn.ConnectionState()
-			logger.Printf("TLS Connection established with %s\n", state.ServerName)
-		}
-
-		ctxWithAuthKey := context.WithValue(ctx, keys.AuthenticationKey, i.details.Token)
-		i.handler = packets.NewHandler(ctxWithAuthKey, tlsConn, packets.InputRoleClient)
-		i.handler.HandlePackets(false)
+	return i.pool.get(ctx)
+}
+
+// ConnectionState returns the current state of the connections to the peer.
+func (i *RemotePeerAdapter) ConnectionState() coattailtypes.ConnectionState {
+	return i.pool.connectionState()
+}
+
+// PoolStats returns statistics about the pool of connections to the peer.
+func (i *RemotePeerAdapter) PoolStats() coattailtypes.PoolStats {
+	return i.pool.stats()
+}
+
+// timeout returns the time left before the deadline of ctx, or zero if ctx
+// has no deadline. The remaining time is sent instead of the deadline so
+// that the clocks of the peers don't need to agree.
+func timeout(ctx context.Context) time.Duration {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0
 	}
 
-	return i.handler, nil
+	// A deadline that has already passed is sent as the shortest timeout,
+	// since zero means no timeout.
+	if remaining := time.Until(d); remaining > 0 {
+		return remaining
+	}
+	return time.Nanosecond
 }
 
 /* ====== Actions ====== */
@@ -77,11 +75,12 @@ func (i *RemotePeerAdapter) Run(ctx context.Context, name string, arg any) (any,
 		return nil, err
 	}
 
-	packet, err := ph.Request(packets.Request{
+	packet, err := ph.Request(ctx, packets.Request{
 		Packet: packets.ActionPacket{
-			Type:   packets.ActionPacketTypePerform,
-			Action: name,
-			Arg:    arg,
+			Type:    packets.ActionPacketTypePerform,
+			Action:  name,
+			Arg:     arg,
+			Timeout: timeout(ctx),
 		},
 	})
 	if err != nil {
@@ -103,11 +102,12 @@ func (i *RemotePeerAdapter) Publish(ctx context.Context, name string, data any)
 	}
 
 	// Run as a request to block until the publish is complete
-	_, err = ph.Request(packets.Request{
+	_, err = ph.Request(ctx, packets.Request{
 		Packet: packets.ActionPacket{
-			Type:   packets.ActionPacketTypePublish,
-			Action: name,
-			Arg:    data,
+			Type:    packets.ActionPacketTypePublish,
+			Action:  name,
+			Arg:     data,
+			Timeout: timeout(ctx),
 		},
 	})
 
@@ -120,16 +120,36 @@ func (i *RemotePeerAdapter) RunAndPublish(ctx context.Context, name string, arg
 		return err
 	}
 
-	err = ph.Send(packets.ActionPacket{
-		Type:   packets.ActionPacketTypePerformAndPublish,
-		Action: name,
-		Arg:    arg,
+	// Run as a request so that errors are returned to the caller
+	_, err = ph.Request(ctx, packets.Request{
+		Packet: packets.ActionPacket{
+			Type:    packets.ActionPacketTypePerformAndPublish,
+			Action:  name,
+			Arg:     arg,
+			Timeout: timeout(ctx),
+		},
 	})
+
+	return err
+}
+
+func (i *RemotePeerAdapter) RunStream(ctx context.Context, name string, arg any) (coattailtypes.ActionStream, error) {
+	ph, err := i.getHandler(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	stream, err := ph.Stream(ctx, packets.ActionPacket{
+		Type:    packets.ActionPacketTypeStream,
+		Action:  name,
+		Arg:     arg,
+		Timeout: timeout(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stream, nil
 }
 
 func (i *RemotePeerAdapter) ListActions(ctx context.Context) ([]string, error) {
@@ -138,7 +158,7 @@ func (i *RemotePeerAdapter) ListActions(ctx context.Context) ([]string, error) {
 		return nil, err
 	}
 
-	packet, err := ph.Request(packets.Request{
+	packet, err := ph.Request(ctx, packets.Request{
 		Packet: packets.ListUnitsPacket{
 			Type: coattailtypes.UnitTypeAction,
 		},
@@ -176,7 +196,7 @@ func (i *RemotePeerAdapter) ListReceivers(ctx context.Context) ([]string, error)
 		return nil, err
 	}
 
-	packet, err := ph.Request(packets.Request{
+	packet, err := ph.Request(ctx, packets.Request{
 		Packet: packets.ListUnitsPacket{
 			Type: coattailtypes.UnitTypeReceiver,
 		},
@@ -207,14 +227,21 @@ func (i *RemotePeerAdapter) RegisterReceiver(ctx context.Context, unit coattailt
 }
 
 func (i *RemotePeerAdapter) Notify(ctx context.Context, name string, arg any) error {
+	return i.notify(ctx, packets.NotifyPacket{
+		Receiver: name,
+		Data:     arg,
+	})
+}
+
+func (i *RemotePeerAdapter) notify(ctx context.Context, packet packets.NotifyPacket) error {
 	ph, err := i.getHandler(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = ph.Send(packets.NotifyPacket{
-		Receiver: name,
-		Data:     arg,
+	// Run as a request so that errors returned by the receiver are reported
+	_, err = ph.Request(ctx, packets.Request{
+		Packet: packet,
 	})
 
 	return err
@@ -245,7 +272,7 @@ func (i *RemotePeerAdapter) Subscribe(ctx context.Context, sub coattailmodels.Su
 	}
 
 	// Should use Request here to block until the subscription is complete
-	_, err = ph.Request(packets.Request{
+	_, err = ph.Request(ctx, packets.Request{
 		Packet: packets.SubscribePacket{
 			Address:  sub.Address,
 			Action:   sub.Action,
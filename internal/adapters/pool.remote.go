@@ -0,0 +1,349 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+const (
+	// DefaultMinConnections is the default number of connections that are
+	// kept open to a remote peer.
+	DefaultMinConnections = 1
+	// DefaultMaxConnections is the default maximum number of connections that
+	// can be open to a remote peer.
+	DefaultMaxConnections = 4
+	// DefaultPoolIdleTimeout is the default amount of time that a connection
+	// to a remote peer can go unused before it is closed.
+	DefaultPoolIdleTimeout = 5 * time.Minute
+)
+
+// pooledConnection is a connection in a remotePool.
+type pooledConnection struct {
+	conn     *remoteConnection
+	lastUsed time.Time
+}
+
+// remotePool manages the connections to a remote peer. The connections are
+// opened by the first call to the peer, and calls are spread across them so
+// that a large call doesn't hold up every other call to the peer.
+type remotePool struct {
+	details  coattailtypes.PeerDetails
+	onChange func(context.Context, coattailtypes.ConnectionStateChange)
+
+	mu       sync.Mutex
+	ctx      context.Context
+	conns    []*pooledConnection
+	notified coattailtypes.ConnectionState
+	err      error
+	opened   uint64
+	reaped   uint64
+	closed   bool
+	changed  chan struct{}
+	done     chan struct{}
+}
+
+func newRemotePool(details coattailtypes.PeerDetails, onChange func(context.Context, coattailtypes.ConnectionStateChange)) *remotePool {
+	if details.ReconnectBackoff <= 0 {
+		details.ReconnectBackoff = DefaultReconnectBackoff
+	}
+	if details.MaxReconnectBackoff <= 0 {
+		details.MaxReconnectBackoff = DefaultMaxReconnectBackoff
+	}
+	if details.ConnectTimeout <= 0 {
+		details.ConnectTimeout = DefaultConnectTimeout
+	}
+	if details.Pool.MinConnections <= 0 {
+		details.Pool.MinConnections = DefaultMinConnections
+	}
+	if details.Pool.MaxConnections <= 0 {
+		details.Pool.MaxConnections = DefaultMaxConnections
+	}
+	if details.Pool.MaxConnections < details.Pool.MinConnections {
+		details.Pool.MaxConnections = details.Pool.MinConnections
+	}
+	if details.Pool.IdleTimeout == 0 {
+		details.Pool.IdleTimeout = DefaultPoolIdleTimeout
+	}
+
+	return &remotePool{
+		details:  details,
+		onChange: onChange,
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// get returns the handler of the connection that the next call to the peer
+// should be sent on, waiting for a connection to be established if necessary.
+// Returns an error wrapping ErrPeerUnavailable if no connection is
+// established within the connect timeout.
+func (p *remotePool) get(ctx context.Context) (*packets.Handler, error) {
+	timer := time.NewTimer(p.details.ConnectTimeout)
+	defer timer.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, fmt.Errorf("%w: %s: %w", coattailtypes.ErrPeerUnavailable, p.details.Address, packets.ErrConnectionClosed)
+	}
+
+	if p.ctx == nil {
+		p.ctx = detachedContext{ctx}
+		for len(p.conns) < p.details.Pool.MinConnections {
+			p.open()
+		}
+		if p.details.Pool.IdleTimeout > 0 {
+			go p.reap()
+		}
+	}
+
+	for {
+		if handler := p.pick(); handler != nil {
+			return handler, nil
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+			p.mu.Lock()
+		case <-ctx.Done():
+			p.mu.Lock()
+			return nil, ctx.Err()
+		case <-timer.C:
+			p.mu.Lock()
+			if p.err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", coattailtypes.ErrPeerUnavailable, p.details.Address, p.err)
+			}
+			return nil, fmt.Errorf("%w: %s", coattailtypes.ErrPeerUnavailable, p.details.Address)
+		}
+
+		if p.closed {
+			return nil, fmt.Errorf("%w: %s: %w", coattailtypes.ErrPeerUnavailable, p.details.Address, packets.ErrConnectionClosed)
+		}
+	}
+}
+
+// pick returns the handler of the connected connection with the fewest calls
+// in flight, or nil if no connection is connected. Another connection is
+// opened when every connection is busy. The caller must hold the lock.
+func (p *remotePool) pick() *packets.Handler {
+	var (
+		best        *pooledConnection
+		bestHandler *packets.Handler
+		bestPending int
+		connected   int
+	)
+	for _, pc := range p.conns {
+		handler := pc.conn.current()
+		if handler == nil {
+			continue
+		}
+
+		connected++
+
+		// No new calls are sent on a connection that the peer is about to
+		// close.
+		if handler.Closing() {
+			continue
+		}
+
+		if pending := handler.Pending(); best == nil || pending < bestPending {
+			best, bestHandler, bestPending = pc, handler, pending
+		}
+	}
+
+	// Connections are only added while every connection is connected, so
+	// that a peer that can't be reached doesn't fill the pool.
+	if best != nil && bestPending > 0 && connected == len(p.conns) && len(p.conns) < p.details.Pool.MaxConnections {
+		p.open()
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	best.lastUsed = time.Now()
+	return bestHandler
+}
+
+// open adds a connection to the pool. The caller must hold the lock.
+func (p *remotePool) open() {
+	pc := &pooledConnection{
+		conn:     newRemoteConnection(p.details, p.connectionChanged),
+		lastUsed: time.Now(),
+	}
+	p.conns = append(p.conns, pc)
+	pc.conn.start(p.ctx)
+}
+
+// connectionChanged is called when the state of a connection in the pool
+// changes, and notifies the app when the state of the pool changes.
+func (p *remotePool) connectionChanged(ctx context.Context, change coattailtypes.ConnectionStateChange) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+
+	switch change.State {
+	case coattailtypes.ConnectionStateConnected:
+		p.opened++
+	case coattailtypes.ConnectionStateDisconnected:
+		p.err = change.Err
+	}
+
+	close(p.changed)
+	p.changed = make(chan struct{})
+
+	state := p.state()
+	notify := state != p.notified
+	p.notified = state
+	p.mu.Unlock()
+
+	if notify && p.onChange != nil {
+		change.Peer = p.details
+		change.State = state
+		p.onChange(ctx, change)
+	}
+}
+
+// state returns the state of the pool, which is the most connected state of
+// its connections. The caller must hold the lock.
+func (p *remotePool) state() coattailtypes.ConnectionState {
+	state := coattailtypes.ConnectionStateDisconnected
+	for _, pc := range p.conns {
+		if s := pc.conn.connectionState(); s > state {
+			state = s
+		}
+	}
+
+	return state
+}
+
+// reap closes the connections that have gone unused for longer than the idle
+// timeout, as long as more than the minimum number of connections are open,
+// until the pool is closed.
+func (p *remotePool) reap() {
+	ticker := time.NewTicker(p.details.Pool.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+
+		p.mu.Lock()
+		conns := make([]*pooledConnection, 0, len(p.conns))
+		reaped := 0
+		for _, pc := range p.conns {
+			idle := time.Since(pc.lastUsed) >= p.details.Pool.IdleTimeout
+			if handler := pc.conn.current(); handler != nil && handler.Pending() > 0 {
+				idle = false
+			}
+
+			if idle && len(p.conns)-reaped > p.details.Pool.MinConnections {
+				pc.conn.close()
+				reaped++
+				continue
+			}
+
+			conns = append(conns, pc)
+		}
+		p.conns = conns
+		p.reaped += uint64(reaped)
+		ctx := p.ctx
+		p.mu.Unlock()
+
+		if reaped > 0 {
+			if logger, _ := logging.GetLogger(ctx); logger != nil {
+				logger.Printf("closed %d idle connections to %s\n", reaped, p.details.Address)
+			}
+		}
+	}
+}
+
+// close closes every connection in the pool.
+func (p *remotePool) close() {
+	for _, pc := range p.stop() {
+		pc.conn.close()
+	}
+}
+
+// shutdown stops new calls from being sent to the peer, and closes every
+// connection in the pool once the calls in flight on it have completed or
+// ctx is done, saying goodbye to the peer.
+func (p *remotePool) shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pc := range p.stop() {
+		wg.Add(1)
+		go func(conn *remoteConnection) {
+			defer wg.Done()
+			conn.shutdown(ctx)
+		}(pc.conn)
+	}
+	wg.Wait()
+}
+
+// stop marks the pool as closed and returns the connections that need to be
+// closed, or nil if the pool was already closed.
+func (p *remotePool) stop() []*pooledConnection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	p.closed = true
+	close(p.done)
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return p.conns
+}
+
+// connectionState returns the state of the pool.
+func (p *remotePool) connectionState() coattailtypes.ConnectionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state()
+}
+
+// stats returns statistics about the pool.
+func (p *remotePool) stats() coattailtypes.PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := coattailtypes.PoolStats{
+		Connections:    len(p.conns),
+		MinConnections: p.details.Pool.MinConnections,
+		MaxConnections: p.details.Pool.MaxConnections,
+		Opened:         p.opened,
+		Reaped:         p.reaped,
+	}
+	for _, pc := range p.conns {
+		handler := pc.conn.current()
+		if handler == nil {
+			continue
+		}
+
+		stats.Connected++
+		pending := handler.Pending()
+		stats.InFlight += pending
+		if pending == 0 {
+			stats.Idle++
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,198 @@
+package adapters_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// action is an action request received by a fakePeer.
+type action struct {
+	conn   int
+	name   string
+	server *packets.StreamCodec
+	id     uint64
+}
+
+// respond answers the action with its name.
+func (a action) respond() {
+	a.server.Write(a.id, packets.ActionResponsePacket{
+		Action:       a.name,
+		ResponseData: a.name,
+	})
+}
+
+// fakePeer listens for TLS connections, accepts any token and passes the
+// action requests it receives to the test along with the index of the
+// connection that they were received on. It returns its address and its
+// self-signed certificate.
+func fakePeer(t *testing.T) (string, *x509.Certificate, chan action) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make(chan action)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+
+			go func(i int, conn net.Conn) {
+				handler, err := packets.NewHandler(context.Background(), conn, packets.InputRoleServer, packets.HandlerConfig{})
+				if err != nil {
+					return
+				}
+				server := handler.Session().NewStreamCodec(conn, packets.FrameConfig{})
+
+				for {
+					p, err := server.Read()
+					if err != nil {
+						return
+					}
+
+					switch data := p.Data.(type) {
+					case packets.AuthenticationPacket:
+						server.Write(p.ID, packets.AuthenticationResponsePacket{Authenticated: true})
+					case packets.ActionPacket:
+						select {
+						case actions <- action{conn: i, name: data.Action, server: server, id: p.ID}:
+						case <-done:
+							return
+						}
+					}
+				}
+			}(i, conn)
+		}
+	}()
+
+	return listener.Addr().String(), cert, actions
+}
+
+func TestPool(t *testing.T) {
+	address, cert, actions := fakePeer(t)
+
+	local := &adapters.LocalPeerAdapter{
+		Peers: []coattailtypes.PeerDetails{{
+			Address:           address,
+			Fingerprint:       fingerprint.Of(cert.Raw),
+			KeepaliveInterval: -1,
+			ConnectTimeout:    5 * time.Second,
+			Pool: coattailtypes.PoolConfig{
+				MinConnections: 1,
+				MaxConnections: 2,
+			},
+		}},
+	}
+
+	ctx := context.Background()
+	peer, err := local.GetPeer(ctx, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := peer.PeerAdapter.(coattailtypes.PeerAdapterWithConnections)
+
+	results := make(chan any, 3)
+	run := func(name string) {
+		go func() {
+			result, err := peer.Run(ctx, name, nil)
+			if err != nil {
+				results <- err
+				return
+			}
+			results <- result
+		}()
+	}
+
+	// The first call is held by the peer, so the second call finds every
+	// connection busy and opens another one.
+	run("First")
+	first := <-actions
+	run("Second")
+	second := <-actions
+	if second.conn != first.conn {
+		t.Fatalf("expected the second call to be sent before the new connection was established")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.PoolStats().Connected < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a second connection, got %s", pool.PoolStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The third call is sent on the idle connection.
+	run("Third")
+	third := <-actions
+	if third.conn == first.conn {
+		t.Errorf("expected the third call to be sent on the idle connection")
+	}
+
+	stats := pool.PoolStats()
+	if stats.Connections != 2 || stats.InFlight != 3 || stats.Opened != 2 {
+		t.Errorf("expected 2 connections with 3 calls in flight, got %s", stats)
+	}
+	if state := pool.ConnectionState(); state != coattailtypes.ConnectionStateConnected {
+		t.Errorf("expected the peer to be %s, got %s", coattailtypes.ConnectionStateConnected, state)
+	}
+
+	for _, a := range []action{first, second, third} {
+		a.respond()
+	}
+	for i := 0; i < 3; i++ {
+		if err, ok := (<-results).(error); ok {
+			t.Error(err)
+		}
+	}
+
+	if stats := pool.PoolStats(); stats.Idle != 2 || stats.InFlight != 0 {
+		t.Errorf("expected 2 idle connections, got %s", stats)
+	}
+}
@@ -0,0 +1,178 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// orderedDeliveryTimeout is the amount of time a notification for an ordered
+// receiver waits for the notifications published before it to arrive. Once
+// it passes, the missing notifications are skipped.
+const orderedDeliveryTimeout = 10 * time.Second
+
+// maxSkippedSequences is the maximum number of notifications that failed to
+// be sent that a publisher reports to a receiver as skipped.
+const maxSkippedSequences = 1024
+
+// sequencerIdleTimeout is the amount of time after which the sequencer of a
+// publisher that has stopped sending notifications is removed. Publishers
+// get a new ID each time they start, so the sequencers of publishers that
+// have restarted are never used again.
+const sequencerIdleTimeout = time.Hour
+
+// sequencer runs the notifications sent by a single publisher to an ordered
+// receiver one at a time, in the order of their sequence numbers.
+type sequencer struct {
+	mu       sync.Mutex
+	next     uint64
+	running  bool
+	waiting  map[uint64]struct{}
+	skipped  map[uint64]struct{}
+	changed  chan struct{}
+	lastUsed time.Time
+}
+
+// newSequencer creates a sequencer that starts with the first notification
+// of a publisher, whose sequence number is 1. If earlier notifications were
+// delivered before the sequencer was created, the next one is delivered
+// once it has waited for orderedDeliveryTimeout.
+func newSequencer() *sequencer {
+	return &sequencer{
+		next:     1,
+		waiting:  map[uint64]struct{}{},
+		skipped:  map[uint64]struct{}{},
+		changed:  make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+}
+
+// touch records that a notification is about to use the sequencer.
+func (s *sequencer) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUsed = time.Now()
+}
+
+// idle returns true if no notification is using the sequencer and none has
+// used it for the provided amount of time.
+func (s *sequencer) idle(timeout time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.running && len(s.waiting) == 0 && time.Since(s.lastUsed) > timeout
+}
+
+// acquire waits for the turn of the notification with the provided sequence
+// number. Returns an error if its turn has already passed. release must be
+// called once the notification has been run.
+func (s *sequencer) acquire(ctx context.Context, seq uint64, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seq < s.next {
+		return fmt.Errorf("%w: notification %d arrived after later notifications were delivered", coattailtypes.ErrInvalidRequest, seq)
+	}
+
+	// A notification that the publisher failed to send may still arrive
+	// before its turn.
+	delete(s.skipped, seq)
+	s.waiting[seq] = struct{}{}
+	expired := false
+	for {
+		// Once a notification has waited long enough, the notifications
+		// missing before it are skipped.
+		if !s.running && (seq == s.next || expired && s.first() == seq) {
+			delete(s.waiting, seq)
+			s.next = seq
+			s.running = true
+			return nil
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			expired = true
+		case <-ctx.Done():
+			s.mu.Lock()
+			delete(s.waiting, seq)
+			s.broadcast()
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+}
+
+// release ends the turn of the notification with the provided sequence
+// number.
+func (s *sequencer) release(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = false
+	s.next = seq + 1
+	s.advance()
+	s.lastUsed = time.Now()
+	s.broadcast()
+}
+
+// skip records that the publisher failed to send the notifications with the
+// provided sequence numbers, so that the notifications after them don't
+// wait for them.
+func (s *sequencer) skip(seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seq := range seqs {
+		if _, isWaiting := s.waiting[seq]; seq >= s.next && !isWaiting {
+			s.skipped[seq] = struct{}{}
+		}
+	}
+	s.advance()
+	s.broadcast()
+}
+
+// advance moves past the skipped notifications that are next in turn.
+func (s *sequencer) advance() {
+	if s.running {
+		return
+	}
+
+	for {
+		if _, isSkipped := s.skipped[s.next]; !isSkipped {
+			return
+		}
+		delete(s.skipped, s.next)
+		s.next++
+	}
+}
+
+// first returns the lowest sequence number that is waiting for its turn.
+func (s *sequencer) first() uint64 {
+	var first uint64
+	for seq := range s.waiting {
+		if first == 0 || seq < first {
+			first = seq
+		}
+	}
+
+	return first
+}
+
+func (s *sequencer) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,97 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+type recordingReceiver struct {
+	mu       sync.Mutex
+	received []int
+}
+
+func (r *recordingReceiver) Execute(ctx context.Context, arg *int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.received = append(r.received, *arg)
+	return nil
+}
+
+func TestNotifyInOrder(t *testing.T) {
+	ctx := context.Background()
+	receiver := &recordingReceiver{}
+
+	local := &adapters.LocalPeerAdapter{}
+	err := local.RegisterReceiver(ctx, coattailtypes.NewReceiver[int](receiver, coattailtypes.WithOrderedDelivery()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The notifications arrive in reverse order, so the sequence starts
+	// with a notification that must wait for the first one.
+	var wg sync.WaitGroup
+	for seq := 4; seq >= 1; seq-- {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			if err := local.NotifyInOrder(ctx, "recordingReceiver", seq, "publisher", uint64(seq), nil); err != nil {
+				t.Error(err)
+			}
+		}(seq)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if len(receiver.received) != 4 {
+		t.Fatalf("expected 4 notifications, got %v", receiver.received)
+	}
+	for i, seq := range receiver.received {
+		if seq != i+1 {
+			t.Fatalf("expected notifications in order, got %v", receiver.received)
+		}
+	}
+
+	// Notifications that arrive after later ones have run are rejected.
+	err = local.NotifyInOrder(ctx, "recordingReceiver", 2, "publisher", 2, nil)
+	if !errors.Is(err, coattailtypes.ErrInvalidRequest) {
+		t.Errorf("expected %s, got %v", coattailtypes.ErrInvalidRequest, err)
+	}
+}
+
+func TestNotifyInOrderSkipped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	receiver := &recordingReceiver{}
+
+	local := &adapters.LocalPeerAdapter{}
+	err := local.RegisterReceiver(ctx, coattailtypes.NewReceiver[int](receiver, coattailtypes.WithOrderedDelivery()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The publisher failed to send notifications 2 and 3, so notification 4
+	// runs without waiting for them.
+	for _, notification := range []struct {
+		seq     uint64
+		skipped []uint64
+	}{
+		{1, nil},
+		{4, []uint64{2, 3}},
+	} {
+		err := local.NotifyInOrder(ctx, "recordingReceiver", int(notification.seq), "publisher", notification.seq, notification.skipped)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(receiver.received) != 2 || receiver.received[1] != 4 {
+		t.Errorf("expected notifications 1 and 4, got %v", receiver.received)
+	}
+}
@@ -0,0 +1,83 @@
+package adapters
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// localActionStream is an ActionStream over an action running in the local
+// peer. Results are handed to the receiver one at a time, so an action that
+// produces results faster than they are received is blocked until the
+// receiver catches up.
+type localActionStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results chan any
+	done    chan struct{}
+	err     error
+	closed  atomic.Bool
+}
+
+func newLocalActionStream(ctx context.Context, run func(ctx context.Context, send func(any) error) error) *localActionStream {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &localActionStream{
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make(chan any),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		err := run(ctx, func(result any) error {
+			select {
+			case s.results <- result:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil {
+			err = io.EOF
+		}
+
+		s.err = err
+		close(s.done)
+	}()
+
+	return s
+}
+
+func (s *localActionStream) Recv() (any, error) {
+	if s.closed.Load() {
+		return nil, coattailtypes.ErrStreamClosed
+	}
+
+	select {
+	case result := <-s.results:
+		return result, nil
+	case <-s.done:
+	case <-s.ctx.Done():
+	}
+
+	// The error of an action that has completed is reported even if the
+	// context is also done.
+	if s.closed.Load() {
+		return nil, coattailtypes.ErrStreamClosed
+	}
+	select {
+	case <-s.done:
+		return nil, s.err
+	default:
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *localActionStream) Close() error {
+	s.closed.Store(true)
+	s.cancel()
+	return nil
+}
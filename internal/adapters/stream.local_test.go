@@ -0,0 +1,46 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+type Count struct{}
+
+func (Count) Execute(ctx context.Context, arg *int, w coattailtypes.StreamWriter[int]) error {
+	for i := 0; ; i++ {
+		if err := w.Send(i); err != nil {
+			return err
+		}
+	}
+}
+
+func TestLocalStreamClose(t *testing.T) {
+	ctx := context.Background()
+
+	local := &adapters.LocalPeerAdapter{}
+	if err := local.RegisterAction(ctx, coattailtypes.NewStreamingAction[int, int](Count{})); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := local.RunStream(ctx, "Count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream reports that it was closed, like a stream from a remote
+	// peer, whether or not the action has completed.
+	stream.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := stream.Recv(); !errors.Is(err, coattailtypes.ErrStreamClosed) {
+			t.Fatalf("expected %v, got %v", coattailtypes.ErrStreamClosed, err)
+		}
+	}
+}
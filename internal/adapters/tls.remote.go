@@ -0,0 +1,92 @@
+package adapters
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+var (
+	ErrCertificateMismatch = errors.New("certificate does not match the pinned fingerprint")
+)
+
+// clientTLSConfig returns the TLS configuration that the certificate of a
+// remote peer is verified with. A pinned certificate is trusted on its own,
+// and is only also verified against certificate authorities if some are
+// configured. Otherwise the certificate is verified against the configured
+// certificate authorities, or those of the system, for the name of the
+// peer.
+func clientTLSConfig(details coattailtypes.PeerDetails) (*tls.Config, error) {
+	if details.InsecureSkipVerify {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	serverName := details.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(details.Address)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	cfg := &tls.Config{ServerName: serverName}
+	if details.CA != "" {
+		bundle, err := os.ReadFile(details.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate authorities: %w", err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in %s", details.CA)
+		}
+	}
+
+	if details.Fingerprint == "" {
+		return cfg, nil
+	}
+
+	pin, err := fingerprint.Parse(details.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default verification is replaced by the pin, which is checked
+	// whether or not the certificate is also verified against certificate
+	// authorities.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return ErrCertificateMismatch
+		}
+
+		leaf := state.PeerCertificates[0]
+		if !fingerprint.Matches(leaf.Raw, pin) {
+			return fmt.Errorf("%w: peer presented %s", ErrCertificateMismatch, fingerprint.Of(leaf.Raw))
+		}
+
+		if cfg.RootCAs == nil {
+			return nil
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         cfg.RootCAs,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		})
+		return err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,110 @@
+package adapters_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func TestVerifyCertificate(t *testing.T) {
+	address, cert, actions := fakePeer(t)
+
+	_, otherCert, _ := fakePeer(t)
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for action := range actions {
+			action.respond()
+		}
+	}()
+
+	for _, test := range []struct {
+		name    string
+		details coattailtypes.PeerDetails
+		check   func(error) bool
+	}{
+		{
+			name:    "pinned",
+			details: coattailtypes.PeerDetails{Fingerprint: fingerprint.Of(cert.Raw)},
+		},
+		{
+			name:    "pinned with ca",
+			details: coattailtypes.PeerDetails{Fingerprint: fingerprint.Of(cert.Raw), CA: ca},
+		},
+		{
+			name:    "ca",
+			details: coattailtypes.PeerDetails{CA: ca},
+		},
+		{
+			name:    "insecure",
+			details: coattailtypes.PeerDetails{InsecureSkipVerify: true},
+		},
+		{
+			name:    "pin mismatch",
+			details: coattailtypes.PeerDetails{Fingerprint: fingerprint.Of(otherCert.Raw)},
+			check: func(err error) bool {
+				return errors.Is(err, adapters.ErrCertificateMismatch)
+			},
+		},
+		{
+			name:    "pinned with ca and wrong name",
+			details: coattailtypes.PeerDetails{Fingerprint: fingerprint.Of(cert.Raw), CA: ca, ServerName: "example.com"},
+			check: func(err error) bool {
+				var hostnameErr x509.HostnameError
+				return errors.As(err, &hostnameErr)
+			},
+		},
+		{
+			name:    "unknown authority",
+			details: coattailtypes.PeerDetails{},
+			check: func(err error) bool {
+				var authorityErr x509.UnknownAuthorityError
+				return errors.As(err, &authorityErr) && strings.Contains(err.Error(), "coattail cert fingerprint")
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			details := test.details
+			details.Address = address
+			details.KeepaliveInterval = -1
+			// Loading the certificate authorities of the system can be slow.
+			details.ConnectTimeout = 2 * time.Second
+
+			local := &adapters.LocalPeerAdapter{Peers: []coattailtypes.PeerDetails{details}}
+			defer local.Close()
+
+			ctx := context.Background()
+			peer, err := local.GetPeer(ctx, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = peer.Run(ctx, "Action", nil)
+			if test.check == nil {
+				if err != nil {
+					t.Errorf("expected the certificate to be trusted, got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, coattailtypes.ErrPeerUnavailable) || !test.check(err) {
+				t.Errorf("expected the certificate to be rejected, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+)
+
+// CertificateFingerprint prints the SHA-256 fingerprint of the certificate
+// of a host, which remote peers pin to trust it.
+func CertificateFingerprint(certFile string) {
+	log := cliLogger()
+
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		log.Printf("Error: failed to read certificate: %s\n", err)
+		os.Exit(1)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		log.Printf("Error: %s does not contain a PEM encoded certificate.\n", certFile)
+		os.Exit(1)
+	}
+
+	fmt.Println(fingerprint.Of(block.Bytes))
+}
+
+// GenerateCertificate generates a self-signed certificate for the provided
+// hosts and prints its fingerprint. Existing files are only replaced if
+// force is true.
+func GenerateCertificate(certFile, keyFile string, hosts []string, force bool) {
+	log := cliLogger()
+
+	if !force {
+		for _, file := range []string{certFile, keyFile} {
+			if _, err := os.Stat(file); err == nil {
+				log.Printf("Error: %s already exists, use --force to replace it.\n", file)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = host.CertificateHosts("", nil)
+	}
+
+	if err := host.GenerateCertificate(certFile, keyFile, hosts); err != nil {
+		log.Printf("Error: failed to generate certificate: %s\n", err)
+		os.Exit(1)
+	}
+
+	log.Println("Certificate generated successfully.")
+	log.Println()
+	log.Printf("  Certificate: %s\n", certFile)
+	log.Printf("  Key:         %s\n", keyFile)
+	log.Printf("  Hosts:       %v\n", hosts)
+	log.Println()
+
+	CertificateFingerprint(certFile)
+}
+
+func cliLogger() *log.Logger {
+	ctx, err := util.CreateServiceContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	log, err := logging.GetLogger(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	return log
+}
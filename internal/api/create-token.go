@@ -61,7 +61,18 @@ func CreateToken(keyfile, network string, perm int, expiryStr string) string {
 		os.Exit(1)
 	}
 
-	token, err := authentication.CreateToken(ctx, key, authentication.Claims{
+	// A keyring signs the token with its primary key, otherwise the
+	// keyfile is an HMAC key.
+	signingKey := authentication.Key{Secret: key}
+	if keyring, err := authentication.ParseKeyring(key); err == nil {
+		signingKey, err = keyring.Primary()
+		if err != nil {
+			log.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	token, err := authentication.CreateToken(ctx, signingKey, authentication.Claims{
 		AuthorizedNetwork: *ipnet,
 		Permitted:         int32(perm),
 		Expiry:            expiry,
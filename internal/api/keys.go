@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+// AddKey adds a new key of the provided type to a keyring, creating the
+// keyring if it does not exist. The key becomes the primary key if primary
+// is true or if the keyring has no primary key.
+func AddKey(keyringFile, keyType string, primary bool) string {
+	log, keyring := openKeyring(keyringFile, true)
+
+	key, err := keyring.Add(keyType, primary)
+	if err != nil {
+		log.Printf("Error: failed to add key: %s\n", err)
+		os.Exit(1)
+	}
+	saveKeyring(log, keyring, keyringFile)
+
+	log.Println("Key added successfully.")
+	log.Println()
+	log.Printf("  ID:         %s\n", key.ID)
+	log.Printf("  Type:       %s\n", keyType)
+	log.Printf("  Primary:    %t\n", key.Primary)
+	if key.Type == authentication.KeyTypeEd25519 {
+		log.Printf("  Public Key: %s\n", base64.StdEncoding.EncodeToString(key.PublicKey))
+	}
+
+	return key.ID
+}
+
+// TrustKey adds the Ed25519 public key of another issuer, encoded in base64,
+// to a keyring, creating the keyring if it does not exist. The tokens signed
+// by the issuer with the key are accepted from then on.
+func TrustKey(keyringFile, publicKey string) string {
+	log, keyring := openKeyring(keyringFile, true)
+
+	data, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		log.Printf("Error: failed to decode public key: %s\n", err)
+		os.Exit(1)
+	}
+
+	key, err := keyring.Trust(data)
+	if err != nil {
+		log.Printf("Error: failed to trust key: %s\n", err)
+		os.Exit(1)
+	}
+	saveKeyring(log, keyring, keyringFile)
+
+	log.Println("Key trusted successfully.")
+	log.Println()
+	log.Printf("  ID:         %s\n", key.ID)
+
+	return key.ID
+}
+
+// PublicKeys prints the Ed25519 public keys of a keyring, which are trusted
+// by the services that accept the tokens that it signs.
+func PublicKeys(keyringFile string) {
+	log, keyring := openKeyring(keyringFile, false)
+
+	keys := keyring.PublicKeys(time.Now())
+	if len(keys) == 0 {
+		log.Printf("Error: keyring has no ed25519 keys.\n")
+		os.Exit(1)
+	}
+
+	for _, key := range keys {
+		fmt.Printf("%s %s\n", key.ID, base64.StdEncoding.EncodeToString(key.PublicKey))
+	}
+}
+
+// PromoteKey makes a key the primary key of a keyring, so that it signs the
+// tokens issued from now on. The previous primary key keeps verifying
+// tokens until it is retired.
+func PromoteKey(keyringFile, id string) {
+	log, keyring := openKeyring(keyringFile, false)
+
+	if err := keyring.Promote(id); err != nil {
+		log.Printf("Error: failed to promote key: %s\n", err)
+		os.Exit(1)
+	}
+	saveKeyring(log, keyring, keyringFile)
+
+	log.Println("Key promoted successfully.")
+	log.Println()
+	log.Printf("  ID:         %s\n", id)
+}
+
+// RetireKey retires a key of a keyring. The tokens signed with it are
+// verified until the provided time, after which the key is removed from the
+// keyring.
+func RetireKey(keyringFile, id string, until time.Time) {
+	log, keyring := openKeyring(keyringFile, false)
+
+	if err := keyring.Retire(id, until); err != nil {
+		log.Printf("Error: failed to retire key: %s\n", err)
+		os.Exit(1)
+	}
+	saveKeyring(log, keyring, keyringFile)
+
+	log.Println("Key retired successfully.")
+	log.Println()
+	log.Printf("  ID:         %s\n", id)
+	log.Printf("  Until:      %s\n", until.Format(time.RFC3339))
+}
+
+// ListKeys lists the keys of a keyring.
+func ListKeys(keyringFile string) {
+	log, keyring := openKeyring(keyringFile, false)
+
+	for _, key := range keyring.Keys {
+		status := "active"
+		switch {
+		case key.Primary:
+			status = "primary"
+		case key.Retired():
+			status = "retired until " + key.RetireAt.Format(time.RFC3339)
+		case !key.CanSign():
+			status = "trusted"
+		}
+		if key.Legacy {
+			status += ", legacy"
+		}
+
+		keyType := key.Type
+		if keyType == "" {
+			keyType = authentication.KeyTypeHMAC
+		}
+
+		log.Printf("  %s  %-7s  created %s  %s\n", key.ID, keyType, key.Created.Format(time.RFC3339), status)
+	}
+}
+
+// openKeyring reads a keyring, returning an empty one if it does not exist
+// and create is true.
+func openKeyring(keyringFile string, create bool) (*log.Logger, *authentication.Keyring) {
+	log := cliLogger()
+
+	keyring, err := authentication.LoadKeyring(keyringFile)
+	if os.IsNotExist(err) && create {
+		return log, &authentication.Keyring{}
+	}
+	if os.IsNotExist(err) {
+		log.Printf("Error: keyring does not exist.\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Printf("Error: failed to read keyring: %s\n", err)
+		os.Exit(1)
+	}
+
+	return log, keyring
+}
+
+// saveKeyring writes a keyring, removing the retired keys that no longer
+// verify tokens. Running hosts pick up the changes when they reload.
+func saveKeyring(log *log.Logger, keyring *authentication.Keyring, keyringFile string) {
+	for _, key := range keyring.Prune(time.Now()) {
+		log.Printf("Removed expired key %s.\n", key.ID)
+	}
+
+	if err := keyring.Save(keyringFile); err != nil {
+		log.Printf("Error: failed to save keyring: %s\n", err)
+		os.Exit(1)
+	}
+}
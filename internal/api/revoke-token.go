@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/database"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util"
+)
+
+// RevokeToken revokes a token, given either as the token itself or as its
+// ID, in the database of a host. A running host closes the connections that
+// authenticated with the token once it checks for revoked tokens.
+func RevokeToken(databaseFile, tokenOrID, reason string) string {
+	ctx, err := util.CreateServiceContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	log, err := logging.GetLogger(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	id := tokenOrID
+	var expiry time.Time
+	if token, err := authentication.NewTokenFromString(tokenOrID); err == nil {
+		id = token.RevocationID()
+		expiry = token.Expiry
+	}
+
+	// make sure the database exists
+	if _, err := os.Stat(databaseFile); os.IsNotExist(err) {
+		log.Printf("Error: database does not exist.\n")
+		os.Exit(1)
+	}
+
+	ctx, err = database.ContextWithDatabase(ctx, database.DatabaseConfig{Path: databaseFile})
+	if err != nil {
+		log.Printf("Error: failed to open database: %s\n", err)
+		os.Exit(1)
+	}
+
+	db, err := database.GetDatabase(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	if err := authentication.RevokeToken(db, id, reason, expiry); err != nil {
+		log.Printf("Error: failed to revoke token: %s\n", err)
+		os.Exit(1)
+	}
+
+	log.Println("Token revoked successfully.")
+	log.Println()
+	log.Printf("  ID:         %s\n", id)
+	if !expiry.IsZero() {
+		log.Printf("  Expiry:     %s\n", expiry.Format(time.RFC3339))
+	}
+
+	return id
+}
@@ -65,8 +65,18 @@ func newDatabase(path string) (*Database, error) {
 	return db, nil
 }
 
+// Close closes the connection to the database.
+func (db *Database) Close() error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
 func (db *Database) migrate() error {
-	err := db.AutoMigrate(&coattailmodels.Subscription{})
+	err := db.AutoMigrate(&coattailmodels.Subscription{}, &coattailmodels.RevokedToken{})
 
 	return err
 }
@@ -18,6 +18,7 @@ type ActionTemplateData struct {
 	Name        string `yaml:"name"`
 	InputType   string `yaml:"input"`
 	OutputType  string `yaml:"output"`
+	Streaming   bool   `yaml:"streaming,omitempty"`
 	PackageName string `yaml:"package_name"`
 
 	templates *embed.FS
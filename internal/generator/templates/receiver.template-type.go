@@ -18,6 +18,7 @@ type ReceiverTemplateData struct {
 	PackageName string `yaml:"package_name"`
 	Name        string `yaml:"name"`
 	InputType   string `yaml:"input"`
+	Ordered     bool   `yaml:"ordered,omitempty"`
 
 	templates *embed.FS
 }
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+// PublicKey is an Ed25519 public key that verifies the tokens issued by a
+// host.
+type PublicKey struct {
+	// ID is the ID of the key, which is recorded in the tokens it signs.
+	ID string `json:"id"`
+	// PublicKey is the public key, encoded in base64.
+	PublicKey []byte `json:"public_key"`
+}
+
+// PublicKeysResponse is the response to a request for the public keys of a
+// host.
+type PublicKeysResponse struct {
+	Keys []PublicKey `json:"keys"`
+}
+
+type PublicKeysHandler struct {
+	ctx context.Context
+}
+
+// NewPublicKeysHandler returns a handler that publishes the Ed25519 public
+// keys of the keyring, so that other services can trust the tokens issued by
+// the host.
+func NewPublicKeysHandler(ctx context.Context) http.Handler {
+	return &PublicKeysHandler{
+		ctx: ctx,
+	}
+}
+
+func (h *PublicKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth, err := authentication.GetService(h.ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	resp := PublicKeysResponse{Keys: []PublicKey{}}
+	for _, key := range auth.Keyring().PublicKeys(time.Now()) {
+		resp.Keys = append(resp.Keys, PublicKey{ID: key.ID, PublicKey: key.PublicKey})
+	}
+
+	respData, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respData)
+}
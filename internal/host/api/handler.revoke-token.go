@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
+)
+
+// maxRevokeTokenRequestSize is the maximum size in bytes of the body of a
+// request to revoke a token.
+const maxRevokeTokenRequestSize = 64 << 10
+
+// RevokeTokenRequest is the body of a request to revoke a token. Either the
+// token itself or its ID must be provided.
+type RevokeTokenRequest struct {
+	// Token is the token to revoke.
+	Token string `json:"token,omitempty"`
+	// ID is the ID of the token to revoke.
+	ID string `json:"id,omitempty"`
+	// Reason describes why the token is revoked.
+	Reason string `json:"reason,omitempty"`
+}
+
+// RevokeTokenResponse is the response to a request to revoke a token.
+type RevokeTokenResponse struct {
+	// ID is the ID of the token that was revoked.
+	ID string `json:"id"`
+}
+
+type RevokeTokenHandler struct {
+	ctx               context.Context
+	allowBearerTokens bool
+}
+
+// NewRevokeTokenHandler returns a handler that revokes the token in the body
+// of POST requests. The connections that authenticated with the token are
+// closed. Requests must be authenticated with a bearer token that has the
+// RevokeTokens permission, which is only accepted if allowBearerTokens is
+// true, since the api is served over plain HTTP.
+func NewRevokeTokenHandler(ctx context.Context, allowBearerTokens bool) http.Handler {
+	return &RevokeTokenHandler{
+		ctx:               ctx,
+		allowBearerTokens: allowBearerTokens,
+	}
+}
+
+func (h *RevokeTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth, err := authentication.GetService(h.ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Revoking a token closes the connections that use it, so only callers
+	// that are permitted to do so can revoke tokens.
+	if status, err := h.authorizeRevocation(auth, r); err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var req RevokeTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRevokeTokenRequestSize)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	id := req.ID
+	var expiry time.Time
+	if req.Token != "" {
+		// Only tokens signed by this host can be revoked by value, so that
+		// the expiry of a revocation can't be forged.
+		token, err := auth.VerifyToken(req.Token)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		id = token.RevocationID()
+		expiry = token.Expiry
+	}
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("token or id is required"))
+		return
+	}
+
+	if err := auth.Revoke(h.ctx, id, req.Reason, expiry); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, authentication.ErrRevocationUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	respData, err := json.Marshal(RevokeTokenResponse{ID: id})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respData)
+}
+
+// authorizeRevocation authenticates the bearer token of a request to revoke
+// a token, and checks that it has the RevokeTokens permission. Returns the
+// status to respond with if it does not.
+func (h *RevokeTokenHandler) authorizeRevocation(auth *authentication.Service, r *http.Request) (int, error) {
+	if !h.allowBearerTokens {
+		return http.StatusForbidden, errors.New("bearer tokens are not accepted, set allow_bearer_tokens to revoke tokens through the api")
+	}
+
+	tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tokenStr == "" {
+		return http.StatusUnauthorized, errors.New("a bearer token is required")
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	result, err := auth.Authenticate(h.ctx, tokenStr, net.ParseIP(host))
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	// The secret of a holder key would be sent in cleartext.
+	if len(result.Token.HolderKey) != 0 {
+		return http.StatusUnauthorized, errors.New("tokens with a holder key can't authenticate to the api")
+	}
+
+	if !permission.GetPermissions(result.Token.Permitted).Has(permission.RevokeTokens) {
+		return http.StatusForbidden, errors.New("token does not permit revoking tokens")
+	}
+
+	return http.StatusOK, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,6 +21,98 @@ func (h Address) String() string {
 type ServiceConfig struct {
 	LogPackets bool    `yaml:"log_packets"`
 	Address    Address `yaml:"address"`
+	// CertificateHosts are additional host names and IP addresses that the
+	// generated self-signed certificate is issued for, such as the public
+	// name of the host when it is behind NAT.
+	CertificateHosts []string `yaml:"certificate_hosts,omitempty"`
+	// Codecs are the wire codecs accepted by the service in order of
+	// preference. Supported codecs are gob, msgpack and json.
+	Codecs []string `yaml:"codecs,omitempty"`
+	// IdleTimeout is the amount of time a connection can go without receiving
+	// a packet before it is closed. A negative value disables the timeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+	// KeepaliveInterval is the interval at which heartbeats are sent to
+	// connected peers. A negative value disables heartbeats.
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval,omitempty"`
+	// Compression are the compression algorithms accepted by the service in
+	// order of preference. Supported algorithms are zstd and gzip. Connections
+	// are not compressed unless at least one is configured.
+	Compression []string `yaml:"compression,omitempty"`
+	// CompressionThreshold is the size in bytes that a packet must reach
+	// before it is compressed. Defaults to 1024.
+	CompressionThreshold int `yaml:"compression_threshold,omitempty"`
+	// MaxFrameSize is the maximum size in bytes of a frame accepted from a
+	// connected peer. Larger packets are split across multiple frames.
+	// Defaults to 1 MiB.
+	MaxFrameSize int `yaml:"max_frame_size,omitempty"`
+	// MaxPacketSize is the maximum size in bytes of a packet accepted from a
+	// connected peer. Defaults to 64 MiB.
+	MaxPacketSize int `yaml:"max_packet_size,omitempty"`
+	// RateLimit limits the rate at which packets are accepted from connected
+	// peers. Packets are not rate limited unless a limit is configured.
+	RateLimit RateLimitConfig `yaml:"rate_limit,omitempty"`
+	// MaxDecodeFailures is the number of consecutive packets from a connected
+	// peer that can fail to decode before the connection is closed. Defaults
+	// to 10. A negative value disables the limit.
+	MaxDecodeFailures int `yaml:"max_decode_failures,omitempty"`
+	// Workers limits the number of packets from connected peers that are
+	// handled at once.
+	Workers WorkersConfig `yaml:"workers,omitempty"`
+	// ShutdownGracePeriod is the amount of time to wait for the requests
+	// that are being handled when the service is stopped. Defaults to 30s.
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period,omitempty"`
+	// RevocationCheckInterval is the interval at which the service checks
+	// for tokens revoked by other processes, such as coattail token revoke,
+	// and closes the connections that authenticated with them. Defaults to
+	// 10s. A negative value only checks for tokens revoked by the service.
+	RevocationCheckInterval time.Duration `yaml:"revocation_check_interval,omitempty"`
+	// TokenSigning is the type of the key that the keyring is created with
+	// when it does not exist, hmac or ed25519. Defaults to hmac.
+	TokenSigning string `yaml:"token_signing,omitempty"`
+	// AllowBearerTokens accepts the tokens of clients that send their token
+	// instead of a proof that they hold it, which clients older than the
+	// challenge authentication do. Defaults to false.
+	AllowBearerTokens bool `yaml:"allow_bearer_tokens,omitempty"`
+}
+
+// WorkersConfig configures the workers that handle packets received from
+// connected peers. Packets that can't be queued in time are rejected.
+type WorkersConfig struct {
+	// Connection is the number of packets from each connection that can be
+	// handled at once. Defaults to 32. A negative value removes the limit.
+	Connection int `yaml:"connection,omitempty"`
+	// ConnectionQueueSize is the number of packets from each connection that
+	// can wait to be handled. Defaults to 128.
+	ConnectionQueueSize int `yaml:"connection_queue_size,omitempty"`
+	// Host is the number of packets from every connection that can be
+	// handled at once. Defaults to 256. A negative value removes the limit.
+	Host int `yaml:"host,omitempty"`
+	// HostQueueSize is the number of packets from every connection that can
+	// wait to be handled. Defaults to 1024.
+	HostQueueSize int `yaml:"host_queue_size,omitempty"`
+	// QueueTimeout is the amount of time to wait for room in a queue before
+	// a packet is rejected. Defaults to 1s.
+	QueueTimeout time.Duration `yaml:"queue_timeout,omitempty"`
+}
+
+// RateLimitConfig configures the rate limits applied to packets received
+// from connected peers.
+type RateLimitConfig struct {
+	// Connection is the limit applied to each connection.
+	Connection RateLimit `yaml:"connection,omitempty"`
+	// Token is the limit shared by every connection authenticated with the
+	// same token.
+	Token RateLimit `yaml:"token,omitempty"`
+}
+
+// RateLimit is a token bucket rate limit.
+type RateLimit struct {
+	// Rate is the number of packets accepted per second. A rate of zero
+	// disables the limit.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of packets that can be accepted at once before the
+	// rate applies. Defaults to the rate.
+	Burst int `yaml:"burst,omitempty"`
 }
 
 type ApiConfig struct {
@@ -36,6 +129,123 @@ type HostConfig struct {
 	ServiceConfig ServiceConfig `yaml:"service"`
 	ApiConfig     ApiConfig     `yaml:"api"`
 	WebConfig     WebConfig     `yaml:"web"`
+	// DataDir is the directory that the files of the host are kept in. A
+	// relative path is relative to the directory of the configuration file.
+	// Defaults to the directory of the configuration file.
+	DataDir string `yaml:"data_dir,omitempty"`
+	// Files overrides the locations of the files of the host.
+	Files FilesConfig `yaml:"files,omitempty"`
+	// Reload configures how the configuration is reloaded while the host is
+	// running.
+	Reload ReloadConfig `yaml:"reload,omitempty"`
+
+	// dir is the directory of the file that the configuration was read from.
+	dir string
+}
+
+// FilesConfig configures the locations of the files of a host. Relative
+// paths are relative to the data directory.
+type FilesConfig struct {
+	// Database is the path of the database. Defaults to data.db.
+	Database string `yaml:"database,omitempty"`
+	// Keyring is the path of the keyring that holds the keys used to sign
+	// and verify tokens, which is created if it does not exist. Defaults to
+	// keyring.yaml.
+	Keyring string `yaml:"keyring,omitempty"`
+	// SecretKey is the path of a key used to sign tokens by earlier
+	// versions. It is imported into the keyring when the keyring is created,
+	// so that the tokens signed with it remain valid. Defaults to
+	// secret.key.
+	SecretKey string `yaml:"secret_key,omitempty"`
+	// Certificate is the path of the TLS certificate of the service. A
+	// self-signed certificate is generated if it or its private key does not
+	// exist. Defaults to server.crt.
+	Certificate string `yaml:"certificate,omitempty"`
+	// CertificateKey is the path of the private key of the TLS certificate.
+	// Defaults to server.key.
+	CertificateKey string `yaml:"certificate_key,omitempty"`
+	// Peers is the path of the file listing the remote peers. Defaults to
+	// peers.yaml.
+	Peers string `yaml:"peers,omitempty"`
+}
+
+// ReloadConfig configures how the configuration of a running host is
+// reloaded. The configuration is always reloaded when the process receives
+// SIGHUP.
+type ReloadConfig struct {
+	// Watch reloads the configuration when host-config.yaml, the peers
+	// file, the keyring or the TLS certificate of the service change.
+	Watch bool `yaml:"watch,omitempty"`
+	// Interval is the interval at which watched files are checked for
+	// changes. Defaults to 2s.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+const (
+	// DefaultHostConfigFile is the default path of the host configuration.
+	DefaultHostConfigFile = "host-config.yaml"
+	// DefaultDatabaseFile is the default path of the database.
+	DefaultDatabaseFile = "data.db"
+	// DefaultKeyringFile is the default path of the keyring.
+	DefaultKeyringFile = "keyring.yaml"
+	// DefaultSecretKeyFile is the default path of the key used to sign
+	// tokens by earlier versions.
+	DefaultSecretKeyFile = "secret.key"
+	// DefaultCertificateFile is the default path of the TLS certificate of
+	// the service.
+	DefaultCertificateFile = "server.crt"
+	// DefaultCertificateKeyFile is the default path of the private key of the
+	// TLS certificate of the service.
+	DefaultCertificateKeyFile = "server.key"
+	// DefaultPeersFile is the default path of the remote peers.
+	DefaultPeersFile = "peers.yaml"
+)
+
+// ResolveFiles returns the locations of the files of the host, with the
+// defaults applied and relative paths resolved against the data directory.
+func (c *HostConfig) ResolveFiles() FilesConfig {
+	files := c.Files
+	if files.Database == "" {
+		files.Database = DefaultDatabaseFile
+	}
+	if files.Keyring == "" {
+		files.Keyring = DefaultKeyringFile
+	}
+	if files.SecretKey == "" {
+		files.SecretKey = DefaultSecretKeyFile
+	}
+	if files.Certificate == "" {
+		files.Certificate = DefaultCertificateFile
+	}
+	if files.CertificateKey == "" {
+		files.CertificateKey = DefaultCertificateKeyFile
+	}
+	if files.Peers == "" {
+		files.Peers = DefaultPeersFile
+	}
+
+	return FilesConfig{
+		Database:       c.dataPath(files.Database),
+		Keyring:        c.dataPath(files.Keyring),
+		SecretKey:      c.dataPath(files.SecretKey),
+		Certificate:    c.dataPath(files.Certificate),
+		CertificateKey: c.dataPath(files.CertificateKey),
+		Peers:          c.dataPath(files.Peers),
+	}
+}
+
+// dataPath resolves path against the data directory.
+func (c *HostConfig) dataPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	dataDir := c.DataDir
+	if !filepath.IsAbs(dataDir) {
+		dataDir = filepath.Join(c.dir, dataDir)
+	}
+
+	return filepath.Join(dataDir, path)
 }
 
 func GetHostConfig() (*HostConfig, error) {
@@ -43,16 +253,40 @@ func GetHostConfig() (*HostConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	hostConfigFile, err := os.ReadFile(filepath.Join(cwd, "host-config.yaml"))
+
+	return LoadHostConfig(filepath.Join(cwd, DefaultHostConfigFile))
+}
+
+// LoadHostConfig reads the host configuration from the provided path.
+// Environment variables in values, written ${VAR} or ${VAR:-default}, are
+// replaced with their values. Write $${ for a literal ${.
+func LoadHostConfig(path string) (*HostConfig, error) {
+	hostConfigFile, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := HostConfig{}
-	err = yaml.Unmarshal(hostConfigFile, &cfg)
+	var node yaml.Node
+	err = yaml.Unmarshal(hostConfigFile, &node)
 	if err != nil {
 		return nil, err
 	}
 
+	err = interpolate(&node, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cfg := HostConfig{
+		dir: filepath.Dir(path),
+	}
+	// An empty file has no document to decode.
+	if len(node.Content) > 0 {
+		err = node.Decode(&cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,207 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+)
+
+func TestResolveFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretKey := filepath.Join(t.TempDir(), "secrets", "signing.key")
+
+	path := filepath.Join(dir, "host-config.yaml")
+	err := os.WriteFile(path, []byte(`
+data_dir: state
+files:
+  database: db/coattail.db
+  secret_key: `+secretKey+`
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadHostConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := cfg.ResolveFiles()
+	expected := config.FilesConfig{
+		Database:       filepath.Join(dir, "state", "db", "coattail.db"),
+		Keyring:        filepath.Join(dir, "state", config.DefaultKeyringFile),
+		SecretKey:      secretKey,
+		Certificate:    filepath.Join(dir, "state", config.DefaultCertificateFile),
+		CertificateKey: filepath.Join(dir, "state", config.DefaultCertificateKeyFile),
+		Peers:          filepath.Join(dir, "state", config.DefaultPeersFile),
+	}
+	if files != expected {
+		t.Errorf("expected %+v, got %+v", expected, files)
+	}
+
+	// Files default to the directory of the configuration file.
+	cfg.DataDir = ""
+	if files := cfg.ResolveFiles(); files.Peers != filepath.Join(dir, config.DefaultPeersFile) {
+		t.Errorf("expected peers at %s, got %s", filepath.Join(dir, config.DefaultPeersFile), files.Peers)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("COATTAIL_TEST_HOST", "127.0.0.1")
+	t.Setenv("COATTAIL_TEST_PORT", "5244")
+
+	path := filepath.Join(t.TempDir(), "host-config.yaml")
+	err := os.WriteFile(path, []byte(`
+service:
+  address:
+    host: ${COATTAIL_TEST_HOST}
+    port: ${COATTAIL_TEST_PORT}
+  idle_timeout: ${COATTAIL_TEST_UNSET:-1m}
+data_dir: "$${COATTAIL_TEST_HOST}"
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadHostConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ServiceConfig.Address.String() != "127.0.0.1:5244" {
+		t.Errorf("expected address 127.0.0.1:5244, got %s", cfg.ServiceConfig.Address)
+	}
+	if cfg.ServiceConfig.IdleTimeout != time.Minute {
+		t.Errorf("expected idle timeout 1m, got %s", cfg.ServiceConfig.IdleTimeout)
+	}
+	if cfg.DataDir != "${COATTAIL_TEST_HOST}" {
+		t.Errorf("expected escaped data dir, got %s", cfg.DataDir)
+	}
+
+	// Every variable that is not set is reported.
+	err = os.WriteFile(path, []byte(`
+service:
+  address:
+    host: ${COATTAIL_TEST_UNSET_HOST}
+    port: ${COATTAIL_TEST_UNSET_PORT}
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = config.LoadHostConfig(path)
+	if err == nil {
+		t.Fatal("expected an error for unset variables")
+	}
+	for _, expected := range []string{"line 4: COATTAIL_TEST_UNSET_HOST", "line 5: COATTAIL_TEST_UNSET_PORT"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"COATTAIL_SERVICE_ADDRESS_PORT":          "5244",
+		"COATTAIL_SERVICE_CODECS":                "msgpack, gob",
+		"COATTAIL_SERVICE_RATE_LIMIT_TOKEN_RATE": "2.5",
+		"COATTAIL_SERVICE_WORKERS_QUEUE_TIMEOUT": "250ms",
+		"COATTAIL_API_ENABLED":                   "true",
+		"COATTAIL_SERVICE_MAX_FRAME_SIZE":        "large",
+		"COATTAIL_SERVICE_SHUTDOWN_GRACE_PERIOD": "soon",
+		"COATTAIL_FILES_PEERS":                   "/etc/coattail/peers.yaml",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	var cfg config.HostConfig
+	err := cfg.ApplyEnv(lookup)
+
+	// Both invalid values are reported with the path of their field.
+	var fieldErr *config.FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Fatalf("expected a field error, got %v", err)
+	}
+	for _, expected := range []string{"service.max_frame_size", "service.shutdown_grace_period"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+
+	if cfg.ServiceConfig.Address.Port != 5244 {
+		t.Errorf("expected port 5244, got %d", cfg.ServiceConfig.Address.Port)
+	}
+	if strings.Join(cfg.ServiceConfig.Codecs, ",") != "msgpack,gob" {
+		t.Errorf("expected codecs msgpack,gob, got %v", cfg.ServiceConfig.Codecs)
+	}
+	if cfg.ServiceConfig.RateLimit.Token.Rate != 2.5 {
+		t.Errorf("expected token rate 2.5, got %v", cfg.ServiceConfig.RateLimit.Token.Rate)
+	}
+	if cfg.ServiceConfig.Workers.QueueTimeout != 250*time.Millisecond {
+		t.Errorf("expected queue timeout 250ms, got %s", cfg.ServiceConfig.Workers.QueueTimeout)
+	}
+	if !cfg.ApiConfig.Enabled {
+		t.Error("expected api to be enabled")
+	}
+	if cfg.Files.Peers != "/etc/coattail/peers.yaml" {
+		t.Errorf("expected peers file /etc/coattail/peers.yaml, got %s", cfg.Files.Peers)
+	}
+
+	if err := cfg.Set("service.address", "localhost"); !errors.Is(err, config.ErrUnknownField) {
+		t.Errorf("expected %v, got %v", config.ErrUnknownField, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.HostConfig{
+		ServiceConfig: config.ServiceConfig{
+			Address:       config.Address{Host: "0.0.0.0", Port: 5243},
+			MaxFrameSize:  2048,
+			MaxPacketSize: 1024,
+			Codecs:        []string{"gob", "gob"},
+			TokenSigning:  "rsa",
+			Workers: config.WorkersConfig{
+				QueueTimeout: -time.Second,
+			},
+		},
+		ApiConfig: config.ApiConfig{
+			Enabled: true,
+			Address: config.Address{Host: "localhost", Port: 5243},
+		},
+		WebConfig: config.WebConfig{
+			Address: config.Address{Port: 70000},
+		},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+
+	for _, expected := range []string{
+		"service.max_frame_size: must not be larger than service.max_packet_size",
+		"service.codecs: gob is listed more than once",
+		"service.workers.queue_timeout: must not be negative",
+		`service.token_signing: unknown value "rsa"`,
+		"api.address.port: port is already used by service.address.port",
+		"web.address.port: must be between 0 and 65535",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+	if !errors.Is(err, config.ErrPortInUse) {
+		t.Errorf("expected error to wrap %v", config.ErrPortInUse)
+	}
+
+	if err := (&config.HostConfig{}).Validate(); err != nil {
+		t.Errorf("expected the default configuration to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// variablePattern matches ${VAR} and ${VAR:-default}. $${ is an escaped ${.
+var variablePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate replaces the variables in the values of node and its children
+// with their values looked up with lookup. Every variable that is not set
+// and has no default is reported.
+func interpolate(node *yaml.Node, lookup func(string) (string, bool)) error {
+	var errs []error
+
+	if node.Kind == yaml.ScalarNode {
+		value := variablePattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+
+			groups := variablePattern.FindStringSubmatch(match)
+			if value, ok := lookup(groups[1]); ok {
+				return value
+			}
+			if strings.Contains(match, ":-") {
+				return groups[2]
+			}
+
+			errs = append(errs, fmt.Errorf("line %d: %s is not set", node.Line, groups[1]))
+			return match
+		})
+
+		if value != node.Value {
+			node.Value = value
+			// Resolve the type of plain values again so that variables can
+			// be used for numbers and booleans.
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		if err := interpolate(child, lookup); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
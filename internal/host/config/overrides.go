@@ -0,0 +1,245 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the
+// fields of the host configuration.
+const EnvPrefix = "COATTAIL_"
+
+var (
+	ErrUnknownField = errors.New("unknown field")
+)
+
+// Field is a field of the host configuration that can be overridden by an
+// environment variable or a command line flag.
+type Field struct {
+	// Path is the path of the field in host-config.yaml, such as
+	// service.address.port.
+	Path string
+	// Env is the environment variable that overrides the field, such as
+	// COATTAIL_SERVICE_ADDRESS_PORT.
+	Env string
+	// Type is the type of the values accepted by the field.
+	Type string
+}
+
+// Fields returns every field of the host configuration that can be
+// overridden, in the order they are declared.
+func Fields() []Field {
+	var fields []Field
+	walkFields(reflect.TypeOf(HostConfig{}), "", func(path string, t reflect.Type) {
+		fields = append(fields, Field{
+			Path: path,
+			Env:  EnvName(path),
+			Type: typeName(t),
+		})
+	})
+
+	return fields
+}
+
+// EnvName returns the environment variable that overrides the field at
+// path.
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// Set parses value and sets the field at path to it. Lists are separated by
+// commas.
+func (c *HostConfig) Set(path, value string) error {
+	field, ok := lookupField(reflect.ValueOf(c).Elem(), path)
+	if !ok {
+		return &FieldError{Path: path, Err: ErrUnknownField}
+	}
+
+	if err := setField(field, value); err != nil {
+		return &FieldError{Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// ApplyEnv overrides the fields of the configuration that have an
+// environment variable set, looking the variables up with lookup. Every
+// value that can't be parsed is reported.
+func (c *HostConfig) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for _, field := range Fields() {
+		value, ok := lookup(field.Env)
+		if !ok {
+			continue
+		}
+
+		if err := c.Set(field.Path, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.Env, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Diff returns the paths of the fields that differ between a and b, in the
+// order they are declared.
+func Diff(a, b *HostConfig) []string {
+	var paths []string
+	for _, field := range Fields() {
+		x, _ := lookupField(reflect.ValueOf(a).Elem(), field.Path)
+		y, _ := lookupField(reflect.ValueOf(b).Elem(), field.Path)
+		// Empty lists are equal whether or not they are nil.
+		if x.Kind() == reflect.Slice && x.Len() == 0 && y.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(x.Interface(), y.Interface()) {
+			paths = append(paths, field.Path)
+		}
+	}
+
+	return paths
+}
+
+// walkFields calls fn with the path and type of every field that can be
+// overridden in the struct t.
+func walkFields(t reflect.Type, prefix string, fn func(path string, t reflect.Type)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			walkFields(field.Type, path, fn)
+			continue
+		}
+
+		if typeName(field.Type) != "" {
+			fn(path, field.Type)
+		}
+	}
+}
+
+// lookupField returns the field at path in the struct v.
+func lookupField(v reflect.Value, path string) (reflect.Value, bool) {
+	name, rest, nested := strings.Cut(path, ".")
+	for i := 0; i < v.NumField(); i++ {
+		if yamlName(v.Type().Field(i)) != name {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if !nested {
+				return reflect.Value{}, false
+			}
+			return lookupField(field, rest)
+		}
+		if nested || typeName(field.Type()) == "" {
+			return reflect.Value{}, false
+		}
+
+		return field, true
+	}
+
+	return reflect.Value{}, false
+}
+
+// yamlName returns the name of a field in host-config.yaml, or an empty
+// string if it is not read from it.
+func yamlName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+
+	return name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// typeName returns the name of the values accepted by a field of type t, or
+// an empty string if it can't be overridden.
+func typeName(t reflect.Type) string {
+	if t == durationType {
+		return "duration"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int64:
+		return "int"
+	case reflect.Float64:
+		return "float"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "list"
+		}
+	}
+
+	return ""
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid int %q", value)
+		}
+		field.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid float %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	}
+
+	return nil
+}
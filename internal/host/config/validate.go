@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidPort = errors.New("must be between 0 and 65535")
+	ErrNegative    = errors.New("must not be negative")
+	ErrPortInUse   = errors.New("port is already used")
+	ErrUnknown     = errors.New("unknown value")
+)
+
+// FieldError is a problem with the field of the host configuration at
+// Path.
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the configuration and returns every problem found, each
+// as a FieldError.
+func (c *HostConfig) Validate() error {
+	var errs []error
+	check := func(path string, err error) {
+		if err != nil {
+			errs = append(errs, &FieldError{Path: path, Err: err})
+		}
+	}
+
+	service := c.ServiceConfig
+	check("service.address.port", validatePort(service.Address.Port))
+	check("service.compression_threshold", notNegative(service.CompressionThreshold))
+	check("service.max_frame_size", notNegative(service.MaxFrameSize))
+	check("service.max_packet_size", notNegative(service.MaxPacketSize))
+	if service.MaxFrameSize > 0 && service.MaxPacketSize > 0 && service.MaxFrameSize > service.MaxPacketSize {
+		check("service.max_frame_size", fmt.Errorf("must not be larger than service.max_packet_size (%d)", service.MaxPacketSize))
+	}
+	check("service.shutdown_grace_period", notNegative(service.ShutdownGracePeriod))
+	check("service.rate_limit.connection.rate", notNegative(service.RateLimit.Connection.Rate))
+	check("service.rate_limit.connection.burst", notNegative(service.RateLimit.Connection.Burst))
+	check("service.rate_limit.token.rate", notNegative(service.RateLimit.Token.Rate))
+	check("service.rate_limit.token.burst", notNegative(service.RateLimit.Token.Burst))
+	check("service.workers.connection_queue_size", notNegative(service.Workers.ConnectionQueueSize))
+	check("service.workers.host_queue_size", notNegative(service.Workers.HostQueueSize))
+	check("service.workers.queue_timeout", notNegative(service.Workers.QueueTimeout))
+	check("service.codecs", noDuplicates(service.Codecs))
+	check("service.compression", noDuplicates(service.Compression))
+	check("service.token_signing", oneOf(service.TokenSigning, "", "hmac", "ed25519"))
+
+	check("reload.interval", notNegative(c.Reload.Interval))
+
+	check("api.address.port", validatePort(c.ApiConfig.Address.Port))
+	check("web.address.port", validatePort(c.WebConfig.Address.Port))
+
+	// The servers that are enabled can't listen on the same port.
+	if c.ApiConfig.Enabled && sharesPort(c.ApiConfig.Address, service.Address) {
+		check("api.address.port", fmt.Errorf("%w by service.address.port", ErrPortInUse))
+	}
+	if c.WebConfig.Enabled {
+		if sharesPort(c.WebConfig.Address, service.Address) {
+			check("web.address.port", fmt.Errorf("%w by service.address.port", ErrPortInUse))
+		}
+		if c.ApiConfig.Enabled && sharesPort(c.WebConfig.Address, c.ApiConfig.Address) {
+			check("web.address.port", fmt.Errorf("%w by api.address.port", ErrPortInUse))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePort(port int) error {
+	if port < 0 || port > 65535 {
+		return ErrInvalidPort
+	}
+
+	return nil
+}
+
+func notNegative[T int | float64 | ~int64](value T) error {
+	if value < 0 {
+		return ErrNegative
+	}
+
+	return nil
+}
+
+func oneOf(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w %q", ErrUnknown, value)
+}
+
+func noDuplicates(values []string) error {
+	seen := map[string]bool{}
+	for _, value := range values {
+		if seen[value] {
+			return fmt.Errorf("%s is listed more than once", value)
+		}
+		seen[value] = true
+	}
+
+	return nil
+}
+
+// sharesPort returns true if a and b listen on the same port. A port of 0 is
+// chosen by the system and never conflicts.
+func sharesPort(a, b Address) bool {
+	if a.Port == 0 || a.Port != b.Port {
+		return false
+	}
+
+	return a.Host == b.Host || isWildcard(a.Host) || isWildcard(b.Host)
+}
+
+func isWildcard(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
@@ -11,11 +11,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync/atomic"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/host/api"
 	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
 	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
 	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
@@ -30,21 +32,36 @@ var web embed.FS
 type Host struct {
 	Config    *config.HostConfig `yaml:"host"`
 	LocalPeer *coattailtypes.Peer
-}
 
-func ContextWithHost(ctx context.Context) (context.Context, error) {
-	host := ctx.Value(keys.HostKey)
+	// CertFile and KeyFile are the paths of the TLS certificate used by the
+	// service and its private key. A self-signed certificate is generated if
+	// either is missing.
+	CertFile string
+	KeyFile  string
+
+	listener net.Listener
+	cert     atomic.Pointer[tls.Certificate]
+	servers  []*http.Server
+	addr     net.Addr
+	apiAddr  net.Addr
+	webAddr  net.Addr
+}
 
-	if host == nil {
-		host, err := newHost()
-		if err != nil {
-			return nil, err
-		}
+// NewHost creates a Host with the provided configuration that uses the
+// certificate configured in it.
+func NewHost(cfg *config.HostConfig) *Host {
+	files := cfg.ResolveFiles()
 
-		return context.WithValue(ctx, keys.HostKey, host), nil
+	return &Host{
+		Config:   cfg,
+		CertFile: files.Certificate,
+		KeyFile:  files.CertificateKey,
 	}
+}
 
-	return ctx, nil
+// ContextWithHost returns a context with the provided host.
+func ContextWithHost(ctx context.Context, host *Host) context.Context {
+	return context.WithValue(ctx, keys.HostKey, host)
 }
 
 func GetHost(ctx context.Context) (*Host, error) {
@@ -60,17 +77,6 @@ func GetHost(ctx context.Context) (*Host, error) {
 	return nil, ErrInvalidHost
 }
 
-func newHost() (*Host, error) {
-	hostConfig, err := config.GetHostConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	return &Host{
-		Config: hostConfig,
-	}, nil
-}
-
 type ConnectionHandler func(context.Context, net.Conn, bool)
 
 func (h *Host) Start(ctx context.Context, connHandler ConnectionHandler) error {
@@ -87,9 +93,72 @@ func (h *Host) Start(ctx context.Context, connHandler ConnectionHandler) error {
 	return err
 }
 
+// Stop stops accepting connections and shuts down the api and web servers.
+// Connections that have already been accepted are left open.
+func (h *Host) Stop(ctx context.Context) error {
+	var errs []error
+
+	if h.listener != nil {
+		if err := h.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, fmt.Errorf("failed to close listener: %w", err))
+		}
+	}
+
+	for _, server := range h.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down server at %s: %w", server.Addr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Addr returns the address that the service is listening on, or nil if the
+// host has not been started.
+func (h *Host) Addr() net.Addr {
+	return h.addr
+}
+
+// ApiAddr returns the address that the api server is listening on, or nil if
+// the api server is disabled or the host has not been started.
+func (h *Host) ApiAddr() net.Addr {
+	return h.apiAddr
+}
+
+// WebAddr returns the address that the web server is listening on, or nil if
+// the web server is disabled or the host has not been started.
+func (h *Host) WebAddr() net.Addr {
+	return h.webAddr
+}
+
+// ReloadCertificate loads the TLS certificate of the service and its private
+// key from certFile and keyFile. Connections accepted afterwards use the new
+// certificate. The current certificate is kept if they can't be loaded.
+func (h *Host) ReloadCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate and key: %w", err)
+	}
+
+	h.cert.Store(&cert)
+	return nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the TLS certificate of the
+// service, which remote peers pin to trust it. It returns an empty string
+// if no certificate has been loaded.
+func (h *Host) Fingerprint() string {
+	cert := h.cert.Load()
+	if cert == nil || len(cert.Certificate) == 0 {
+		return ""
+	}
+
+	return fingerprint.Of(cert.Certificate[0])
+}
+
 func (h *Host) startListener(ctx context.Context, handleConnection ConnectionHandler) error {
-	certFile := "server.crt"
-	keyFile := "server.key"
+	certFile := h.CertFile
+	keyFile := h.KeyFile
 
 	_, certFileErr := os.Stat(certFile)
 	_, keyFileErr := os.Stat(keyFile)
@@ -120,19 +189,22 @@ func (h *Host) startListener(ctx context.Context, handleConnection ConnectionHan
 
 		// generate new cert and key
 
-		err := h.createSelfSignedCertificate(ctx, h.Config.ServiceConfig.Address.Host)
+		err := h.createSelfSignedCertificate(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to generate self-signed certificate: %w", err)
 		}
 	}
 
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return fmt.Errorf("failed to load certificate and key: %w", err)
+	if err := h.ReloadCertificate(certFile, keyFile); err != nil {
+		return err
 	}
 
+	// The certificate is looked up for each connection so that it can be
+	// replaced while the host is running.
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return h.cert.Load(), nil
+		},
 	}
 
 	listener, err := net.Listen("tcp", h.Config.ServiceConfig.Address.String())
@@ -141,12 +213,18 @@ func (h *Host) startListener(ctx context.Context, handleConnection ConnectionHan
 	}
 
 	tlsListener := tls.NewListener(listener, tlsConfig)
+	h.listener = tlsListener
+	h.addr = listener.Addr()
 	go func() {
 		defer listener.Close()
 
 		for {
 			conn, err := tlsListener.Accept()
 			if err != nil {
+				// The listener is closed when the host is stopped.
+				if errors.Is(err, net.ErrClosed) {
+					break
+				}
 				if logger, _ := logging.GetLogger(ctx); logger != nil {
 					logger.Println(fmt.Errorf("failed to accept connection: %v", err))
 				}
@@ -159,7 +237,7 @@ func (h *Host) startListener(ctx context.Context, handleConnection ConnectionHan
 	}()
 
 	if logger, _ := logging.GetLogger(ctx); logger != nil {
-		logger.Printf("running service at %s\n", h.Config.ServiceConfig.Address.String())
+		logger.Printf("running service at %s\n", h.addr)
 	}
 
 	return nil
@@ -177,17 +255,29 @@ func (h *Host) startWebServer(ctx context.Context) error {
 		panic(err)
 	}
 
+	listener, err := net.Listen("tcp", h.Config.WebConfig.Address.String())
+	if err != nil {
+		return fmt.Errorf("failed to start web server: %w", err)
+	}
+	h.webAddr = listener.Addr()
+
+	webMux := http.NewServeMux()
+	webServer := &http.Server{
+		Addr:    h.webAddr.String(),
+		Handler: webMux,
+	}
+	h.servers = append(h.servers, webServer)
+
 	go func() {
-		webMux := http.NewServeMux()
 		fs := http.FileServer(http.FS(wfbFs))
 		webMux.Handle("/", fs)
 
 		if logger, err := logging.GetLogger(ctx); err == nil {
-			logger.Printf("running web server at %v\n", h.Config.WebConfig.Address.String())
+			logger.Printf("running web server at %v\n", h.webAddr)
 		}
 
-		err = http.ListenAndServe(h.Config.WebConfig.Address.String(), webMux)
-		if err != nil {
+		err := webServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
 			if logger, err := logging.GetLogger(ctx); err == nil {
 				logger.Print(fmt.Errorf("listen and serve error: %w", err))
 			}
@@ -211,24 +301,37 @@ func (h *Host) startApiServer(ctx context.Context) error {
 		return nil
 	}
 
+	listener, err := net.Listen("tcp", h.Config.ApiConfig.Address.String())
+	if err != nil {
+		return fmt.Errorf("failed to start api server: %w", err)
+	}
+	h.apiAddr = listener.Addr()
+
+	apiMux := http.NewServeMux()
+	apiServer := &http.Server{
+		Addr:    h.apiAddr.String(),
+		Handler: apiMux,
+	}
+	h.servers = append(h.servers, apiServer)
+
 	go func() {
 		if logger, err := logging.GetLogger(ctx); err == nil {
 			apiLogger := log.New(os.Stdout, logger.Prefix()+"[API] ", log.LstdFlags)
 			ctx = context.WithValue(ctx, keys.LoggerKey, apiLogger)
 		}
 
-		apiMux := http.NewServeMux()
-
 		apiMux.Handle("/healthcheck", loggingMiddleware(ctx, api.NewHealthCheckHandler(ctx, h.LocalPeer)))
 		apiMux.Handle("/peers", loggingMiddleware(ctx, api.NewPeersHandler(ctx, h.LocalPeer)))
 		apiMux.Handle("/actions", loggingMiddleware(ctx, api.NewActionsHandler(ctx, h.LocalPeer)))
+		apiMux.Handle("/tokens/revoke", loggingMiddleware(ctx, api.NewRevokeTokenHandler(ctx, h.Config.ServiceConfig.AllowBearerTokens)))
+		apiMux.Handle("/keys", loggingMiddleware(ctx, api.NewPublicKeysHandler(ctx)))
 
 		if logger, err := logging.GetLogger(ctx); err == nil {
-			logger.Printf("running api server at %v\n", h.Config.ApiConfig.Address.String())
+			logger.Printf("running api server at %v\n", h.apiAddr)
 		}
 
-		err := http.ListenAndServe(h.Config.ApiConfig.Address.String(), apiMux)
-		if err != nil {
+		err := apiServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
 			if logger, err := logging.GetLogger(ctx); err == nil {
 				logger.Print(fmt.Errorf("failed to start api server: %w", err))
 			}
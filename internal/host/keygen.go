@@ -10,24 +10,72 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
 	"time"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
 )
 
-func (h *Host) createSelfSignedCertificate(ctx context.Context, commonName string) error {
+func (h *Host) createSelfSignedCertificate(ctx context.Context) error {
+	hosts := CertificateHosts(h.Config.ServiceConfig.Address.Host, h.Config.ServiceConfig.CertificateHosts)
+	if err := GenerateCertificate(h.CertFile, h.KeyFile, hosts); err != nil {
+		return err
+	}
+
+	if logger, _ := logging.GetLogger(ctx); logger != nil {
+		logger.Printf("certificate for %v saved to %s\n", hosts, h.CertFile)
+		logger.Printf("private key saved to %s\n", h.KeyFile)
+	}
+
+	return nil
+}
+
+// CertificateHosts returns the host names and IP addresses that a
+// certificate for a service listening on host is issued for, followed by
+// extra. A service listening on all interfaces is reachable at the name of
+// the machine, localhost and the addresses of each of its interfaces.
+func CertificateHosts(host string, extra []string) []string {
+	var hosts []string
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		hosts = append(hosts, host)
+	} else {
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		hosts = append(hosts, "localhost", "127.0.0.1", "::1")
+
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+					hosts = append(hosts, ipNet.IP.String())
+				}
+			}
+		}
+	}
+
+	return append(hosts, extra...)
+}
+
+// GenerateCertificate writes a self-signed certificate that is valid for
+// each of hosts to certFile, and its private key to keyFile. The first host
+// is used as the common name of the certificate.
+func GenerateCertificate(certFile, keyFile string, hosts []string) error {
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return fmt.Errorf("failed to generate private key: %w", err)
 	}
 
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
 	// Create a certificate template
 	certTemplate := x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serialNumber,
 		Subject: pkix.Name{
 			Organization: []string{"coattail"},
-			CommonName:   commonName,
 		},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC),
@@ -36,6 +84,20 @@ func (h *Host) createSelfSignedCertificate(ctx context.Context, commonName strin
 		BasicConstraintsValid: true,
 	}
 
+	// Clients verify the certificate against its subject alternative names,
+	// the common name is only informational.
+	for _, host := range hosts {
+		if certTemplate.Subject.CommonName == "" {
+			certTemplate.Subject.CommonName = host
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			certTemplate.IPAddresses = append(certTemplate.IPAddresses, ip)
+		} else {
+			certTemplate.DNSNames = append(certTemplate.DNSNames, host)
+		}
+	}
+
 	certDER, err := x509.CreateCertificate(
 		rand.Reader,
 		&certTemplate,
@@ -47,40 +109,21 @@ func (h *Host) createSelfSignedCertificate(ctx context.Context, commonName strin
 		return fmt.Errorf("failed to create certificate: %w", err)
 	}
 
-	// Save the certificate to a file
-	certFile, err := os.Create("server.crt")
-	if err != nil {
-		return fmt.Errorf("failed to create certificate file: %w", err)
-	}
-	defer certFile.Close()
-
-	err = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	if err != nil {
-		return fmt.Errorf("failed to encode certificate: %w", err)
-	}
-	if logger, _ := logging.GetLogger(ctx); logger != nil {
-		logger.Println("certificate saved to server.crt")
-	}
-
-	// Save the private key to a file
-	keyFile, err := os.Create("server.key")
-	if err != nil {
-		return fmt.Errorf("failed to create key file: %w", err)
-	}
-	defer keyFile.Close()
-
 	privBytes, err := x509.MarshalECPrivateKey(privateKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal private key: %w", err)
 	}
 
-	err = pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes})
+	// Save the private key to a file
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}), 0o600)
 	if err != nil {
-		return fmt.Errorf("failed to encode private key: %w", err)
+		return fmt.Errorf("failed to write key file: %w", err)
 	}
 
-	if logger, _ := logging.GetLogger(ctx); logger != nil {
-		logger.Println("private key saved to server.key")
+	// Save the certificate to a file
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate file: %w", err)
 	}
 
 	return nil
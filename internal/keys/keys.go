@@ -10,4 +10,7 @@ const (
 	ConnectionKey
 	AuthenticationKey
 	PermissionsKey
+	StreamKey
+	ChallengeKey
+	ClaimsKey
 )
@@ -0,0 +1,11 @@
+package packets
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+// authorizedPacket is implemented by packets that must be authorized against
+// the claims of the connection before they are handled.
+type authorizedPacket interface {
+	authorize(claims authentication.Claims) error
+}
@@ -1,33 +1,166 @@
 package packets
 
 import (
+	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
 	"io"
+	"reflect"
+	"sync"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/util/atomicid"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
+const (
+	CodecGob     = "gob"
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+)
+
+// DefaultCodecs are the codecs supported by a Handler when none are
+// configured, in order of preference.
+var DefaultCodecs = []string{CodecGob, CodecMsgpack, CodecJSON}
+
 type EncodedPacket struct {
 	ID           uint64
 	RespondingTo uint64
 	Data         any
 }
 
+// Codec creates the encoders and decoders used to transfer packets over a
+// connection.
+type Codec interface {
+	// Name is the name used to identify the codec when it is negotiated.
+	Name() string
+	// NewEncoder returns an Encoder that writes packets to w.
+	NewEncoder(w io.Writer) Encoder
+	// NewDecoder returns a Decoder that reads packets from r.
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encoder writes packets to a stream.
+type Encoder interface {
+	Encode(p EncodedPacket) error
+}
+
+// Decoder reads packets from a stream.
+type Decoder interface {
+	Decode(p *EncodedPacket) error
+}
+
+var codecs = map[string]Codec{
+	CodecGob:     gobCodec{},
+	CodecJSON:    jsonCodec{},
+	CodecMsgpack: msgpackCodec{},
+}
+
+// GetCodec returns the codec with the provided name.
+func GetCodec(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %s", name)
+	}
+
+	return codec, nil
+}
+
+/* ====== Packet Registry ====== */
+
+var packetTypes = sync.Map{}
+
+// registerPacket registers a packet type so that it can be transferred by
+// every codec. Codecs without their own type information identify packets
+// by the name of their type.
+func registerPacket(p coattailtypes.Packet) {
+	gob.Register(p)
+	packetTypes.Store(packetTypeName(p), reflect.TypeOf(p))
+}
+
+func packetTypeName(p any) string {
+	return reflect.TypeOf(p).Name()
+}
+
+func newPacket(name string) (reflect.Value, error) {
+	t, ok := packetTypes.Load(name)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown packet type %s", name)
+	}
+
+	return reflect.New(t.(reflect.Type)), nil
+}
+
+/* ====== Stream ====== */
+
 type StreamCodec struct {
-	id      *atomicid.AtomicId
-	encoder *gob.Encoder
-	decoder *gob.Decoder
+	id       *atomicid.AtomicId
+	codec    Codec
+	encoder  Encoder
+	decoder  Decoder
+	frames   *frameWriter
+	counters *trafficCounters
+}
+
+// NewStreamCodec returns a StreamCodec that writes packets directly to rw, as
+// is done by protocol versions before FramedProtocolVersion.
+func NewStreamCodec(rw io.ReadWriter, codec Codec) *StreamCodec {
+	counters := &trafficCounters{}
+
+	return &StreamCodec{
+		id:       atomicid.New(new(uint64)),
+		codec:    codec,
+		encoder:  codec.NewEncoder(countingWriter{w: rw, counters: counters}),
+		decoder:  codec.NewDecoder(countingReader{r: rw, counters: counters}),
+		counters: counters,
+	}
 }
 
-func NewStreamCodec(rw io.ReadWriter) *StreamCodec {
+// NewFramedStreamCodec returns a StreamCodec that writes packets to rw in
+// frames. Packets larger than the compression threshold are compressed with
+// compressor, unless compressor is nil. Packets sent are split into frames
+// that fit within the remote limits, and packets received that exceed the
+// limits in cfg are rejected.
+func NewFramedStreamCodec(rw io.ReadWriter, codec Codec, compressor Compressor, cfg FrameConfig, remote FrameLimits) *StreamCodec {
+	if cfg.CompressionThreshold == 0 {
+		cfg.CompressionThreshold = DefaultCompressionThreshold
+	}
+
+	counters := &trafficCounters{}
+	frames := &frameWriter{
+		w:          rw,
+		compressor: compressor,
+		threshold:  cfg.CompressionThreshold,
+		limits:     remote.withDefaults(),
+		counters:   counters,
+	}
+
 	return &StreamCodec{
 		id:      atomicid.New(new(uint64)),
-		encoder: gob.NewEncoder(rw),
-		decoder: gob.NewDecoder(rw),
+		codec:   codec,
+		encoder: codec.NewEncoder(frames),
+		decoder: codec.NewDecoder(&frameReader{
+			r:          rw,
+			compressor: compressor,
+			limits:     cfg.FrameLimits.withDefaults(),
+			counters:   counters,
+		}),
+		frames:   frames,
+		counters: counters,
 	}
 }
 
+// Codec returns the codec used by the stream.
+func (e StreamCodec) Codec() Codec {
+	return e.codec
+}
+
+// Stats returns the number of bytes transferred by the stream.
+func (e StreamCodec) Stats() TrafficStats {
+	return e.counters.stats()
+}
+
 func (e StreamCodec) Read() (EncodedPacket, error) {
 	var p EncodedPacket
 	err := e.decoder.Decode(&p)
@@ -39,15 +172,215 @@ func (e StreamCodec) Read() (EncodedPacket, error) {
 }
 
 func (e StreamCodec) Write(callerId uint64, p coattailtypes.Packet) (uint64, error) {
-	packetId := e.id.Next()
+	packetId := e.NextID()
+	return packetId, e.WriteID(packetId, callerId, p)
+}
+
+// NextID reserves the ID of the next packet written to the stream.
+func (e StreamCodec) NextID() uint64 {
+	return e.id.Next()
+}
+
+// WriteID writes a packet to the stream using an ID that was reserved with
+// NextID.
+func (e StreamCodec) WriteID(packetId uint64, callerId uint64, p coattailtypes.Packet) error {
 	err := e.encoder.Encode(EncodedPacket{
 		ID:           packetId,
 		RespondingTo: callerId,
 		Data:         p,
 	})
+
+	if e.frames == nil {
+		return err
+	}
+
 	if err != nil {
-		return packetId, err
+		e.frames.buf.Reset()
+		return err
+	}
+
+	return e.frames.flush(packetId)
+}
+
+/* ====== Gob ====== */
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return CodecGob
+}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gobEncoder{gob.NewEncoder(w)}
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gobDecoder{gob.NewDecoder(r)}
+}
+
+type gobEncoder struct {
+	*gob.Encoder
+}
+
+func (e gobEncoder) Encode(p EncodedPacket) error {
+	return e.Encoder.Encode(p)
+}
+
+type gobDecoder struct {
+	*gob.Decoder
+}
+
+func (d gobDecoder) Decode(p *EncodedPacket) error {
+	return d.Decoder.Decode(p)
+}
+
+/* ====== JSON ====== */
+
+// jsonPacket is the representation of an EncodedPacket used by the JSON
+// codec.
+type jsonPacket struct {
+	ID           uint64          `json:"id"`
+	RespondingTo uint64          `json:"responding_to"`
+	Type         string          `json:"type"`
+	Data         json.RawMessage `json:"data"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return jsonEncoder{json.NewEncoder(w)}
+}
+
+func (jsonCodec) NewDecoder(r io.Reader) Decoder {
+	return jsonDecoder{json.NewDecoder(r)}
+}
+
+type jsonEncoder struct {
+	*json.Encoder
+}
+
+func (e jsonEncoder) Encode(p EncodedPacket) error {
+	data, err := json.Marshal(p.Data)
+	if err != nil {
+		return err
+	}
+
+	return e.Encoder.Encode(jsonPacket{
+		ID:           p.ID,
+		RespondingTo: p.RespondingTo,
+		Type:         packetTypeName(p.Data),
+		Data:         data,
+	})
+}
+
+type jsonDecoder struct {
+	*json.Decoder
+}
+
+func (d jsonDecoder) Decode(p *EncodedPacket) error {
+	var wire jsonPacket
+	if err := d.Decoder.Decode(&wire); err != nil {
+		return err
+	}
+
+	data, err := newPacket(wire.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(wire.Data, data.Interface()); err != nil {
+		return err
+	}
+
+	p.ID = wire.ID
+	p.RespondingTo = wire.RespondingTo
+	p.Data = data.Elem().Interface()
+	return nil
+}
+
+/* ====== Msgpack ====== */
+
+// msgpackPacket is the representation of an EncodedPacket used by the
+// msgpack codec.
+type msgpackPacket struct {
+	ID           uint64             `json:"id"`
+	RespondingTo uint64             `json:"responding_to"`
+	Type         string             `json:"type"`
+	Data         msgpack.RawMessage `json:"data"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return CodecMsgpack
+}
+
+func (msgpackCodec) NewEncoder(w io.Writer) Encoder {
+	return msgpackEncoder{newMsgpackEncoder(w)}
+}
+
+func (msgpackCodec) NewDecoder(r io.Reader) Decoder {
+	return msgpackDecoder{newMsgpackDecoder(r)}
+}
+
+// newMsgpackEncoder returns a msgpack encoder that uses the json struct tags
+// so that payloads decoded by the remote peer can be converted to their
+// original types.
+func newMsgpackEncoder(w io.Writer) *msgpack.Encoder {
+	encoder := msgpack.NewEncoder(w)
+	encoder.SetCustomStructTag("json")
+	return encoder
+}
+
+func newMsgpackDecoder(r io.Reader) *msgpack.Decoder {
+	decoder := msgpack.NewDecoder(r)
+	decoder.SetCustomStructTag("json")
+	return decoder
+}
+
+type msgpackEncoder struct {
+	*msgpack.Encoder
+}
+
+func (e msgpackEncoder) Encode(p EncodedPacket) error {
+	var data bytes.Buffer
+	if err := newMsgpackEncoder(&data).Encode(p.Data); err != nil {
+		return err
+	}
+
+	return e.Encoder.Encode(msgpackPacket{
+		ID:           p.ID,
+		RespondingTo: p.RespondingTo,
+		Type:         packetTypeName(p.Data),
+		Data:         data.Bytes(),
+	})
+}
+
+type msgpackDecoder struct {
+	*msgpack.Decoder
+}
+
+func (d msgpackDecoder) Decode(p *EncodedPacket) error {
+	var wire msgpackPacket
+	if err := d.Decoder.Decode(&wire); err != nil {
+		return err
+	}
+
+	data, err := newPacket(wire.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := newMsgpackDecoder(bytes.NewReader(wire.Data)).Decode(data.Interface()); err != nil {
+		return err
 	}
 
-	return packetId, nil
+	p.ID = wire.ID
+	p.RespondingTo = wire.RespondingTo
+	p.Data = data.Elem().Interface()
+	return nil
 }
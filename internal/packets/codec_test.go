@@ -0,0 +1,244 @@
+package packets_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+type codecTestArg struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestCodecs(t *testing.T) {
+	for _, name := range packets.DefaultCodecs {
+		t.Run(name, func(t *testing.T) {
+			codec, err := packets.GetCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			stream := packets.NewStreamCodec(&buf, codec)
+
+			sent := packets.ListUnitsResponsePacket{Values: []string{"Foo", "Bar"}}
+			id, err := stream.Write(7, sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			received, err := stream.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if received.ID != id || received.RespondingTo != 7 {
+				t.Errorf("expected [%d,r7], got [%d,r%d]", id, received.ID, received.RespondingTo)
+			}
+
+			packet, ok := received.Data.(packets.ListUnitsResponsePacket)
+			if !ok {
+				t.Fatalf("expected ListUnitsResponsePacket, got %T", received.Data)
+			}
+
+			if len(packet.Values) != 2 || packet.Values[0] != "Foo" || packet.Values[1] != "Bar" {
+				t.Errorf("expected %v, got %v", sent.Values, packet.Values)
+			}
+		})
+	}
+}
+
+func TestCodecsConvertPayload(t *testing.T) {
+	// The gob codec requires payloads to be registered and is covered by the
+	// packet round trip above.
+	for _, name := range []string{packets.CodecJSON, packets.CodecMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := packets.GetCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			stream := packets.NewStreamCodec(&buf, codec)
+
+			arg := codecTestArg{Name: "John", Count: 3}
+			if _, err := stream.Write(0, packets.ActionPacket{Action: "Greet", Arg: arg}); err != nil {
+				t.Fatal(err)
+			}
+
+			received, err := stream.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			packet, ok := received.Data.(packets.ActionPacket)
+			if !ok {
+				t.Fatalf("expected ActionPacket, got %T", received.Data)
+			}
+
+			converted, err := coattailtypes.ConvertValue[codecTestArg](packet.Arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if converted != arg {
+				t.Errorf("expected %v, got %v", arg, converted)
+			}
+		})
+	}
+}
+
+func TestFramedStreamCodecCompression(t *testing.T) {
+	codec, err := packets.GetCodec(packets.CodecGob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{packets.CompressionGzip, packets.CompressionZstd} {
+		t.Run(name, func(t *testing.T) {
+			compressor, err := packets.GetCompressor(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			stream := packets.NewFramedStreamCodec(&buf, codec, compressor, packets.FrameConfig{}, packets.FrameLimits{})
+
+			// Packets below the threshold are sent as they are.
+			if _, err := stream.Write(0, packets.PingPacket{}); err != nil {
+				t.Fatal(err)
+			}
+			small := stream.Stats()
+			if small.WireBytesSent <= small.BytesSent {
+				t.Errorf("expected small packet to be sent uncompressed, got %s", small)
+			}
+
+			sent := packets.ListUnitsResponsePacket{Values: []string{strings.Repeat("Foo", 10000)}}
+			if _, err := stream.Write(0, sent); err != nil {
+				t.Fatal(err)
+			}
+
+			stats := stream.Stats()
+			if stats.WireBytesSent-small.WireBytesSent >= stats.BytesSent-small.BytesSent {
+				t.Errorf("expected large packet to be compressed, got %s", stats)
+			}
+
+			for i := 0; i < 2; i++ {
+				received, err := stream.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if packet, ok := received.Data.(packets.ListUnitsResponsePacket); ok && packet.Values[0] != sent.Values[0] {
+					t.Errorf("received packet does not match sent packet")
+				}
+			}
+
+			if stats := stream.Stats(); stats.BytesReceived != stats.BytesSent || stats.WireBytesReceived != stats.WireBytesSent {
+				t.Errorf("expected received bytes to match sent bytes, got %s", stats)
+			}
+		})
+	}
+}
+
+func TestFramedStreamCodecChunking(t *testing.T) {
+	codec, err := packets.GetCodec(packets.CodecGob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := packets.FrameLimits{MaxFrameSize: packets.MinFrameSize}
+
+	var buf bytes.Buffer
+	stream := packets.NewFramedStreamCodec(&buf, codec, nil, packets.FrameConfig{FrameLimits: limits}, limits)
+
+	sent := packets.ListUnitsResponsePacket{Values: []string{strings.Repeat("Foo", packets.MinFrameSize)}}
+	if _, err := stream.Write(0, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packet is split into frames with a 13 byte header each.
+	stats := stream.Stats()
+	frames := (stats.BytesSent + packets.MinFrameSize - 1) / packets.MinFrameSize
+	if frames < 3 || stats.WireBytesSent != stats.BytesSent+frames*13 {
+		t.Errorf("expected packet to be split into frames, got %s", stats)
+	}
+
+	received, err := stream.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if packet, ok := received.Data.(packets.ListUnitsResponsePacket); !ok || packet.Values[0] != sent.Values[0] {
+		t.Errorf("received packet does not match sent packet")
+	}
+}
+
+func TestFramedStreamCodecLimits(t *testing.T) {
+	codec, err := packets.GetCodec(packets.CodecJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := packets.ListUnitsResponsePacket{Values: []string{strings.Repeat("Foo", packets.MinFrameSize)}}
+	limits := packets.FrameLimits{MaxPacketSize: packets.MinFrameSize}
+
+	t.Run("send", func(t *testing.T) {
+		var buf bytes.Buffer
+		stream := packets.NewFramedStreamCodec(&buf, codec, nil, packets.FrameConfig{}, limits)
+
+		if _, err := stream.Write(0, sent); !errors.Is(err, packets.ErrPacketTooLarge) {
+			t.Errorf("expected %s, got %v", packets.ErrPacketTooLarge, err)
+		}
+
+		if buf.Len() != 0 {
+			t.Errorf("expected nothing to be written, got %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		var buf bytes.Buffer
+		stream := packets.NewFramedStreamCodec(&buf, codec, nil, packets.FrameConfig{FrameLimits: limits}, packets.FrameLimits{})
+
+		id, err := stream.Write(0, sent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = stream.Read()
+
+		var frameErr *packets.FrameError
+		if !errors.As(err, &frameErr) || !errors.Is(err, packets.ErrPacketTooLarge) {
+			t.Fatalf("expected a frame error wrapping %s, got %v", packets.ErrPacketTooLarge, err)
+		}
+
+		if frameErr.PacketID != id {
+			t.Errorf("expected the error to identify packet %d, got %d", id, frameErr.PacketID)
+		}
+	})
+
+	t.Run("decompress", func(t *testing.T) {
+		compressor, err := packets.GetCompressor(packets.CompressionZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		stream := packets.NewFramedStreamCodec(&buf, codec, compressor, packets.FrameConfig{FrameLimits: limits}, packets.FrameLimits{})
+
+		// The compressed packet fits within the limit, but the decompressed
+		// packet doesn't.
+		if _, err := stream.Write(0, sent); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stream.Read(); !errors.Is(err, packets.ErrPacketTooLarge) {
+			t.Errorf("expected %s, got %v", packets.ErrPacketTooLarge, err)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package packets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// DefaultCompressionThreshold is the default size in bytes that an encoded
+// packet must reach before it is compressed.
+const DefaultCompressionThreshold = 1024
+
+// Compressor compresses the frames sent over a connection.
+type Compressor interface {
+	// Name is the name used to identify the compressor when it is
+	// negotiated.
+	Name() string
+	// Compress returns the compressed form of data.
+	Compress(data []byte) ([]byte, error)
+	// Decompress returns the decompressed form of data. Returns an error
+	// wrapping ErrPacketTooLarge if the decompressed data would be larger
+	// than limit bytes.
+	Decompress(data []byte, limit int) ([]byte, error)
+}
+
+var compressors = map[string]Compressor{
+	CompressionGzip: gzipCompressor{},
+	CompressionZstd: &zstdCompressor{},
+}
+
+// GetCompressor returns the compressor with the provided name.
+func GetCompressor(name string) (Compressor, error) {
+	compressor, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %s", name)
+	}
+
+	return compressor, nil
+}
+
+/* ====== Gzip ====== */
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return CompressionGzip
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return readLimited(r, limit)
+}
+
+/* ====== Zstd ====== */
+
+// zstdCompressor shares a single encoder between all connections, which is
+// safe for concurrent use with EncodeAll. Decoders are pooled since they
+// stream their output so that it can be limited.
+type zstdCompressor struct {
+	once     sync.Once
+	encoder  *zstd.Encoder
+	decoders sync.Pool
+	err      error
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil)
+	})
+
+	return c.err
+}
+
+func (c *zstdCompressor) Name() string {
+	return CompressionZstd
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	decoder, _ := c.decoders.Get().(*zstd.Decoder)
+	if decoder == nil {
+		var err error
+		decoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer c.decoders.Put(decoder)
+
+	if err := decoder.Reset(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return readLimited(decoder, limit)
+}
+
+// readLimited reads all of r, returning an error if r holds more than limit
+// bytes.
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > limit {
+		return nil, fmt.Errorf("%w: decompressed packet exceeds the maximum packet size of %d bytes", ErrPacketTooLarge, limit)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,340 @@
+package packets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// FramedProtocolVersion is the first protocol version in which each packet is
+// sent in its own frame. Earlier versions write packets directly to the
+// connection.
+const FramedProtocolVersion = 2
+
+// frameHeaderSize is the size of a frame header in bytes: a flags byte, the
+// ID of the packet that the frame belongs to as a big-endian uint64 and the
+// length of the frame payload as a big-endian uint32.
+const frameHeaderSize = 13
+
+const (
+	// frameFlagCompressed is set when the packet is compressed with the
+	// compressor negotiated for the connection.
+	frameFlagCompressed byte = 1 << iota
+	// frameFlagContinued is set on every frame of a packet except the last
+	// when the packet is split across multiple frames.
+	frameFlagContinued
+)
+
+const (
+	// DefaultMaxFrameSize is the default maximum size in bytes of a single
+	// frame. Larger packets are split across multiple frames.
+	DefaultMaxFrameSize = 1 << 20
+	// DefaultMaxPacketSize is the default maximum size in bytes of a single
+	// encoded packet.
+	DefaultMaxPacketSize = 64 << 20
+	// MinFrameSize is the smallest maximum frame size that will be used
+	// when splitting packets, regardless of the limit set by the peer.
+	MinFrameSize = 1 << 10
+)
+
+var (
+	ErrCompressedFrame = errors.New("received compressed frame without negotiated compression")
+	ErrFrameTooLarge   = errors.New("frame too large")
+	ErrPacketTooLarge  = errors.New("packet too large")
+)
+
+// FrameError is returned when a packet received from the remote peer
+// violates the framing protocol. The connection can't be used after a
+// FrameError since the rest of the packet was not read.
+type FrameError struct {
+	// PacketID is the ID of the packet that caused the error.
+	PacketID uint64
+	Err      error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("packet %d: %s", e.PacketID, e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// FrameLimits are the limits on the size of the frames and packets that a
+// peer accepts. Each peer tells the other its limits during the handshake.
+type FrameLimits struct {
+	// MaxFrameSize is the maximum size in bytes of a single frame. Defaults
+	// to DefaultMaxFrameSize.
+	MaxFrameSize int
+	// MaxPacketSize is the maximum size in bytes of a single packet once all
+	// of its frames have been reassembled and decompressed. Defaults to
+	// DefaultMaxPacketSize.
+	MaxPacketSize int
+}
+
+func (l FrameLimits) withDefaults() FrameLimits {
+	if l.MaxFrameSize <= 0 {
+		l.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if l.MaxPacketSize <= 0 {
+		l.MaxPacketSize = DefaultMaxPacketSize
+	}
+
+	return l
+}
+
+// FrameConfig configures how packets are framed on a connection.
+type FrameConfig struct {
+	// FrameLimits are the limits on the size of the frames and packets
+	// received from the remote peer.
+	FrameLimits
+	// CompressionThreshold is the size in bytes that an encoded packet must
+	// reach before it is compressed. Defaults to DefaultCompressionThreshold.
+	CompressionThreshold int
+}
+
+// TrafficStats counts the bytes transferred over a connection.
+type TrafficStats struct {
+	// BytesSent is the number of bytes of encoded packets sent, before
+	// compression.
+	BytesSent int64
+	// BytesReceived is the number of bytes of encoded packets received,
+	// after decompression.
+	BytesReceived int64
+	// WireBytesSent is the number of bytes written to the connection.
+	WireBytesSent int64
+	// WireBytesReceived is the number of bytes read from the connection.
+	WireBytesReceived int64
+}
+
+func (s TrafficStats) String() string {
+	return fmt.Sprintf("sent %d bytes (%d on the wire), received %d bytes (%d on the wire)",
+		s.BytesSent, s.WireBytesSent, s.BytesReceived, s.WireBytesReceived)
+}
+
+type trafficCounters struct {
+	bytesSent         atomic.Int64
+	bytesReceived     atomic.Int64
+	wireBytesSent     atomic.Int64
+	wireBytesReceived atomic.Int64
+}
+
+func (c *trafficCounters) stats() TrafficStats {
+	return TrafficStats{
+		BytesSent:         c.bytesSent.Load(),
+		BytesReceived:     c.bytesReceived.Load(),
+		WireBytesSent:     c.wireBytesSent.Load(),
+		WireBytesReceived: c.wireBytesReceived.Load(),
+	}
+}
+
+/* ====== Writer ====== */
+
+// frameWriter collects everything written by an Encoder and writes it to the
+// connection when flush is called, split into frames no larger than the
+// remote peer accepts.
+type frameWriter struct {
+	w          io.Writer
+	buf        bytes.Buffer
+	compressor Compressor
+	threshold  int
+	limits     FrameLimits
+	counters   *trafficCounters
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *frameWriter) flush(packetID uint64) error {
+	defer f.buf.Reset()
+
+	payload := f.buf.Bytes()
+	if len(payload) > f.limits.MaxPacketSize {
+		return fmt.Errorf("%w: packet of %d bytes exceeds the maximum packet size of %d bytes accepted by the remote peer",
+			ErrPacketTooLarge, len(payload), f.limits.MaxPacketSize)
+	}
+
+	f.counters.bytesSent.Add(int64(len(payload)))
+
+	var flags byte
+	if f.compressor != nil && len(payload) >= f.threshold {
+		compressed, err := f.compressor.Compress(payload)
+		if err != nil {
+			return fmt.Errorf("failed to compress frame: %w", err)
+		}
+
+		// Payloads that don't compress well are sent as they are.
+		if len(compressed) < len(payload) {
+			payload = compressed
+			flags |= frameFlagCompressed
+		}
+	}
+
+	frameSize := f.limits.MaxFrameSize
+	if frameSize < MinFrameSize {
+		frameSize = MinFrameSize
+	}
+
+	for {
+		chunk := payload
+		chunkFlags := flags
+		if len(chunk) > frameSize {
+			chunk = payload[:frameSize]
+			chunkFlags |= frameFlagContinued
+		}
+		payload = payload[len(chunk):]
+
+		header := make([]byte, frameHeaderSize)
+		header[0] = chunkFlags
+		binary.BigEndian.PutUint64(header[1:], packetID)
+		binary.BigEndian.PutUint32(header[9:], uint32(len(chunk)))
+
+		if _, err := f.w.Write(append(header, chunk...)); err != nil {
+			return err
+		}
+
+		f.counters.wireBytesSent.Add(int64(frameHeaderSize + len(chunk)))
+
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+/* ====== Reader ====== */
+
+// frameReader reads packets from the connection on demand, reassembling
+// packets that were split across multiple frames, and hands them to a
+// Decoder.
+type frameReader struct {
+	r          io.Reader
+	packet     bytes.Reader
+	compressor Compressor
+	limits     FrameLimits
+	counters   *trafficCounters
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	if f.packet.Len() == 0 {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	return f.packet.Read(p)
+}
+
+func (f *frameReader) ReadByte() (byte, error) {
+	if f.packet.Len() == 0 {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	return f.packet.ReadByte()
+}
+
+// next reads every frame of the next packet.
+func (f *frameReader) next() error {
+	var payload []byte
+	var packetID uint64
+	var flags byte
+
+	for first := true; first || flags&frameFlagContinued != 0; first = false {
+		header := make([]byte, frameHeaderSize)
+		if _, err := io.ReadFull(f.r, header); err != nil {
+			if !first && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		flags = header[0]
+		id := binary.BigEndian.Uint64(header[1:])
+		length := int(binary.BigEndian.Uint32(header[9:]))
+
+		if !first && id != packetID {
+			return &FrameError{
+				PacketID: packetID,
+				Err:      fmt.Errorf("frame of packet %d received before packet %d was complete", id, packetID),
+			}
+		}
+		packetID = id
+
+		if length > f.limits.MaxFrameSize {
+			return &FrameError{
+				PacketID: packetID,
+				Err:      fmt.Errorf("%w: frame of %d bytes exceeds the maximum frame size of %d bytes", ErrFrameTooLarge, length, f.limits.MaxFrameSize),
+			}
+		}
+
+		if len(payload)+length > f.limits.MaxPacketSize {
+			return &FrameError{
+				PacketID: packetID,
+				Err:      fmt.Errorf("%w: packet exceeds the maximum packet size of %d bytes", ErrPacketTooLarge, f.limits.MaxPacketSize),
+			}
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(f.r, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		payload = append(payload, frame...)
+		f.counters.wireBytesReceived.Add(int64(frameHeaderSize + length))
+	}
+
+	if flags&frameFlagCompressed != 0 {
+		if f.compressor == nil {
+			return &FrameError{PacketID: packetID, Err: ErrCompressedFrame}
+		}
+
+		var err error
+		payload, err = f.compressor.Decompress(payload, f.limits.MaxPacketSize)
+		if err != nil {
+			return &FrameError{
+				PacketID: packetID,
+				Err:      fmt.Errorf("failed to decompress packet: %w", err),
+			}
+		}
+	}
+
+	f.counters.bytesReceived.Add(int64(len(payload)))
+	f.packet.Reset(payload)
+	return nil
+}
+
+/* ====== Unframed ====== */
+
+// countingReader and countingWriter count the bytes transferred over
+// connections that don't use frames.
+type countingReader struct {
+	r        io.Reader
+	counters *trafficCounters
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counters.bytesReceived.Add(int64(n))
+	c.counters.wireBytesReceived.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w        io.Writer
+	counters *trafficCounters
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counters.bytesSent.Add(int64(n))
+	c.counters.wireBytesSent.Add(int64(n))
+	return n, err
+}
@@ -2,6 +2,8 @@ package packets
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -11,11 +13,15 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
 	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/ratelimit"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/workerpool"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
@@ -24,6 +30,46 @@ import (
 // will block until an operation is completed.
 const MaxBufferedOperations = 100
 
+// HandshakeTimeout is the maximum amount of time to wait for the remote peer
+// to complete the handshake when a connection is established.
+const HandshakeTimeout = 10 * time.Second
+
+const (
+	// DefaultIdleTimeout is the default amount of time a connection can go
+	// without receiving a packet before it is closed.
+	DefaultIdleTimeout = 30 * time.Second
+	// DefaultKeepaliveInterval is the default interval at which heartbeats
+	// are sent to the remote peer.
+	DefaultKeepaliveInterval = 10 * time.Second
+)
+
+// DefaultMaxDecodeFailures is the default number of consecutive packets that
+// can fail to decode before the connection is closed.
+const DefaultMaxDecodeFailures = 10
+
+const (
+	// DefaultWorkers is the default number of packets from a single
+	// connection that can be handled at once.
+	DefaultWorkers = 32
+	// DefaultQueueSize is the default number of packets from a single
+	// connection that can wait to be handled.
+	DefaultQueueSize = 128
+	// DefaultHostWorkers is the default number of packets from every
+	// connection to a host that can be handled at once.
+	DefaultHostWorkers = 256
+	// DefaultHostQueueSize is the default number of packets from every
+	// connection to a host that can wait to be handled.
+	DefaultHostQueueSize = 1024
+	// DefaultQueueTimeout is the default amount of time to wait for room in
+	// a queue before a packet is rejected.
+	DefaultQueueTimeout = time.Second
+)
+
+var (
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrRequestCancelled = errors.New("request cancelled by remote peer")
+)
+
 type HandlerInputRole int
 
 const (
@@ -31,26 +77,135 @@ const (
 	InputRoleClient
 )
 
+// HandlerConfig is the configuration of a Handler.
+type HandlerConfig struct {
+	// Codecs are the names of the codecs supported by the Handler in order of
+	// preference. Defaults to DefaultCodecs.
+	Codecs []string
+	// Features are the optional protocol features enabled on the Handler.
+	// Defaults to DefaultFeatures.
+	Features []Feature
+	// IdleTimeout is the amount of time the connection can go without
+	// receiving a packet before it is closed. Defaults to DefaultIdleTimeout.
+	// A negative value disables the timeout.
+	IdleTimeout time.Duration
+	// KeepaliveInterval is the interval at which heartbeats are sent to the
+	// remote peer. Defaults to DefaultKeepaliveInterval. A negative value
+	// disables heartbeats.
+	KeepaliveInterval time.Duration
+	// Compression are the names of the compression algorithms supported by
+	// the Handler in order of preference. Connections are not compressed
+	// unless at least one is configured.
+	Compression []string
+	// CompressionThreshold is the size in bytes that an encoded packet must
+	// reach before it is compressed. Defaults to DefaultCompressionThreshold.
+	CompressionThreshold int
+	// MaxFrameSize is the maximum size in bytes of a frame accepted from the
+	// remote peer. Defaults to DefaultMaxFrameSize.
+	MaxFrameSize int
+	// MaxPacketSize is the maximum size in bytes of a packet accepted from
+	// the remote peer. Defaults to DefaultMaxPacketSize.
+	MaxPacketSize int
+	// RateLimiter limits the rate at which packets are accepted from the
+	// remote peer. Packets are not limited if it is nil.
+	RateLimiter *ratelimit.Limiter
+	// TokenRateLimits holds the rate limits shared by every connection
+	// authenticated with the same token. Packets are not limited by token if
+	// it is nil.
+	TokenRateLimits *ratelimit.Registry
+	// MaxDecodeFailures is the number of consecutive packets that can fail to
+	// decode before the connection is closed. Defaults to
+	// DefaultMaxDecodeFailures. A negative value disables the limit.
+	MaxDecodeFailures int
+	// Workers is the number of packets from the remote peer that can be
+	// handled at once. Defaults to DefaultWorkers. A negative value removes
+	// the limit.
+	Workers int
+	// QueueSize is the number of packets from the remote peer that can wait
+	// to be handled. Defaults to DefaultQueueSize.
+	QueueSize int
+	// QueueTimeout is the amount of time to wait for room in a queue before
+	// a packet is rejected. Defaults to DefaultQueueTimeout.
+	QueueTimeout time.Duration
+	// HostWorkers is the worker pool shared by every connection to the host.
+	// Packets are only limited by the workers of the connection if it is
+	// nil.
+	HostWorkers *workerpool.Pool
+	// AllowBearerTokens allows authenticating by sending the whole token
+	// when the remote peer does not support FeatureChallenge. A server
+	// accepts tokens from such clients, and a client sends its token to such
+	// servers.
+	AllowBearerTokens bool
+}
+
+// NewHostWorkerPool creates the worker pool shared by every connection to a
+// host. Defaults to DefaultHostWorkers workers and a queue of
+// DefaultHostQueueSize packets. Returns nil if workers is negative.
+func NewHostWorkerPool(workers int, queueSize int) *workerpool.Pool {
+	if workers == 0 {
+		workers = DefaultHostWorkers
+	}
+	if queueSize == 0 {
+		queueSize = DefaultHostQueueSize
+	}
+
+	return workerpool.New(workers, queueSize)
+}
+
 // Handler is a handler for incoming and outgoing packets on a connection.
 type Handler struct {
 	ctx                 context.Context
 	inputRole           HandlerInputRole
 	conn                net.Conn
-	authenticated       bool
 	permissions         permission.Permissions
+	tokenID             atomic.Value
+	authMu              sync.RWMutex
+	authenticated       bool
+	claims              authentication.Claims
 	authenticationError string
+	authAttempted       atomic.Bool
+	session             Session
 	codec               *StreamCodec
 	wg                  sync.WaitGroup
-	authWg              sync.WaitGroup
+	authOnce            sync.Once
+	authSettled         chan struct{}
 	output              chan outputOperation
+	outputMu            sync.RWMutex
+	outputClosed        bool
 	connected           bool
+	done                chan struct{}
+	idleTimeout         time.Duration
+	keepaliveInterval   time.Duration
+	latency             atomic.Int64
+	inflight            sync.Map
+	streams             sync.Map
+	senders             sync.Map
+	pending             pendingRequests
+	authDone            chan struct{}
+	authErr             error
+	handling            sync.WaitGroup
+	shutdownMu          sync.RWMutex
+	shuttingDown        bool
+	goodbye             atomic.Bool
+	rateLimiter         *ratelimit.Limiter
+	tokenRateLimits     *ratelimit.Registry
+	tokenRateLimiter    atomic.Pointer[ratelimit.Limiter]
+	maxDecodeFailures   int
+	workers             *workerpool.Pool
+	workerCount         int
+	queueSize           int
+	queueTimeout        time.Duration
+	hostWorkers         *workerpool.Pool
+	challenge           []byte
+	allowBearerTokens   bool
 }
 
 // NewHandler creates a new PacketHandler with the provided context and
 // connection. The PacketHandler will handle incoming and outgoing packets on
 // the connection. The context will be used to pass services to the packet
-// handlers.
-func NewHandler(ctx context.Context, conn net.Conn, inputRole HandlerInputRole) *Handler {
+// handlers. The codec used for the connection is negotiated with the remote
+// peer before NewHandler returns.
+func NewHandler(ctx context.Context, conn net.Conn, inputRole HandlerInputRole, cfg HandlerConfig) (*Handler, error) {
 	var ctxWithLogger context.Context = ctx
 	if logger, _ := logging.GetLogger(ctx); logger != nil {
 		_, port, err := net.SplitHostPort(conn.RemoteAddr().String())
@@ -68,13 +223,92 @@ func NewHandler(ctx context.Context, conn net.Conn, inputRole HandlerInputRole)
 		logger.Printf("created connection handler: %s, role: %s\n", conn.RemoteAddr().String(), role)
 	}
 
+	limits := FrameLimits{
+		MaxFrameSize:  cfg.MaxFrameSize,
+		MaxPacketSize: cfg.MaxPacketSize,
+	}.withDefaults()
+
+	handshakeCfg := handshakeConfig{
+		codecs:      cfg.Codecs,
+		features:    cfg.Features,
+		compression: cfg.Compression,
+		limits:      limits,
+	}
+	if handshakeCfg.codecs == nil {
+		handshakeCfg.codecs = DefaultCodecs
+	}
+	if handshakeCfg.features == nil {
+		handshakeCfg.features = DefaultFeatures
+	}
+
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	var session Session
+	var err error
+	if inputRole == InputRoleClient {
+		session, err = clientHandshake(conn, handshakeCfg)
+	} else {
+		session, err = serverHandshake(conn, handshakeCfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	challenge, err := authChallenge(session, conn)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	if logger, err := logging.GetLogger(ctx); err == nil {
+		compression := "none"
+		if session.Compression != nil {
+			compression = session.Compression.Name()
+		}
+		logger.Printf("negotiated protocol version %d with peer running framework version %s, codec: %s, compression: %s, features: %v\n",
+			session.ProtocolVersion, session.RemoteFrameworkVersion, session.Codec.Name(), compression, session.Features)
+	}
+
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if cfg.KeepaliveInterval == 0 {
+		cfg.KeepaliveInterval = DefaultKeepaliveInterval
+	}
+	if cfg.MaxDecodeFailures == 0 {
+		cfg.MaxDecodeFailures = DefaultMaxDecodeFailures
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.QueueTimeout == 0 {
+		cfg.QueueTimeout = DefaultQueueTimeout
+	}
+
 	return &Handler{
 		ctx:           ctxWithLogger,
 		inputRole:     inputRole,
 		conn:          conn,
 		authenticated: inputRole == InputRoleClient,
-		codec:         NewStreamCodec(conn),
-	}
+		session:       session,
+		codec: session.NewStreamCodec(conn, FrameConfig{
+			FrameLimits:          limits,
+			CompressionThreshold: cfg.CompressionThreshold,
+		}),
+		idleTimeout:       cfg.IdleTimeout,
+		keepaliveInterval: cfg.KeepaliveInterval,
+		rateLimiter:       cfg.RateLimiter,
+		tokenRateLimits:   cfg.TokenRateLimits,
+		maxDecodeFailures: cfg.MaxDecodeFailures,
+		workerCount:       cfg.Workers,
+		queueSize:         cfg.QueueSize,
+		queueTimeout:      cfg.QueueTimeout,
+		hostWorkers:       cfg.HostWorkers,
+		challenge:         challenge,
+		allowBearerTokens: cfg.AllowBearerTokens,
+	}, nil
 }
 
 // Context returns the context that was passed to the PacketHandler when it was
@@ -83,6 +317,56 @@ func (c *Handler) Context() context.Context {
 	return c.ctx
 }
 
+// Session returns the session that was negotiated with the remote peer.
+func (c *Handler) Session() Session {
+	return c.session
+}
+
+// Codec returns the codec that was negotiated for the connection.
+func (c *Handler) Codec() Codec {
+	return c.session.Codec
+}
+
+// HasFeature returns true if the feature was enabled for the connection
+// during the handshake.
+func (c *Handler) HasFeature(feature Feature) bool {
+	return c.session.HasFeature(feature)
+}
+
+// Latency returns the most recently measured round-trip time to the remote
+// peer. Returns 0 if no heartbeat has completed yet.
+func (c *Handler) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
+
+// TokenID returns the revocation ID of the token that the remote peer
+// authenticated with, or an empty string if it has not authenticated.
+func (c *Handler) TokenID() string {
+	id, _ := c.tokenID.Load().(string)
+	return id
+}
+
+// authResult returns whether the remote peer has authenticated, the claims
+// of the token that it authenticated with, and why it failed to
+// authenticate.
+func (c *Handler) authResult() (bool, authentication.Claims, string) {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+
+	return c.authenticated, c.claims, c.authenticationError
+}
+
+// Stats returns the number of bytes transferred over the connection.
+func (c *Handler) Stats() TrafficStats {
+	return c.codec.Stats()
+}
+
+// WorkerStats returns the state of the workers that handle the packets
+// received on the connection.
+func (c *Handler) WorkerStats() workerpool.Stats {
+	return c.workers.Stats()
+}
+
 // HandlePackets starts handling incoming and outgoing packets on the connection.
 // This function will block until the connection is closed.
 func (c *Handler) HandlePackets(logPackets bool) {
@@ -93,18 +377,34 @@ func (c *Handler) HandlePackets(logPackets bool) {
 	c.connected = true
 	c.wg = sync.WaitGroup{}
 	c.output = make(chan outputOperation, MaxBufferedOperations)
+	c.done = make(chan struct{})
+	c.authDone = make(chan struct{})
+	c.authSettled = make(chan struct{})
+	c.workers = workerpool.New(c.workerCount, c.queueSize)
 	c.wg.Add(2)
 	go c.startOutput(logPackets)
 	go c.startInput(logPackets)
 
-	c.authWg.Add(1)
 	if c.inputRole == InputRoleClient {
 		go c.startAuthentication()
 	}
 
+	go c.startKeepalive()
+
 	go func() {
 		c.wg.Wait()
+		c.conn.Close()
 		c.connected = false
+		c.closeStreams()
+		c.workers.Close()
+		close(c.done)
+
+		if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+			logger.Printf("connection closed: %s, workers: %s\n", c.Stats(), c.WorkerStats())
+			if c.hostWorkers != nil {
+				logger.Printf("host workers: %s\n", c.hostWorkers.Stats())
+			}
+		}
 	}()
 }
 
@@ -114,6 +414,78 @@ func (c *Handler) IsConnected() bool {
 	return c.connected
 }
 
+// Pending returns the number of requests sent to the remote peer that are
+// waiting for a response.
+func (c *Handler) Pending() int {
+	return c.pending.len()
+}
+
+// Closing returns true once either peer has said goodbye. No new requests
+// should be sent on a connection that is closing.
+func (c *Handler) Closing() bool {
+	return c.goodbye.Load()
+}
+
+// Shutdown says goodbye to the remote peer so that it stops sending requests
+// on the connection, waits for the packets that are being handled to be
+// handled, and then closes the connection. Packets received after Shutdown is
+// called are rejected with ErrShuttingDown. The connection is closed without
+// waiting any longer once ctx is done.
+func (c *Handler) Shutdown(ctx context.Context) error {
+	c.shutdownMu.Lock()
+	c.shuttingDown = true
+	c.shutdownMu.Unlock()
+
+	c.goodbye.Store(true)
+	if c.HasFeature(FeatureGoodbye) {
+		if err := c.Send(GoodbyePacket{}); err != nil {
+			if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+				logger.Printf("failed to say goodbye: %s\n", err)
+			}
+		}
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		c.handling.Wait()
+		close(handled)
+	}()
+
+	var err error
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.Close()
+	return err
+}
+
+// Done returns a channel that is closed once the connection has been closed.
+// It must only be called after HandlePackets.
+func (c *Handler) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection. Requests that are waiting for a response fail
+// with ErrConnectionClosed.
+func (c *Handler) Close() error {
+	return c.conn.Close()
+}
+
+// WaitAuthenticated waits for a client Handler to authenticate with the
+// remote peer. Returns the reason if authentication failed. It must only be
+// called after HandlePackets.
+func (c *Handler) WaitAuthenticated(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.authDone:
+		return c.authErr
+	}
+}
+
 // Send sends a packet to the remote peer and returns an error if the packet
 // could not be sent. If the remote peer response with a packet, it will be
 // automatically handled.
@@ -122,10 +494,13 @@ func (c *Handler) Send(packet coattailtypes.Packet) error {
 	errChan := make(chan error)
 
 	// Send the packet to the remote peer
-	c.output <- outputOperation{
+	err := c.enqueue(outputOperation{
 		callerId: 0,
 		packet:   packet,
 		errChan:  errChan,
+	})
+	if err != nil {
+		return err
 	}
 
 	// Wait for the result of the operation
@@ -142,7 +517,8 @@ type Request struct {
 	Packet coattailtypes.Packet
 	// ResponseTimeout is the amount of time to wait for a response from the
 	// remote peer. If the response is not received within this time, an error
-	// will be returned. Defaults to 10 seconds.
+	// will be returned. Defaults to 10 seconds if the context passed to
+	// Request has no deadline.
 	ResponseTimeout time.Duration
 }
 
@@ -152,34 +528,67 @@ type Request struct {
 // handled. You must call the Handle method on the response packet to handle it.
 // The context passed to the Handle method will be the same context that was
 // passed to the PacketHandler when it was created.
-func (c *Handler) Request(request Request) (coattailtypes.Packet, error) {
-	errChan := make(chan error)
-	respChan := make(chan any)
-	idChan := make(chan uint64)
+//
+// If ctx is done before the response is received, or the response timeout
+// passes, a CancelPacket is sent so that the remote peer can stop handling
+// the request.
+func (c *Handler) Request(ctx context.Context, request Request) (coattailtypes.Packet, error) {
+	// The channels are buffered so that the output and input handlers never
+	// block on a caller that has stopped waiting.
+	errChan := make(chan error, 1)
+	respChan := make(chan any, 1)
+	idChan := make(chan uint64, 1)
 
-	c.output <- outputOperation{
+	err := c.enqueue(outputOperation{
 		callerId: 0,
 		packet:   request.Packet,
 		errChan:  errChan,
 		idChan:   idChan,
 		respChan: respChan,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	id := <-idChan
+	var id uint64
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case id = <-idChan:
+	}
 
 	if request.ResponseTimeout == 0 {
-		request.ResponseTimeout = 10 * time.Second
+		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+			request.ResponseTimeout = 10 * time.Second
+		}
+	}
+
+	var timeout <-chan time.Time
+	if request.ResponseTimeout > 0 {
+		timer := time.NewTimer(request.ResponseTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
 	select {
-	case <-time.After(request.ResponseTimeout):
-		responseHandlers.Delete(id)
-		errorHandlers.Delete(id)
+	case <-ctx.Done():
+		c.abandon(id)
+		return nil, ctx.Err()
+	case <-timeout:
+		c.abandon(id)
 		packetName := reflect.TypeOf(request.Packet).Name()
 		return nil, fmt.Errorf("timeout waiting for response for packet %v %v", packetName, id)
 	case resp := <-respChan:
-		if p, ok := resp.(AuthenticationInvalidPacket); ok {
-			return nil, errors.New(p.Error)
+		switch p := resp.(type) {
+		case AuthenticationInvalidPacket:
+			return nil, &coattailtypes.RemoteError{
+				Code:    coattailtypes.ErrorCodeUnauthenticated,
+				Message: p.Error,
+			}
+		case AuthorizationDeniedPacket:
+			return nil, p.Err()
+		case ErrorPacket:
+			return nil, p.Err()
 		}
 		return resp.(coattailtypes.Packet), nil
 	case err := <-errChan:
@@ -187,8 +596,46 @@ func (c *Handler) Request(request Request) (coattailtypes.Packet, error) {
 	}
 }
 
-var responseHandlers = sync.Map{}
-var errorHandlers = sync.Map{}
+// Stream sends a packet that starts a stream to the remote peer and returns
+// the Stream that receives its results. Returns ErrStreamingUnsupported if
+// streaming was not enabled for the connection during the handshake.
+//
+// The stream is cancelled if ctx is done before the stream ends.
+func (c *Handler) Stream(ctx context.Context, packet coattailtypes.Packet) (*Stream, error) {
+	if !c.HasFeature(FeatureStreaming) {
+		return nil, ErrStreamingUnsupported
+	}
+
+	stream := newStream(ctx, c)
+	errChan := make(chan error, 1)
+
+	err := c.enqueue(outputOperation{
+		callerId: 0,
+		packet:   packet,
+		errChan:  errChan,
+		stream:   stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		// Stop the stream once the packet that starts it has been written.
+		go func() {
+			if err := <-errChan; err == nil {
+				stream.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case err := <-errChan:
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stream, nil
+}
 
 type outputOperation struct {
 	callerId uint64
@@ -196,6 +643,7 @@ type outputOperation struct {
 	idChan   chan uint64
 	errChan  chan error
 	respChan chan any
+	stream   *Stream
 }
 
 type response struct {
@@ -210,38 +658,222 @@ func (c *Handler) respond(resp response) error {
 	errChan := make(chan error)
 	idChan := make(chan uint64)
 
-	c.output <- outputOperation{
+	err := c.enqueue(outputOperation{
 		callerId: resp.CallerID,
 		packet:   resp.Packet,
 		errChan:  errChan,
 		idChan:   idChan,
+	})
+	if err != nil {
+		return err
 	}
 
-	id := <-idChan
-	errorHandlers.Store(id, errChan)
+	<-idChan
 
 	return <-errChan
 }
 
+// abandon stops waiting for a response to the packet with the provided ID and
+// asks the remote peer to stop handling it.
+func (c *Handler) abandon(id uint64) {
+	c.pending.remove(id)
+
+	go func() {
+		if err := c.Send(CancelPacket{RequestID: id}); err != nil {
+			if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+				logger.Printf("failed to cancel request %d: %s\n", id, err)
+			}
+		}
+	}()
+}
+
+// cancelInflight cancels the context used to handle the packet with the
+// provided ID if it is still being handled, or keeps it from being handled
+// if it is waiting for a worker.
+func (c *Handler) cancelInflight(id uint64) {
+	if request, ok := c.inflight.Load(id); ok {
+		request.(*inflightRequest).abort()
+	}
+}
+
+// enqueue queues an operation to be written by the output handler. Returns
+// ErrConnectionClosed if the output handler has been closed.
+func (c *Handler) enqueue(operation outputOperation) error {
+	c.outputMu.RLock()
+	defer c.outputMu.RUnlock()
+
+	if c.outputClosed {
+		return ErrConnectionClosed
+	}
+
+	c.output <- operation
+	return nil
+}
+
+func (c *Handler) closeOutput() {
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+
+	c.outputClosed = true
+	close(c.output)
+}
+
+// dispatchStream delivers packets that belong to a stream. Returns false if
+// the packet does not belong to a stream.
+func (c *Handler) dispatchStream(packet EncodedPacket) bool {
+	if credit, isCredit := packet.Data.(StreamCreditPacket); isCredit {
+		if sender, ok := c.senders.Load(credit.RequestID); ok {
+			sender.(*streamSender).grant(credit.Credits)
+		}
+		return true
+	}
+
+	if packet.RespondingTo == 0 {
+		return false
+	}
+
+	stream, ok := c.streams.Load(packet.RespondingTo)
+	if !ok {
+		return false
+	}
+
+	stream.(*Stream).deliver(packet.Data)
+	return true
+}
+
+// closeStreams ends every stream on the connection once it has been closed.
+func (c *Handler) closeStreams() {
+	c.streams.Range(func(id, stream any) bool {
+		c.streams.Delete(id)
+		stream.(*Stream).finish(ErrConnectionClosed)
+		return true
+	})
+
+	c.senders.Range(func(_, sender any) bool {
+		sender.(*streamSender).close()
+		return true
+	})
+}
+
+// allow applies the rate limits of the connection to a packet received from
+// the remote peer. Returns a RateLimitedError if a limit has been exceeded.
+// Responses, heartbeats and cancellations are never limited.
+func (c *Handler) allow(packet EncodedPacket) error {
+	if packet.RespondingTo != 0 {
+		return nil
+	}
+
+	switch packet.Data.(type) {
+	case PingPacket, CancelPacket:
+		return nil
+	}
+
+	if ok, retryAfter := c.rateLimiter.Allow(); !ok {
+		return &coattailtypes.RateLimitedError{Limit: "connection", RetryAfter: retryAfter}
+	}
+
+	if ok, retryAfter := c.tokenRateLimiter.Load().Allow(); !ok {
+		return &coattailtypes.RateLimitedError{Limit: "token", RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// reject responds to a packet received from the remote peer with the reason
+// that it will not be handled.
+func (c *Handler) reject(packet EncodedPacket, reason error) {
+	if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+		logger.Printf("Rejected packet %T[%d]: %s\n", packet.Data, packet.ID, reason)
+	}
+
+	err := c.respond(response{
+		CallerID: packet.ID,
+		Packet:   NewErrorPacket(reason),
+	})
+	if err != nil {
+		if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+			logger.Printf("Error writing response packet: %s\n", err)
+		}
+	}
+}
+
+func (c *Handler) resetReadDeadline() {
+	if c.idleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	}
+}
+
+// startKeepalive periodically sends a PingPacket to the remote peer until the
+// connection is closed, keeping the connection from going idle and measuring
+// the round-trip latency.
+func (c *Handler) startKeepalive() {
+	if c.keepaliveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		timeout := c.idleTimeout
+		if timeout <= 0 {
+			timeout = c.keepaliveInterval
+		}
+
+		sentAt := time.Now()
+		_, err := c.Request(context.Background(), Request{
+			Packet:          PingPacket{},
+			ResponseTimeout: timeout,
+		})
+		if err != nil {
+			if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+				logger.Printf("heartbeat failed: %s\n", err)
+			}
+			continue
+		}
+
+		c.latency.Store(int64(time.Since(sentAt)))
+	}
+}
+
 func (c *Handler) startAuthentication() {
+	var authErr error
+	defer func() {
+		c.authErr = authErr
+		close(c.authDone)
+	}()
+
 	handleResponseErr := func(err error) {
+		authErr = err
 		if logger, _ := logging.GetLogger(c.Context()); logger != nil {
 			logger.Printf("%s", err)
 		}
 	}
 
-	resp, err := c.Request(Request{
-		Packet: AuthenticationPacket{
-			Token: c.ctx.Value(keys.AuthenticationKey).(string),
-		},
+	// The token is only sent if the server can't be sent a proof of it.
+	token := c.ctx.Value(keys.AuthenticationKey).(string)
+	packet := AuthenticationPacket{Token: token}
+	if c.challenge != nil {
+		packet = newProofPacket(token, c.challenge)
+	} else if !c.allowBearerTokens {
+		handleResponseErr(ErrChallengeUnsupported)
+		return
+	}
+
+	resp, err := c.Request(context.Background(), Request{
+		Packet: packet,
 	})
 	if err != nil {
 		handleResponseErr(err)
 		return
 	}
 
-	defer c.authWg.Done()
-
 	respPacket, isRespPacket := resp.(AuthenticationResponsePacket)
 	if !isRespPacket {
 		handleResponseErr(fmt.Errorf("unexpected response packet of type %v", resp))
@@ -253,11 +885,36 @@ func (c *Handler) startAuthentication() {
 		return
 	}
 
+	c.authMu.Lock()
 	c.authenticated = respPacket.Authenticated
+	c.authMu.Unlock()
 	c.permissions = permission.GetPermissions(respPacket.Permitted)
 	c.ctx = permission.ContextWithPermissions(c.ctx, c.permissions)
 }
 
+// settleAuthentication records the result of the authentication of the
+// remote peer and releases the packets waiting for it. Only the first result
+// is recorded.
+func (c *Handler) settleAuthentication(resp AuthenticationResponsePacket) {
+	c.authOnce.Do(func() {
+		c.authMu.Lock()
+		c.claims = resp.claims
+		c.authenticated = resp.Authenticated
+		c.authenticationError = resp.Error
+		c.authMu.Unlock()
+		if resp.Authenticated {
+			c.tokenID.Store(resp.tokenID)
+		}
+		if resp.Authenticated && c.tokenRateLimits != nil {
+			// Tokens are hashed so that they aren't kept in memory for
+			// longer than the connection.
+			key := sha256.Sum256([]byte(resp.token))
+			c.tokenRateLimiter.Store(c.tokenRateLimits.Get(hex.EncodeToString(key[:])))
+		}
+		close(c.authSettled)
+	})
+}
+
 func (c *Handler) startOutput(logPackets bool) {
 	defer c.wg.Done()
 
@@ -270,16 +927,41 @@ func (c *Handler) startOutput(logPackets bool) {
 			break
 		}
 
-		// check if output is authentication response
-		if authResPacket, isAuthRespPacket := operation.packet.(AuthenticationResponsePacket); isAuthRespPacket {
-			c.authenticated = authResPacket.Authenticated
-			c.authenticationError = authResPacket.Error
-			c.authWg.Done()
+		// Requests and streams are registered before the packet is written so
+		// that no response can arrive before they are ready to receive it.
+		id := c.codec.NextID()
+		if operation.stream != nil {
+			operation.stream.id = id
+			c.streams.Store(id, operation.stream)
+		}
+
+		var err error
+		var registered bool
+		if operation.respChan != nil {
+			err = c.pending.add(id, pendingRequest{
+				respChan: operation.respChan,
+				errChan:  operation.errChan,
+			})
+			registered = err == nil
 		}
 
-		id, err := c.codec.Write(operation.callerId, operation.packet)
-		operation.idChan <- id
+		if err == nil {
+			err = c.codec.WriteID(id, operation.callerId, operation.packet)
+		}
+		if operation.idChan != nil {
+			operation.idChan <- id
+		}
 		if err != nil {
+			if operation.stream != nil {
+				c.streams.Delete(id)
+			}
+			// Requests that were failed when the connection closed have
+			// already received an error.
+			if registered {
+				if _, ok := c.pending.remove(id); !ok {
+					continue
+				}
+			}
 			operation.errChan <- err
 			continue
 		}
@@ -290,23 +972,25 @@ func (c *Handler) startOutput(logPackets bool) {
 			}
 		}
 
-		if operation.respChan != nil {
-			responseHandlers.Store(id, operation.respChan)
-		}
-
-		if operation.errChan != nil {
-			errorHandlers.Store(id, operation.errChan)
+		// Operations that don't wait for a response are complete once the
+		// packet has been written.
+		if operation.respChan == nil && operation.errChan != nil {
+			operation.errChan <- nil
 		}
 	}
 }
 
 func (c *Handler) startInput(logPackets bool) {
 	defer c.wg.Done()
-	defer close(c.output)
+	defer c.closeOutput()
+
+	// No more responses can be received once the input handler stops.
+	defer c.pending.close(ErrConnectionClosed)
 
-	// Set the initial read deadline to 10 seconds
-	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	// Set the initial read deadline
+	c.resetReadDeadline()
 
+	var decodeFailures int
 	for {
 		// Read and decode the incoming ProtocolPacket
 		packet, err := c.codec.Read()
@@ -330,13 +1014,39 @@ func (c *Handler) startInput(logPackets bool) {
 				logger.Printf("Error reading packet: %s\n", err)
 			}
 
+			// The rest of a packet that violates the framing protocol can't
+			// be skipped, so the remote peer is told why and the connection
+			// is closed.
+			var frameErr *FrameError
+			if errors.As(err, &frameErr) {
+				err = c.respond(response{
+					CallerID: frameErr.PacketID,
+					Packet:   NewErrorPacket(fmt.Errorf("%w: %w", coattailtypes.ErrInvalidRequest, frameErr.Err)),
+				})
+				if err != nil {
+					if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+						logger.Printf("Error writing response packet: %s\n", err)
+					}
+				}
+				return
+			}
+
 			// If we failed to decode a packet, try again with the next packet
-			// TODO: Implement rate limiting.
+			// unless the remote peer keeps sending packets that can't be
+			// decoded.
+			decodeFailures++
+			if c.maxDecodeFailures > 0 && decodeFailures >= c.maxDecodeFailures {
+				if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+					logger.Printf("Closing connection after %d consecutive packets failed to decode.\n", decodeFailures)
+				}
+				return
+			}
 			continue
 		}
+		decodeFailures = 0
 
 		// Reset the deadline after successful read & process
-		c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		c.resetReadDeadline()
 
 		// print the packet
 		if logPackets {
@@ -345,22 +1055,87 @@ func (c *Handler) startInput(logPackets bool) {
 			}
 		}
 
-		// Process the Packet in a new goroutine
-		go func() {
+		// Stream packets are dispatched in the order that they were received.
+		if c.dispatchStream(packet) {
+			continue
+		}
+
+		// Responses are delivered to the request that is waiting for them.
+		if packet.RespondingTo != 0 && c.pending.deliver(packet.RespondingTo, packet.Data) {
+			continue
+		}
+
+		// Cancellation is handled here since the Handler owns the contexts of
+		// the packets being handled, and so that it doesn't wait for a
+		// worker.
+		if cancelPacket, isCancelPacket := packet.Data.(CancelPacket); isCancelPacket {
+			c.cancelInflight(cancelPacket.RequestID)
+			continue
+		}
+
+		// A peer that says goodbye is about to close the connection, so no
+		// new requests are sent on it.
+		if _, isGoodbyePacket := packet.Data.(GoodbyePacket); isGoodbyePacket {
+			if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+				logger.Println("Peer said goodbye.")
+			}
+			c.goodbye.Store(true)
+			continue
+		}
+
+		// Packets that exceed a rate limit are rejected before they are
+		// handled. The response is written before the next packet is read so
+		// that a peer sending too many packets is slowed down.
+		if err := c.allow(packet); err != nil {
+			c.reject(packet, err)
+			continue
+		}
+
+		// A connection is only authenticated once, so that the result of its
+		// authentication can't change while packets are being handled.
+		_, isAuthPacket := packet.Data.(AuthenticationPacket)
+		isAuthPacket = isAuthPacket && c.inputRole == InputRoleServer
+		if isAuthPacket && !c.authAttempted.CompareAndSwap(false, true) {
+			c.reject(packet, ErrAlreadyAuthenticated)
+			continue
+		}
+
+		// The packets waiting for the authentication of the connection are
+		// released however the handling of its authentication packet ends.
+		reject := func(reason error) {
+			c.reject(packet, reason)
+			if isAuthPacket {
+				c.settleAuthentication(AuthenticationResponsePacket{Error: reason.Error()})
+			}
+		}
+
+		// Packets can be cancelled from the moment that they are received,
+		// and the time that the caller is willing to wait is counted from
+		// then, so that the clocks of the peers don't need to agree.
+		received := time.Now()
+		request := &inflightRequest{}
+
+		// Process the Packet on a worker
+		handle := func() {
+			if isAuthPacket {
+				defer c.settleAuthentication(AuthenticationResponsePacket{Error: ErrAuthenticationIncomplete.Error()})
+			}
+
 			// Make sure that either the connection is authenticated, or that
 			// the packet is an authentication packet.
 			if c.inputRole == InputRoleServer {
-				if !c.authenticated { // Should only be run on the host since authenticated is always true on the clients
-					if _, isAuthPacket := packet.Data.(AuthenticationPacket); !isAuthPacket {
-						c.authWg.Wait()
-						if !c.authenticated {
-							logger, _ := logging.GetLogger(c.Context())
-							packetName := reflect.TypeOf(packet.Data).Name()
-							logger.Printf("Authentication failed for packet %v (responding to: %v)\n", packetName, packet.RespondingTo)
-							err = c.respond(response{
+				if authenticated, _, _ := c.authResult(); !authenticated { // Should only be run on the host since authenticated is always true on the clients
+					if !isAuthPacket {
+						<-c.authSettled
+						if authenticated, _, authErr := c.authResult(); !authenticated {
+							if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+								packetName := reflect.TypeOf(packet.Data).Name()
+								logger.Printf("Authentication failed for packet %v (responding to: %v)\n", packetName, packet.RespondingTo)
+							}
+							err := c.respond(response{
 								CallerID: packet.ID,
 								Packet: AuthenticationInvalidPacket{
-									Error: fmt.Sprintf("authentication failed: %s", c.authenticationError),
+									Error: fmt.Sprintf("authentication failed: %s", authErr),
 								},
 							})
 							if err != nil {
@@ -374,44 +1149,96 @@ func (c *Handler) startInput(logPackets bool) {
 				}
 			}
 
-			// Should only have an impact on the client since the client doesn't send this packet type.
-			if c.inputRole == InputRoleClient {
-				if authInvalidPacket, isAuthInvalidPacket := packet.Data.(AuthenticationInvalidPacket); isAuthInvalidPacket {
-					if _, ok := responseHandlers.Load(packet.RespondingTo); !ok {
-						if errChan, ok := errorHandlers.Load(packet.RespondingTo); ok {
-							errChan.(chan error) <- errors.New(authInvalidPacket.Error)
+			// Make sure that the claims of the connection authorize the packet.
+			if c.inputRole == InputRoleServer {
+				if authorized, isAuthorizedPacket := packet.Data.(authorizedPacket); isAuthorizedPacket {
+					_, claims, _ := c.authResult()
+					if err := authorized.authorize(claims); err != nil {
+						if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+							logger.Printf("Authorization denied for packet %T: %s\n", packet.Data, err)
+						}
 
-							// Clean up the response handlers if any
-							if _, hasResponseHandler := responseHandlers.Load(packet.RespondingTo); hasResponseHandler {
-								responseHandlers.Delete(packet.RespondingTo)
-							}
+						var unauthorizedErr *authentication.UnauthorizedError
+						reason := err.Error()
+						if errors.As(err, &unauthorizedErr) {
+							reason = unauthorizedErr.Reason
+						}
 
-							// Delete the error handler after it has been used
-							errorHandlers.Delete(packet.RespondingTo)
+						err = c.respond(response{
+							CallerID: packet.ID,
+							Packet: AuthorizationDeniedPacket{
+								Reason: reason,
+							},
+						})
+						if err != nil {
+							if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+								logger.Printf("Error writing response packet: %s\n", err)
+							}
 						}
+						return
 					}
 				}
 			}
 
-			// If this is a response packet, load the response handler for the
-			// original packet (if any) and send it the response.
-			if packet.RespondingTo != 0 {
-				if respChan, ok := responseHandlers.Load(packet.RespondingTo); ok {
-					respChan.(chan any) <- packet.Data
-					// Delete the response handler after it has been used
-					responseHandlers.Delete(packet.RespondingTo)
-					return
+			// Handle the packet. The context is cancelled if the remote peer
+			// sends a CancelPacket for it.
+			baseCtx := context.WithValue(c.ctx, keys.ConnectionKey, c.conn)
+			if c.inputRole == InputRoleServer {
+				baseCtx = context.WithValue(baseCtx, keys.ChallengeKey, authPolicy{
+					challenge:         c.challenge,
+					allowBearerTokens: c.allowBearerTokens,
+				})
+				_, claims, _ := c.authResult()
+				baseCtx = authentication.ContextWithClaims(baseCtx, claims)
+			}
+
+			// Packets that start a stream send their results through a
+			// streamSender.
+			if streaming, isStreaming := packet.Data.(streamingPacket); isStreaming && streaming.streaming() && c.HasFeature(FeatureStreaming) {
+				sender := newStreamSender(c, packet.ID)
+				c.senders.Store(packet.ID, sender)
+				defer c.senders.Delete(packet.ID)
+				baseCtx = context.WithValue(baseCtx, keys.StreamKey, sender)
+			}
+
+			handleCtx, cancel := context.WithCancelCause(baseCtx)
+			if !request.start(cancel) {
+				cancel(nil)
+				if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+					logger.Printf("Packet %T[%d] cancelled by remote peer before it was handled\n", packet.Data, packet.ID)
 				}
+				return
+			}
+			if timed, isTimed := packet.Data.(timedPacket); isTimed && timed.timeout() > 0 {
+				var cancelTimeout context.CancelFunc
+				handleCtx, cancelTimeout = context.WithDeadline(handleCtx, received.Add(timed.timeout()))
+				defer cancelTimeout()
+			}
+
+			resp, err := packet.Data.(coattailtypes.Packet).Handle(handleCtx)
+			cancelled := errors.Is(context.Cause(handleCtx), ErrRequestCancelled)
+			cancel(nil)
+
+			if authResp, isAuthResp := resp.(AuthenticationResponsePacket); isAuthPacket && isAuthResp {
+				c.settleAuthentication(authResp)
+			}
+
+			// The remote peer is no longer waiting for a response.
+			if cancelled {
+				if logger, _ := logging.GetLogger(c.Context()); logger != nil {
+					logger.Printf("Packet %T[%d] cancelled by remote peer\n", packet.Data, packet.ID)
+				}
+				return
 			}
 
-			// Handle the packet.
-			resp, err := packet.Data.(coattailtypes.Packet).Handle(
-				context.WithValue(c.ctx, keys.ConnectionKey, c.conn),
-			)
 			if err != nil {
 				if logger, _ := logging.GetLogger(c.Context()); logger != nil {
 					logger.Printf("Error executing packet: %s\n", err)
 				}
+
+				// Report the error to the remote peer so that it doesn't
+				// have to wait for a response that will never arrive.
+				resp = NewErrorPacket(err)
 			}
 
 			// If the packet handler returned a response, send it back to the
@@ -421,12 +1248,59 @@ func (c *Handler) startInput(logPackets bool) {
 					CallerID: packet.ID,
 					Packet:   resp,
 				})
+
+				// Let the remote peer know why it won't receive a response
+				// that is too large for it to accept.
+				if errors.Is(err, ErrPacketTooLarge) {
+					err = c.respond(response{
+						CallerID: packet.ID,
+						Packet:   NewErrorPacket(err),
+					})
+				}
+
 				if err != nil {
 					if logger, _ := logging.GetLogger(c.Context()); logger != nil {
 						logger.Printf("Error writing response packet: %s\n", err)
 					}
 				}
 			}
-		}()
+		}
+
+		// Requests are counted while they are handled so that Shutdown can
+		// wait for them, and are rejected once the Handler is shutting down.
+		// Heartbeats are still answered.
+		_, isPingPacket := packet.Data.(PingPacket)
+		if !isPingPacket {
+			c.shutdownMu.RLock()
+			if c.shuttingDown {
+				c.shutdownMu.RUnlock()
+				reject(coattailtypes.ErrShuttingDown)
+				continue
+			}
+			c.handling.Add(1)
+			c.shutdownMu.RUnlock()
+		}
+		c.inflight.Store(packet.ID, request)
+		done := func() {
+			c.inflight.Delete(packet.ID)
+			if !isPingPacket {
+				c.handling.Done()
+			}
+		}
+
+		// Packets are handled by the workers of the connection and then by
+		// the workers of the host, so that a single peer can't take every
+		// worker of the host. Packets that can't be queued in time are
+		// rejected.
+		err = c.workers.Submit(func() {
+			defer done()
+			if err := c.hostWorkers.Run(handle, c.queueTimeout); err != nil {
+				reject(fmt.Errorf("%w: host %w", coattailtypes.ErrOverloaded, err))
+			}
+		}, c.queueTimeout)
+		if err != nil {
+			reject(fmt.Errorf("%w: connection %w", coattailtypes.ErrOverloaded, err))
+			done()
+		}
 	}
 }
@@ -0,0 +1,693 @@
+package packets_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/ratelimit"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/workerpool"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// connect returns a client Handler created with cfg connected to a server that
+// completes the handshake and then leaves the packets it receives to the
+// caller, along with the server end of the connection. Heartbeats are
+// disabled.
+func connect(t *testing.T, cfg packets.HandlerConfig) (*packets.Handler, *packets.StreamCodec, net.Conn) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	serverReady := make(chan *packets.StreamCodec)
+	go func() {
+		server, err := packets.NewHandler(context.Background(), serverConn, packets.InputRoleServer, packets.HandlerConfig{})
+		if err != nil {
+			t.Error(err)
+			close(serverReady)
+			return
+		}
+		serverReady <- server.Session().NewStreamCodec(serverConn, packets.FrameConfig{})
+	}()
+
+	ctx := context.WithValue(context.Background(), keys.AuthenticationKey, "token")
+	cfg.KeepaliveInterval = -1
+	client, err := packets.NewHandler(ctx, clientConn, packets.InputRoleClient, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := <-serverReady
+	if server == nil {
+		t.FailNow()
+	}
+
+	client.HandlePackets(false)
+
+	return client, server, serverConn
+}
+
+func TestRequestCancelledByContext(t *testing.T) {
+	client, server, _ := connect(t, packets.HandlerConfig{})
+
+	cancelled := make(chan packets.CancelPacket)
+	actionIDs := make(chan uint64, 1)
+	go func() {
+		for {
+			p, err := server.Read()
+			if err != nil {
+				return
+			}
+
+			switch data := p.Data.(type) {
+			case packets.ActionPacket:
+				if data.Timeout <= 0 {
+					t.Errorf("expected the action packet to carry the caller's timeout")
+				}
+				actionIDs <- p.ID
+			case packets.CancelPacket:
+				cancelled <- data
+				return
+			}
+		}
+	}()
+
+	requestCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Request(requestCtx, packets.Request{
+		Packet: packets.ActionPacket{
+			Type:    packets.ActionPacketTypePerform,
+			Action:  "Slow",
+			Timeout: 100 * time.Millisecond,
+		},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case packet := <-cancelled:
+		if id := <-actionIDs; packet.RequestID != id {
+			t.Errorf("expected cancel for request %d, got %d", id, packet.RequestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a cancel packet to be sent")
+	}
+}
+
+func TestStream(t *testing.T) {
+	client, server, _ := connect(t, packets.HandlerConfig{})
+
+	const results = packets.StreamWindow + 1
+
+	credits := make(chan packets.StreamCreditPacket, 1)
+	go func() {
+		var streamID uint64
+		for {
+			p, err := server.Read()
+			if err != nil {
+				return
+			}
+
+			switch data := p.Data.(type) {
+			case packets.ActionPacket:
+				if data.Type != packets.ActionPacketTypeStream {
+					t.Errorf("expected a stream action packet, got type %d", data.Type)
+				}
+				streamID = p.ID
+
+				// Use up the stream window.
+				for i := 0; i < packets.StreamWindow; i++ {
+					server.Write(streamID, packets.StreamItemPacket{Data: i})
+				}
+			case packets.StreamCreditPacket:
+				credits <- data
+				server.Write(streamID, packets.StreamItemPacket{Data: packets.StreamWindow})
+				server.Write(streamID, packets.StreamEndPacket{})
+			}
+		}
+	}()
+
+	stream, err := client.Stream(context.Background(), packets.ActionPacket{
+		Type:   packets.ActionPacketTypeStream,
+		Action: "Count",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for i := 0; i < results; i++ {
+		item, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("expected result %d, got error %v", i, err)
+		}
+		if item != i {
+			t.Errorf("expected result %d, got %v", i, item)
+		}
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+
+	stream.Close()
+	if _, err := stream.Recv(); !errors.Is(err, coattailtypes.ErrStreamClosed) {
+		t.Errorf("expected %v once closed, got %v", coattailtypes.ErrStreamClosed, err)
+	}
+
+	select {
+	case credit := <-credits:
+		if credit.Credits != packets.StreamWindow/2 {
+			t.Errorf("expected %d credits, got %d", packets.StreamWindow/2, credit.Credits)
+		}
+	default:
+		t.Errorf("expected credits to be granted")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	_, server, _ := connect(t, packets.HandlerConfig{
+		RateLimiter: ratelimit.NewLimiter(1, 1),
+	})
+
+	var ids []uint64
+	for i := 0; i < 2; i++ {
+		id, err := server.Write(0, packets.ListUnitsPacket{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for {
+		p, err := server.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.RespondingTo != ids[1] {
+			continue
+		}
+
+		errPacket, ok := p.Data.(packets.ErrorPacket)
+		if !ok {
+			t.Fatalf("expected an error packet, got %T", p.Data)
+		}
+		if errPacket.Code != coattailtypes.ErrorCodeRateLimited {
+			t.Errorf("expected code %s, got %s", coattailtypes.ErrorCodeRateLimited, errPacket.Code)
+		}
+		if errPacket.Details["retry_after"] == "" {
+			t.Errorf("expected the error to say when to retry")
+		}
+		return
+	}
+}
+
+// undecodablePacket is not registered, so it can be encoded but not decoded.
+type undecodablePacket struct{}
+
+func (undecodablePacket) Handle(context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
+
+func TestMaxDecodeFailures(t *testing.T) {
+	_, server, _ := connect(t, packets.HandlerConfig{
+		Codecs:            []string{packets.CodecJSON},
+		MaxDecodeFailures: 3,
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := server.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if _, err := server.Write(0, undecodablePacket{}); err != nil {
+			t.Fatalf("expected packet %d to be written, got %v", i, err)
+		}
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the connection to be closed")
+	}
+}
+
+func TestResponsesDeliveredPerConnection(t *testing.T) {
+	type connection struct {
+		client *packets.Handler
+		server *packets.StreamCodec
+		action string
+	}
+
+	connections := []connection{}
+	for _, action := range []string{"First", "Second"} {
+		client, server, _ := connect(t, packets.HandlerConfig{})
+		connections = append(connections, connection{client, server, action})
+	}
+
+	// Both requests are sent once the clients have sent their authentication
+	// packets, and before either is answered, so that they are pending at the
+	// same time with the same packet IDs.
+	requestIDs := make([]chan uint64, len(connections))
+	authenticating := make(chan struct{}, len(connections))
+	for i, conn := range connections {
+		requestIDs[i] = make(chan uint64, 1)
+		go func(server *packets.StreamCodec, ids chan uint64) {
+			for {
+				p, err := server.Read()
+				if err != nil {
+					return
+				}
+
+				switch p.Data.(type) {
+				case packets.AuthenticationPacket:
+					authenticating <- struct{}{}
+				case packets.ActionPacket:
+					ids <- p.ID
+				}
+			}
+		}(conn.server, requestIDs[i])
+	}
+
+	for range connections {
+		<-authenticating
+	}
+
+	results := make([]chan any, len(connections))
+	for i, conn := range connections {
+		results[i] = make(chan any, 1)
+		go func(conn connection, result chan any) {
+			resp, err := conn.client.Request(context.Background(), packets.Request{
+				Packet: packets.ActionPacket{
+					Type:   packets.ActionPacketTypePerform,
+					Action: conn.action,
+				},
+			})
+			if err != nil {
+				result <- err
+				return
+			}
+			result <- resp.(packets.ActionResponsePacket).ResponseData
+		}(conn, results[i])
+	}
+
+	ids := make([]uint64, len(connections))
+	for i := range connections {
+		ids[i] = <-requestIDs[i]
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("expected both requests to have the same packet ID, got %d and %d", ids[0], ids[1])
+	}
+
+	for i, conn := range connections {
+		conn.server.Write(ids[i], packets.ActionResponsePacket{
+			Action:       conn.action,
+			ResponseData: conn.action,
+		})
+	}
+
+	for i, conn := range connections {
+		if result := <-results[i]; result != conn.action {
+			t.Errorf("expected response %q, got %v", conn.action, result)
+		}
+	}
+}
+
+func TestPendingRequestsFailOnClose(t *testing.T) {
+	client, server, serverConn := connect(t, packets.HandlerConfig{})
+
+	go func() {
+		for {
+			p, err := server.Read()
+			if err != nil {
+				return
+			}
+
+			if _, ok := p.Data.(packets.ActionPacket); ok {
+				serverConn.Close()
+				return
+			}
+		}
+	}()
+
+	_, err := client.Request(context.Background(), packets.Request{
+		Packet: packets.ActionPacket{
+			Type:   packets.ActionPacketTypePerform,
+			Action: "Slow",
+		},
+	})
+	if !errors.Is(err, packets.ErrConnectionClosed) {
+		t.Errorf("expected %s, got %v", packets.ErrConnectionClosed, err)
+	}
+}
+
+func TestOverloaded(t *testing.T) {
+	hostWorkers := workerpool.New(1, 0)
+	defer hostWorkers.Close()
+
+	// Keep the only worker of the host busy.
+	release := make(chan struct{})
+	defer close(release)
+	if err := hostWorkers.Submit(func() { <-release }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	_, server, _ := connect(t, packets.HandlerConfig{
+		QueueTimeout: 10 * time.Millisecond,
+		HostWorkers:  hostWorkers,
+	})
+
+	id, err := server.Write(0, packets.ListUnitsPacket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		p, err := server.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.RespondingTo != id {
+			continue
+		}
+
+		errPacket, ok := p.Data.(packets.ErrorPacket)
+		if !ok {
+			t.Fatalf("expected an error packet, got %T", p.Data)
+		}
+		if errPacket.Code != coattailtypes.ErrorCodeOverloaded {
+			t.Errorf("expected code %s, got %s", coattailtypes.ErrorCodeOverloaded, errPacket.Code)
+		}
+		if stats := hostWorkers.Stats(); stats.Rejected != 1 {
+			t.Errorf("expected 1 rejected packet, got %d", stats.Rejected)
+		}
+		return
+	}
+}
+
+func TestCancelQueued(t *testing.T) {
+	hostWorkers := workerpool.New(1, 1)
+	defer hostWorkers.Close()
+
+	// Keep the only worker of the host busy so that the request waits in
+	// the queue.
+	release := make(chan struct{})
+	if err := hostWorkers.Submit(func() { <-release }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	_, server, _ := connect(t, packets.HandlerConfig{
+		QueueTimeout: 5 * time.Second,
+		HostWorkers:  hostWorkers,
+	})
+
+	id, err := server.Write(0, packets.ListUnitsPacket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hostWorkers.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := server.Write(0, packets.CancelPacket{RequestID: id}); err != nil {
+		t.Fatal(err)
+	}
+	ping, err := server.Write(0, packets.PingPacket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	// The request is not handled, so nothing is sent in response to it
+	// before the heartbeat that was queued after it is answered.
+	for {
+		p, err := server.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch p.RespondingTo {
+		case id:
+			t.Fatalf("expected the cancelled request not to be handled, got %T{%v}", p.Data, p.Data)
+		case ping:
+			return
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	hostWorkers := workerpool.New(1, 1)
+	defer hostWorkers.Close()
+
+	// Keep the only worker of the host busy so that the first request is
+	// still being handled when the Handler shuts down.
+	release := make(chan struct{})
+	if err := hostWorkers.Submit(func() { <-release }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server, _ := connect(t, packets.HandlerConfig{
+		QueueTimeout: 5 * time.Second,
+		HostWorkers:  hostWorkers,
+	})
+
+	first, err := server.Write(0, packets.ListUnitsPacket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hostWorkers.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- client.Shutdown(ctx)
+	}()
+
+	// read returns the next packet that isn't part of the handshake.
+	read := func() packets.EncodedPacket {
+		for {
+			p, err := server.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, isAuthPacket := p.Data.(packets.AuthenticationPacket); !isAuthPacket {
+				return p
+			}
+		}
+	}
+
+	if p := read(); p.Data != (packets.GoodbyePacket{}) {
+		t.Fatalf("expected a goodbye packet, got %T", p.Data)
+	}
+
+	second, err := server.Write(0, packets.ListUnitsPacket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := read()
+	errPacket, ok := p.Data.(packets.ErrorPacket)
+	if p.RespondingTo != second || !ok || errPacket.Code != coattailtypes.ErrorCodeShuttingDown {
+		t.Fatalf("expected the request to be rejected with %s, got %T{%v}", coattailtypes.ErrorCodeShuttingDown, p.Data, p.Data)
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected shutdown to wait for the first request, got %v", err)
+	default:
+	}
+
+	close(release)
+	if p := read(); p.RespondingTo != first {
+		t.Errorf("expected a response to the first request, got a response to %d", p.RespondingTo)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("expected shutdown to succeed, got %v", err)
+	}
+	if _, err := server.Read(); err == nil {
+		t.Errorf("expected the connection to be closed")
+	}
+}
+
+func TestRepeatedAuthentication(t *testing.T) {
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	ctx, err := authentication.ContextWithService(context.Background(), authentication.ServiceConfig{
+		KeyringFile: filepath.Join(t.TempDir(), "keyring.yaml"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := authentication.GetService(ctx)
+	token, err := auth.Issue(ctx, authentication.Claims{
+		AuthorizedNetwork: *network,
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		server, err := packets.NewHandler(ctx, conn, packets.InputRoleServer, packets.HandlerConfig{KeepaliveInterval: -1})
+		if err != nil {
+			return
+		}
+		server.HandlePackets(false)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The client is driven by hand so that it can authenticate twice.
+	handler, err := packets.NewHandler(context.Background(), conn, packets.InputRoleClient, packets.HandlerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := handler.Session().NewStreamCodec(conn, packets.FrameConfig{})
+
+	claims, _ := authentication.SplitToken(token.String())
+	authenticate := func() any {
+		t.Helper()
+
+		id, err := client.Write(0, packets.AuthenticationPacket{
+			Claims: claims,
+			Proof:  token.Prove(handler.Session().Challenge),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			p, err := client.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.RespondingTo == id {
+				return p.Data
+			}
+		}
+	}
+
+	if resp, ok := authenticate().(packets.AuthenticationResponsePacket); !ok || !resp.Authenticated {
+		t.Fatalf("expected the client to authenticate, got %+v", resp)
+	}
+
+	resp, ok := authenticate().(packets.ErrorPacket)
+	if !ok || resp.Message != packets.ErrAlreadyAuthenticated.Error() {
+		t.Errorf("expected the second authentication to be rejected with %v, got %+v", packets.ErrAlreadyAuthenticated, resp)
+	}
+}
+
+func TestAuthenticationOverloaded(t *testing.T) {
+	hostWorkers := workerpool.New(1, 0)
+	defer hostWorkers.Close()
+
+	// Keep the only worker of the host busy so that the authentication
+	// packet is rejected.
+	release := make(chan struct{})
+	if err := hostWorkers.Submit(func() { <-release }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	serverReady := make(chan *packets.Handler)
+	go func() {
+		server, err := packets.NewHandler(context.Background(), serverConn, packets.InputRoleServer, packets.HandlerConfig{
+			KeepaliveInterval: -1,
+			QueueTimeout:      10 * time.Millisecond,
+			HostWorkers:       hostWorkers,
+		})
+		if err != nil {
+			t.Error(err)
+			close(serverReady)
+			return
+		}
+		serverReady <- server
+	}()
+
+	// The client is driven by hand so that it can send requests after its
+	// authentication failed.
+	handler, err := packets.NewHandler(context.Background(), clientConn, packets.InputRoleClient, packets.HandlerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-serverReady
+	if server == nil {
+		t.FailNow()
+	}
+	server.HandlePackets(false)
+
+	client := handler.Session().NewStreamCodec(clientConn, packets.FrameConfig{})
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	request := func(packet coattailtypes.Packet) any {
+		t.Helper()
+
+		id, err := client.Write(0, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			p, err := client.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.RespondingTo == id {
+				return p.Data
+			}
+		}
+	}
+
+	resp, ok := request(packets.AuthenticationPacket{Token: "token"}).(packets.ErrorPacket)
+	if !ok || resp.Code != coattailtypes.ErrorCodeOverloaded {
+		t.Fatalf("expected the authentication to be rejected as overloaded, got %+v", resp)
+	}
+	close(release)
+
+	if resp, ok := request(packets.ListUnitsPacket{}).(packets.AuthenticationInvalidPacket); !ok {
+		t.Errorf("expected the request to be rejected as unauthenticated, got %+v", resp)
+	}
+}
@@ -0,0 +1,375 @@
+package packets
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/version"
+	"github.com/samber/lo"
+)
+
+const (
+	// ProtocolVersion is the newest version of the wire protocol supported by
+	// this implementation.
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest version of the wire protocol supported
+	// by this implementation.
+	MinProtocolVersion = 1
+)
+
+// maxHelloSize is the maximum size of a hello message in bytes.
+const maxHelloSize = 4096
+
+var (
+	ErrHelloTooLarge              = errors.New("hello message too large")
+	ErrNoCommonCodec              = errors.New("no common codec")
+	ErrUnsupportedProtocolVersion = errors.New("unsupported protocol version")
+)
+
+// Feature is an optional protocol feature. A feature is only used on a
+// connection if both peers support it.
+type Feature string
+
+const (
+	// FeatureStreaming allows actions to stream multiple results back to
+	// the caller.
+	FeatureStreaming Feature = "streaming"
+	// FeatureGoodbye allows a peer to say goodbye before it closes the
+	// connection, so that no new requests are sent on it.
+	FeatureGoodbye Feature = "goodbye"
+	// FeatureChallenge authenticates the client with a proof that it holds
+	// its token, computed over a challenge sent by the server and the TLS
+	// channel binding of the connection, instead of the token itself.
+	FeatureChallenge Feature = "challenge"
+)
+
+// DefaultFeatures are the features enabled on a Handler when none are
+// configured.
+var DefaultFeatures = []Feature{
+	FeatureStreaming,
+	FeatureGoodbye,
+	FeatureChallenge,
+}
+
+// challengeSize is the size in bytes of the challenge sent by the server.
+const challengeSize = 32
+
+// ClientHello is sent by the client when a connection is established. It is
+// written as a single line of JSON before any packets so that it can be read
+// regardless of the codec that is eventually used for the connection.
+type ClientHello struct {
+	// ProtocolVersion is the newest protocol version supported by the client.
+	ProtocolVersion int `json:"protocol_version"`
+	// FrameworkVersion is the version of Coattail used by the client.
+	FrameworkVersion string `json:"framework_version"`
+	// Codecs are the codecs supported by the client in order of preference.
+	Codecs []string `json:"codecs"`
+	// Features are the optional features supported by the client.
+	Features []Feature `json:"features,omitempty"`
+	// Compression are the compression algorithms supported by the client in
+	// order of preference.
+	Compression []string `json:"compression,omitempty"`
+	// MaxFrameSize is the maximum size of a frame accepted by the client.
+	MaxFrameSize int `json:"max_frame_size,omitempty"`
+	// MaxPacketSize is the maximum size of a packet accepted by the client.
+	MaxPacketSize int `json:"max_packet_size,omitempty"`
+}
+
+// ServerHello is sent by the server in response to a ClientHello.
+type ServerHello struct {
+	// ProtocolVersion is the protocol version that will be used for the
+	// connection.
+	ProtocolVersion int `json:"protocol_version"`
+	// FrameworkVersion is the version of Coattail used by the server.
+	FrameworkVersion string `json:"framework_version"`
+	// Codec is the codec that will be used for the connection.
+	Codec string `json:"codec,omitempty"`
+	// Features are the optional features that will be used for the
+	// connection.
+	Features []Feature `json:"features,omitempty"`
+	// Compression is the compression algorithm that will be used for the
+	// connection, if any.
+	Compression string `json:"compression,omitempty"`
+	// MaxFrameSize is the maximum size of a frame accepted by the server.
+	MaxFrameSize int `json:"max_frame_size,omitempty"`
+	// MaxPacketSize is the maximum size of a packet accepted by the server.
+	MaxPacketSize int `json:"max_packet_size,omitempty"`
+	// Challenge is a random nonce that the client must authenticate with if
+	// FeatureChallenge is enabled.
+	Challenge []byte `json:"challenge,omitempty"`
+	// Error is set if the server rejected the connection.
+	Error string `json:"error,omitempty"`
+}
+
+// Session describes what was negotiated with the remote peer during the
+// handshake.
+type Session struct {
+	// ProtocolVersion is the protocol version used for the connection.
+	ProtocolVersion int
+	// RemoteFrameworkVersion is the version of Coattail used by the remote
+	// peer.
+	RemoteFrameworkVersion string
+	// Codec is the codec used for the connection.
+	Codec Codec
+	// Features are the optional features enabled for the connection.
+	Features []Feature
+	// Compression is the compressor used for the connection, or nil if the
+	// connection is not compressed.
+	Compression Compressor
+	// RemoteLimits are the limits on the size of the frames and packets
+	// accepted by the remote peer.
+	RemoteLimits FrameLimits
+	// Challenge is the nonce sent by the server that the client
+	// authenticates with if FeatureChallenge is enabled.
+	Challenge []byte
+}
+
+// HasFeature returns true if the feature is enabled for the connection.
+func (s Session) HasFeature(feature Feature) bool {
+	return lo.Contains(s.Features, feature)
+}
+
+// NewStreamCodec returns the StreamCodec used to transfer packets over a
+// connection with the session.
+func (s Session) NewStreamCodec(rw io.ReadWriter, cfg FrameConfig) *StreamCodec {
+	if s.ProtocolVersion < FramedProtocolVersion {
+		return NewStreamCodec(rw, s.Codec)
+	}
+
+	return NewFramedStreamCodec(rw, s.Codec, s.Compression, cfg, s.RemoteLimits)
+}
+
+type handshakeConfig struct {
+	codecs      []string
+	features    []Feature
+	compression []string
+	limits      FrameLimits
+}
+
+// clientHandshake sends a ClientHello to the server and returns the session
+// that the server negotiated.
+func clientHandshake(rw io.ReadWriter, cfg handshakeConfig) (Session, error) {
+	err := writeHello(rw, ClientHello{
+		ProtocolVersion:  ProtocolVersion,
+		FrameworkVersion: version.Framework(),
+		Codecs:           cfg.codecs,
+		Features:         cfg.features,
+		Compression:      cfg.compression,
+		MaxFrameSize:     cfg.limits.MaxFrameSize,
+		MaxPacketSize:    cfg.limits.MaxPacketSize,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	var hello ServerHello
+	if err := readHello(rw, &hello); err != nil {
+		return Session{}, err
+	}
+
+	if hello.Error != "" {
+		return Session{}, fmt.Errorf("connection rejected by server (framework version %s): %s", hello.FrameworkVersion, hello.Error)
+	}
+
+	if hello.ProtocolVersion < MinProtocolVersion || hello.ProtocolVersion > ProtocolVersion {
+		return Session{}, fmt.Errorf("%w: server selected protocol version %d, client supports %d-%d", ErrUnsupportedProtocolVersion, hello.ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	}
+
+	if !lo.Contains(cfg.codecs, hello.Codec) {
+		return Session{}, fmt.Errorf("server selected unsupported codec %s", hello.Codec)
+	}
+
+	codec, err := GetCodec(hello.Codec)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if unsupported, _ := lo.Difference(hello.Features, cfg.features); len(unsupported) > 0 {
+		return Session{}, fmt.Errorf("server selected unsupported features %v", unsupported)
+	}
+
+	if lo.Contains(hello.Features, FeatureChallenge) && len(hello.Challenge) < challengeSize {
+		return Session{}, fmt.Errorf("server sent a challenge of %d bytes, expected %d", len(hello.Challenge), challengeSize)
+	}
+
+	var compressor Compressor
+	if hello.Compression != "" {
+		if hello.ProtocolVersion < FramedProtocolVersion || !lo.Contains(cfg.compression, hello.Compression) {
+			return Session{}, fmt.Errorf("server selected unsupported compression %s", hello.Compression)
+		}
+
+		compressor, err = GetCompressor(hello.Compression)
+		if err != nil {
+			return Session{}, err
+		}
+	}
+
+	return Session{
+		ProtocolVersion:        hello.ProtocolVersion,
+		RemoteFrameworkVersion: hello.FrameworkVersion,
+		Codec:                  codec,
+		Features:               hello.Features,
+		Compression:            compressor,
+		RemoteLimits: FrameLimits{
+			MaxFrameSize:  hello.MaxFrameSize,
+			MaxPacketSize: hello.MaxPacketSize,
+		},
+		Challenge: hello.Challenge,
+	}, nil
+}
+
+// serverHandshake reads a ClientHello from the client and responds with the
+// negotiated session. The first codec in the client's order of preference
+// that is also supported by the server is selected, and only the features
+// supported by both peers are enabled. Compression is selected the same way
+// as the codec, and is only used if both peers support it.
+func serverHandshake(rw io.ReadWriter, cfg handshakeConfig) (Session, error) {
+	reject := func(err error) (Session, error) {
+		writeHello(rw, ServerHello{
+			ProtocolVersion:  ProtocolVersion,
+			FrameworkVersion: version.Framework(),
+			Error:            err.Error(),
+		})
+		return Session{}, err
+	}
+
+	var hello ClientHello
+	if err := readHello(rw, &hello); err != nil {
+		return reject(err)
+	}
+
+	if hello.ProtocolVersion < MinProtocolVersion {
+		return reject(fmt.Errorf("%w: client supports protocol version %d, server supports %d-%d", ErrUnsupportedProtocolVersion, hello.ProtocolVersion, MinProtocolVersion, ProtocolVersion))
+	}
+
+	name, found := lo.Find(hello.Codecs, func(codec string) bool {
+		return lo.Contains(cfg.codecs, codec)
+	})
+	if !found {
+		return reject(fmt.Errorf("%w: client supports %v, server supports %v", ErrNoCommonCodec, hello.Codecs, cfg.codecs))
+	}
+
+	codec, err := GetCodec(name)
+	if err != nil {
+		return reject(err)
+	}
+
+	session := Session{
+		ProtocolVersion:        lo.Min([]int{hello.ProtocolVersion, ProtocolVersion}),
+		RemoteFrameworkVersion: hello.FrameworkVersion,
+		Codec:                  codec,
+		Features:               lo.Intersect(cfg.features, hello.Features),
+		RemoteLimits: FrameLimits{
+			MaxFrameSize:  hello.MaxFrameSize,
+			MaxPacketSize: hello.MaxPacketSize,
+		},
+	}
+
+	var compression string
+	if session.ProtocolVersion >= FramedProtocolVersion {
+		compression, _ = lo.Find(hello.Compression, func(compression string) bool {
+			return lo.Contains(cfg.compression, compression)
+		})
+	}
+
+	if compression != "" {
+		session.Compression, err = GetCompressor(compression)
+		if err != nil {
+			return reject(err)
+		}
+	}
+
+	if session.HasFeature(FeatureChallenge) {
+		session.Challenge = make([]byte, challengeSize)
+		if _, err := rand.Read(session.Challenge); err != nil {
+			return reject(fmt.Errorf("failed to generate challenge: %w", err))
+		}
+	}
+
+	err = writeHello(rw, ServerHello{
+		ProtocolVersion:  session.ProtocolVersion,
+		FrameworkVersion: version.Framework(),
+		Codec:            session.Codec.Name(),
+		Features:         session.Features,
+		Compression:      compression,
+		MaxFrameSize:     cfg.limits.MaxFrameSize,
+		MaxPacketSize:    cfg.limits.MaxPacketSize,
+		Challenge:        session.Challenge,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// channelBindingLabel is the label of the keying material exported from a
+// TLS connection as its channel binding, defined by RFC 9266.
+const channelBindingLabel = "EXPORTER-Channel-Binding"
+
+// authChallenge returns the data that a client proves that it holds its
+// token over: the challenge of the session followed by the channel binding
+// of conn, if it is a TLS connection. The channel binding differs on each
+// side of a connection that is relayed through another TLS endpoint, so a
+// proof can't be relayed to another server. Returns nil if the session has
+// no challenge.
+func authChallenge(session Session, conn net.Conn) ([]byte, error) {
+	if !session.HasFeature(FeatureChallenge) {
+		return nil, nil
+	}
+
+	challenge := append([]byte{}, session.Challenge...)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		binding, err := state.ExportKeyingMaterial(channelBindingLabel, nil, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export channel binding: %w", err)
+		}
+		challenge = append(challenge, binding...)
+	}
+
+	return challenge, nil
+}
+
+func writeHello(w io.Writer, hello any) error {
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// readHello reads a single line of JSON from r. The line is read one byte at
+// a time so that none of the packets following the hello are consumed.
+func readHello(r io.Reader, hello any) error {
+	var line []byte
+	b := make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fmt.Errorf("failed to read hello: %w", err)
+		}
+
+		if b[0] == '\n' {
+			break
+		}
+
+		line = append(line, b[0])
+		if len(line) > maxHelloSize {
+			return ErrHelloTooLarge
+		}
+	}
+
+	if err := json.Unmarshal(line, hello); err != nil {
+		return fmt.Errorf("malformed hello: %w", err)
+	}
+
+	return nil
+}
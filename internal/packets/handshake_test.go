@@ -0,0 +1,187 @@
+package packets_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+)
+
+func handshake(t *testing.T, client, server packets.HandlerConfig) (*packets.Handler, *packets.Handler, error, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	type result struct {
+		handler *packets.Handler
+		err     error
+	}
+
+	serverResult := make(chan result)
+	go func() {
+		h, err := packets.NewHandler(context.Background(), serverConn, packets.InputRoleServer, server)
+		serverResult <- result{h, err}
+	}()
+
+	clientHandler, clientErr := packets.NewHandler(context.Background(), clientConn, packets.InputRoleClient, client)
+	res := <-serverResult
+
+	return clientHandler, res.handler, clientErr, res.err
+}
+
+func TestHandshakeNegotiatesCodec(t *testing.T) {
+	client, server, clientErr, serverErr := handshake(t,
+		packets.HandlerConfig{Codecs: []string{packets.CodecJSON, packets.CodecGob}},
+		packets.HandlerConfig{Codecs: []string{packets.CodecGob, packets.CodecMsgpack, packets.CodecJSON}},
+	)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client: %v, server: %v", clientErr, serverErr)
+	}
+
+	if client.Codec().Name() != packets.CodecJSON || server.Codec().Name() != packets.CodecJSON {
+		t.Errorf("expected both peers to use %s, got client: %s, server: %s", packets.CodecJSON, client.Codec().Name(), server.Codec().Name())
+	}
+}
+
+func TestHandshakeNoCommonCodec(t *testing.T) {
+	_, _, clientErr, serverErr := handshake(t,
+		packets.HandlerConfig{Codecs: []string{packets.CodecJSON}},
+		packets.HandlerConfig{Codecs: []string{packets.CodecGob}},
+	)
+
+	if !errors.Is(serverErr, packets.ErrNoCommonCodec) {
+		t.Errorf("expected server error %s, got %v", packets.ErrNoCommonCodec, serverErr)
+	}
+
+	if clientErr == nil {
+		t.Errorf("expected client handshake to be rejected")
+	}
+}
+
+func TestHandshakeNegotiatesFeatures(t *testing.T) {
+	client, server, clientErr, serverErr := handshake(t,
+		packets.HandlerConfig{Features: []packets.Feature{"a", "b"}},
+		packets.HandlerConfig{Features: []packets.Feature{"b", "c"}},
+	)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client: %v, server: %v", clientErr, serverErr)
+	}
+
+	for _, handler := range []*packets.Handler{client, server} {
+		if !handler.HasFeature("b") || handler.HasFeature("a") || handler.HasFeature("c") {
+			t.Errorf("expected only feature b to be enabled, got %v", handler.Session().Features)
+		}
+	}
+}
+
+func TestHandshakeNegotiatesCompression(t *testing.T) {
+	client, server, clientErr, serverErr := handshake(t,
+		packets.HandlerConfig{Compression: []string{packets.CompressionZstd, packets.CompressionGzip}},
+		packets.HandlerConfig{Compression: []string{packets.CompressionGzip}},
+	)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client: %v, server: %v", clientErr, serverErr)
+	}
+
+	for _, handler := range []*packets.Handler{client, server} {
+		if compression := handler.Session().Compression; compression == nil || compression.Name() != packets.CompressionGzip {
+			t.Errorf("expected %s compression, got %v", packets.CompressionGzip, compression)
+		}
+	}
+}
+
+func TestHandshakeWithoutCompression(t *testing.T) {
+	client, server, clientErr, serverErr := handshake(t,
+		packets.HandlerConfig{Compression: []string{packets.CompressionZstd}},
+		packets.HandlerConfig{},
+	)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client: %v, server: %v", clientErr, serverErr)
+	}
+
+	for _, handler := range []*packets.Handler{client, server} {
+		if compression := handler.Session().Compression; compression != nil {
+			t.Errorf("expected no compression, got %s", compression.Name())
+		}
+	}
+}
+
+func TestHandshakeRejectsUnsupportedProtocolVersion(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverErr := make(chan error)
+	go func() {
+		_, err := packets.NewHandler(context.Background(), serverConn, packets.InputRoleServer, packets.HandlerConfig{})
+		serverErr <- err
+	}()
+
+	hello, _ := json.Marshal(packets.ClientHello{
+		ProtocolVersion: packets.MinProtocolVersion - 1,
+		Codecs:          packets.DefaultCodecs,
+	})
+	if _, err := clientConn.Write(append(hello, '\n')); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(clientConn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply packets.ServerHello
+	if err := json.Unmarshal(line, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Error == "" {
+		t.Errorf("expected the server to reject the connection")
+	}
+
+	if err := <-serverErr; !errors.Is(err, packets.ErrUnsupportedProtocolVersion) {
+		t.Errorf("expected %s, got %v", packets.ErrUnsupportedProtocolVersion, err)
+	}
+}
+
+func TestHandshakeSendsChallenge(t *testing.T) {
+	var challenges [][]byte
+	for i := 0; i < 2; i++ {
+		client, server, clientErr, serverErr := handshake(t, packets.HandlerConfig{}, packets.HandlerConfig{})
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("handshake failed: client: %v, server: %v", clientErr, serverErr)
+		}
+
+		challenge := server.Session().Challenge
+		if len(challenge) == 0 || !bytes.Equal(client.Session().Challenge, challenge) {
+			t.Fatalf("expected both peers to have the challenge of the server, got client: %x, server: %x", client.Session().Challenge, challenge)
+		}
+		challenges = append(challenges, challenge)
+	}
+
+	if bytes.Equal(challenges[0], challenges[1]) {
+		t.Errorf("expected each connection to have its own challenge")
+	}
+}
+
+func TestAuthenticationWithoutChallenge(t *testing.T) {
+	// The token is not sent to a server that does not send a challenge
+	// unless bearer tokens are allowed.
+	client, _, _ := connect(t, packets.HandlerConfig{Features: []packets.Feature{packets.FeatureStreaming}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitAuthenticated(ctx); !errors.Is(err, packets.ErrChallengeUnsupported) {
+		t.Errorf("expected %v, got %v", packets.ErrChallengeUnsupported, err)
+	}
+}
@@ -0,0 +1,43 @@
+package packets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// timedPacket is implemented by packets that carry how long the caller is
+// willing to wait for them to be handled.
+type timedPacket interface {
+	timeout() time.Duration
+}
+
+// inflightRequest is a packet received from the remote peer that is waiting
+// for a worker or being handled. It can be cancelled in either state.
+type inflightRequest struct {
+	mu        sync.Mutex
+	cancelled bool
+	cancel    context.CancelCauseFunc
+}
+
+// start records the function that cancels the handling of the packet.
+// Returns false if the packet was cancelled before it started.
+func (r *inflightRequest) start(cancel context.CancelCauseFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cancel = cancel
+	return !r.cancelled
+}
+
+// abort cancels the packet, or keeps it from being handled if it has not
+// started yet.
+func (r *inflightRequest) abort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cancelled = true
+	if r.cancel != nil {
+		r.cancel(ErrRequestCancelled)
+	}
+}
@@ -2,14 +2,16 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
+	"io"
+	"time"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(ActionPacket{})
+	registerPacket(ActionPacket{})
 }
 
 type ActionPacketType int
@@ -18,12 +20,54 @@ const (
 	ActionPacketTypePerformAndPublish ActionPacketType = iota
 	ActionPacketTypePerform
 	ActionPacketTypePublish
+	// ActionPacketTypeStream runs the action and streams its results back to
+	// the caller with StreamItemPackets followed by a StreamEndPacket.
+	ActionPacketTypeStream
 )
 
 type ActionPacket struct {
 	Action string           `json:"action"`
 	Arg    any              `json:"arg"`
 	Type   ActionPacketType `json:"type"`
+	// Timeout is the time that was left before the deadline of the caller's
+	// context when the packet was sent. The action is cancelled if it is
+	// still running once the timeout has passed since the packet was
+	// received.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+func (h ActionPacket) timeout() time.Duration {
+	return h.Timeout
+}
+
+func (h ActionPacket) authorize(claims authentication.Claims) error {
+	var operations []authentication.AuthorizedOperation
+
+	switch h.Type {
+	case ActionPacketTypePerformAndPublish:
+		operations = []authentication.AuthorizedOperation{authentication.Run, authentication.Publish}
+	case ActionPacketTypePerform, ActionPacketTypeStream:
+		operations = []authentication.AuthorizedOperation{authentication.Run}
+	case ActionPacketTypePublish:
+		operations = []authentication.AuthorizedOperation{authentication.Publish}
+	}
+
+	for _, operation := range operations {
+		err := claims.Authorize(authentication.AuthorizationRequest{
+			Type:      authentication.Action,
+			Operation: operation,
+			Name:      h.Action,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h ActionPacket) streaming() bool {
+	return h.Type == ActionPacketTypeStream
 }
 
 func (h ActionPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
@@ -32,6 +76,10 @@ func (h ActionPacket) Handle(ctx context.Context) (coattailtypes.Packet, error)
 		return nil, err
 	}
 
+	if h.Type == ActionPacketTypeStream {
+		return h.handleStream(ctx, ctHost)
+	}
+
 	var resp any
 
 	switch h.Type {
@@ -53,5 +101,34 @@ func (h ActionPacket) Handle(ctx context.Context) (coattailtypes.Packet, error)
 		}, nil
 	}
 
-	return nil, nil
+	return EmptyPacket{}, nil
+}
+
+// handleStream runs the action and sends each of its results to the caller
+// as it is produced.
+func (h ActionPacket) handleStream(ctx context.Context, ctHost *host.Host) (coattailtypes.Packet, error) {
+	sender, err := getStreamSender(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := ctHost.LocalPeer.RunStream(ctx, h.Action, h.Arg)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	for {
+		item, err := stream.Recv()
+		if err == io.EOF {
+			return StreamEndPacket{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := sender.Send(ctx, item); err != nil {
+			return nil, err
+		}
+	}
 }
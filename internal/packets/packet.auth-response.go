@@ -2,20 +2,32 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(AuthenticationResponsePacket{})
+	registerPacket(AuthenticationResponsePacket{})
 }
 
 type AuthenticationResponsePacket struct {
 	Authenticated bool   `json:"authenticated"`
 	Permitted     int32  `json:"permitted"`
 	Error         string `json:"error"`
+
+	// claims are the claims of the authenticated token. They are never sent
+	// to the remote peer and are only used by the Handler that authenticated
+	// the connection to authorize subsequent packets.
+	claims authentication.Claims
+	// token is the token that authenticated the connection. It is never sent
+	// to the remote peer and is only used by the Handler to apply the rate
+	// limit of the token.
+	token string
+	// tokenID is the revocation ID of the token, which is used to drop the
+	// connection if the token is revoked.
+	tokenID string
 }
 
 func (h AuthenticationResponsePacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
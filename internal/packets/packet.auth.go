@@ -2,7 +2,6 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 	"errors"
 	"net"
 
@@ -12,18 +11,86 @@ import (
 )
 
 var (
-	ErrConnectionNotFound = errors.New("connection not found in context")
+	ErrConnectionNotFound       = errors.New("connection not found in context")
+	ErrChallengeUnsupported     = errors.New("remote peer does not support challenge authentication and bearer tokens are not allowed")
+	ErrBearerTokenRejected      = errors.New("bearer tokens are not accepted, authenticate with a proof of the token")
+	ErrAlreadyAuthenticated     = errors.New("connection has already authenticated")
+	ErrAuthenticationIncomplete = errors.New("authentication of the connection was not completed")
 )
 
 func init() {
-	gob.Register(AuthenticationPacket{})
+	registerPacket(AuthenticationPacket{})
 }
 
+// AuthenticationPacket authenticates a client. Clients that support
+// FeatureChallenge send the claims of their token along with a proof that
+// they hold it, while other clients send the token itself.
 type AuthenticationPacket struct {
 	Token string `json:"token"`
+	// Claims are the claims of the token, as they appear in the token.
+	Claims string `json:"claims,omitempty"`
+	// Signature is the signature of a token signed with an Ed25519 key,
+	// which the server can't compute from the claims. Those tokens are
+	// proven with their holder key, which is never sent.
+	Signature []byte `json:"signature,omitempty"`
+	// Proof is the proof that the client holds the token, computed over
+	// the challenge of the connection.
+	Proof []byte `json:"proof,omitempty"`
 }
 
+// authPolicy is how a server expects clients to authenticate on a
+// connection.
+type authPolicy struct {
+	// challenge is what the client must prove that it holds its token over,
+	// or nil if the client does not support FeatureChallenge.
+	challenge []byte
+	// allowBearerTokens accepts the tokens sent by clients that don't
+	// support FeatureChallenge.
+	allowBearerTokens bool
+}
+
+// newProofPacket returns the packet that authenticates the holder of token
+// over challenge without sending the token.
+func newProofPacket(token string, challenge []byte) AuthenticationPacket {
+	claims, _ := authentication.SplitToken(token)
+	packet := AuthenticationPacket{Claims: claims}
+
+	parsed, err := authentication.NewTokenFromString(token)
+	if err != nil {
+		return packet
+	}
+	packet.Proof = parsed.Prove(challenge)
+
+	// The signature of tokens signed with an Ed25519 key can't be computed
+	// by the server.
+	if parsed.Algorithm == authentication.AlgorithmEdDSA {
+		packet.Signature = parsed.Signature
+	}
+
+	return packet
+}
+
+// Handle authenticates the client. Failures are reported in the response so
+// that the connection is never left waiting for the result of its
+// authentication.
 func (h AuthenticationPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	result, err := h.authenticate(ctx)
+
+	var response AuthenticationResponsePacket
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Authenticated = result.Authenticated
+		response.Permitted = result.Token.Permitted
+		response.claims = result.Token.Claims
+		response.token = result.Token.String()
+		response.tokenID = result.Token.RevocationID()
+	}
+
+	return response, nil
+}
+
+func (h AuthenticationPacket) authenticate(ctx context.Context) (*authentication.AuthenticationResult, error) {
 	conn, ok := ctx.Value(keys.ConnectionKey).(net.Conn)
 	if !ok {
 		return nil, ErrConnectionNotFound
@@ -38,16 +105,19 @@ func (h AuthenticationPacket) Handle(ctx context.Context) (coattailtypes.Packet,
 	if err != nil {
 		return nil, err
 	}
+	source := net.ParseIP(host)
 
-	var response AuthenticationResponsePacket
+	// A proof is only accepted for the challenge of this connection, so
+	// that it can't be replayed on another one, and a client that supports
+	// challenges never sends its token.
+	policy, _ := ctx.Value(keys.ChallengeKey).(authPolicy)
 
-	result, err := auth.Authenticate(ctx, h.Token, net.ParseIP(host))
-	if err != nil {
-		response.Error = err.Error()
-	} else {
-		response.Authenticated = result.Authenticated
-		response.Permitted = result.Token.Permitted
+	switch {
+	case policy.challenge != nil:
+		return auth.AuthenticateProof(ctx, h.Claims, h.Signature, policy.challenge, h.Proof, source)
+	case policy.allowBearerTokens:
+		return auth.Authenticate(ctx, h.Token, source)
+	default:
+		return nil, ErrBearerTokenRejected
 	}
-
-	return response, nil
 }
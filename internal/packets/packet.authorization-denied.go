@@ -0,0 +1,35 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(AuthorizationDeniedPacket{})
+}
+
+// AuthorizationDeniedPacket is sent in response to a packet that the claims of
+// the connection do not authorize.
+type AuthorizationDeniedPacket struct {
+	Reason string `json:"reason"`
+}
+
+// Err returns the error represented by the packet, which matches
+// coattailtypes.ErrUnauthorized.
+func (h AuthorizationDeniedPacket) Err() error {
+	return &coattailtypes.RemoteError{
+		Code:    coattailtypes.ErrorCodeUnauthorized,
+		Message: h.Reason,
+	}
+}
+
+func (h AuthorizationDeniedPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	if logger, _ := logging.GetLogger(ctx); logger != nil {
+		logger.Printf("%s", h.Err())
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(CancelPacket{})
+}
+
+// CancelPacket is sent when the caller of a request is no longer waiting for
+// the response, for example because its context was cancelled or its deadline
+// passed. The remote peer cancels the context used to handle the request.
+type CancelPacket struct {
+	// RequestID is the ID of the packet that should be cancelled.
+	RequestID uint64 `json:"request_id"`
+}
+
+// Handle is a no-op. Cancellation is performed by the Handler that receives
+// the packet since it owns the contexts of the requests in flight.
+func (h CancelPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package packets
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(ErrorPacket{})
+}
+
+// ErrorPacket is sent in response to a packet that could not be handled.
+type ErrorPacket struct {
+	Code    coattailtypes.ErrorCode `json:"code"`
+	Message string                  `json:"message"`
+	Details map[string]string       `json:"details,omitempty"`
+}
+
+// NewErrorPacket creates an ErrorPacket describing err.
+func NewErrorPacket(err error) ErrorPacket {
+	packet := ErrorPacket{
+		Code:    coattailtypes.ErrorCodeOf(err),
+		Message: err.Error(),
+	}
+
+	// The authentication service can't depend on coattailtypes, so its
+	// errors are given their code here.
+	if packet.Code == coattailtypes.ErrorCodeInternal && errors.Is(err, authentication.ErrUnauthorized) {
+		packet.Code = coattailtypes.ErrorCodeUnauthorized
+	}
+
+	var remoteErr *coattailtypes.RemoteError
+	if packet.Code != coattailtypes.ErrorCodeApplication && errors.As(err, &remoteErr) {
+		packet.Message = remoteErr.Message
+		packet.Details = remoteErr.Details
+	}
+
+	var withDetails coattailtypes.ErrorWithDetails
+	if errors.As(err, &withDetails) {
+		packet.Details = withDetails.ErrorDetails()
+	}
+
+	return packet
+}
+
+// Err returns the error represented by the packet.
+func (h ErrorPacket) Err() error {
+	return &coattailtypes.RemoteError{
+		Code:    h.Code,
+		Message: h.Message,
+		Details: h.Details,
+	}
+}
+
+func (h ErrorPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	if logger, _ := logging.GetLogger(ctx); logger != nil {
+		logger.Printf("%s", h.Err())
+	}
+
+	return nil, nil
+}
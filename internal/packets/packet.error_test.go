@@ -0,0 +1,97 @@
+package packets_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+type detailedError struct{}
+
+func (detailedError) Error() string {
+	return "quota exceeded"
+}
+
+func (detailedError) ErrorDetails() map[string]string {
+	return map[string]string{"limit": "10"}
+}
+
+func TestErrorPacket(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		code     coattailtypes.ErrorCode
+		sentinel error
+	}{
+		{
+			name:     "not found",
+			err:      fmt.Errorf("handler Foo %w", coattailtypes.ErrNotFound),
+			code:     coattailtypes.ErrorCodeNotFound,
+			sentinel: coattailtypes.ErrNotFound,
+		},
+		{
+			name:     "application",
+			err:      &coattailtypes.ApplicationError{Err: errors.New("boom")},
+			code:     coattailtypes.ErrorCodeApplication,
+			sentinel: coattailtypes.ErrApplication,
+		},
+		{
+			name:     "unauthorized",
+			err:      &authentication.UnauthorizedError{Reason: "run action Foo"},
+			code:     coattailtypes.ErrorCodeUnauthorized,
+			sentinel: coattailtypes.ErrUnauthorized,
+		},
+		{
+			name:     "internal",
+			err:      errors.New("host not found in context"),
+			code:     coattailtypes.ErrorCodeInternal,
+			sentinel: coattailtypes.ErrInternal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet := packets.NewErrorPacket(test.err)
+			if packet.Code != test.code {
+				t.Errorf("expected code %s, got %s", test.code, packet.Code)
+			}
+
+			if packet.Message != test.err.Error() {
+				t.Errorf("expected message %q, got %q", test.err.Error(), packet.Message)
+			}
+
+			err := packet.Err()
+			if !errors.Is(err, test.sentinel) {
+				t.Errorf("expected %v to match %v", err, test.sentinel)
+			}
+
+			var remoteErr *coattailtypes.RemoteError
+			if !errors.As(err, &remoteErr) {
+				t.Errorf("expected %v to be a RemoteError", err)
+			}
+		})
+	}
+}
+
+func TestErrorPacketDetails(t *testing.T) {
+	packet := packets.NewErrorPacket(&coattailtypes.ApplicationError{Err: detailedError{}})
+	if packet.Details["limit"] != "10" {
+		t.Errorf("expected details to be propagated, got %v", packet.Details)
+	}
+}
+
+func TestAuthorizationDeniedPacket(t *testing.T) {
+	err := packets.AuthorizationDeniedPacket{Reason: "run action Foo"}.Err()
+
+	var remoteErr *coattailtypes.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != coattailtypes.ErrorCodeUnauthorized {
+		t.Errorf("expected a RemoteError with code %s, got %v", coattailtypes.ErrorCodeUnauthorized, err)
+	}
+	if !errors.Is(err, coattailtypes.ErrUnauthorized) {
+		t.Errorf("expected %v to match %v", err, coattailtypes.ErrUnauthorized)
+	}
+}
@@ -0,0 +1,22 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(GoodbyePacket{})
+}
+
+// GoodbyePacket is sent by a peer that is about to close the connection. The
+// remote peer stops sending new requests on the connection, and the requests
+// that were already sent are still answered before it is closed.
+type GoodbyePacket struct{}
+
+// Handle is a no-op. The Handler that receives the packet stops sending new
+// requests on the connection.
+func (h GoodbyePacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
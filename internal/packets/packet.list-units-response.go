@@ -2,13 +2,12 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(ListUnitsResponsePacket{})
+	registerPacket(ListUnitsResponsePacket{})
 }
 
 type ListUnitsResponsePacket struct {
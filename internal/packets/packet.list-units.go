@@ -2,21 +2,33 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 	"fmt"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(ListUnitsPacket{})
+	registerPacket(ListUnitsPacket{})
 }
 
 type ListUnitsPacket struct {
 	Type coattailtypes.UnitType
 }
 
+func (h ListUnitsPacket) authorize(claims authentication.Claims) error {
+	switch h.Type {
+	case coattailtypes.UnitTypeAction:
+		return claims.AuthorizePermission(permission.ReadActions)
+	case coattailtypes.UnitTypeReceiver:
+		return claims.AuthorizePermission(permission.ReadReceivers)
+	}
+
+	return nil
+}
+
 func (h ListUnitsPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
 	ctHost, err := host.GetHost(ctx)
 	if err != nil {
@@ -31,10 +43,43 @@ func (h ListUnitsPacket) Handle(ctx context.Context) (coattailtypes.Packet, erro
 	case coattailtypes.UnitTypeReceiver:
 		values, _ = ctHost.LocalPeer.ListReceivers(ctx)
 	default:
-		return nil, fmt.Errorf("invalid unit type")
+		return nil, fmt.Errorf("%w: invalid unit type %d", coattailtypes.ErrInvalidRequest, h.Type)
+	}
+
+	// Scoped claims only list the units that they authorize an operation
+	// on.
+	if claims, ok := authentication.ClaimsFromContext(ctx); ok && claims.IsScoped() {
+		values = authorizedUnits(claims, h.Type, values)
 	}
 
 	return ListUnitsResponsePacket{
 		Values: values,
 	}, nil
 }
+
+// authorizedUnits returns the names that claims authorize any operation on
+// for units of type unitType.
+func authorizedUnits(claims authentication.Claims, unitType coattailtypes.UnitType, names []string) []string {
+	authorizationType := authentication.Action
+	operations := []authentication.AuthorizedOperation{authentication.Run, authentication.Publish, authentication.Subscribe}
+	if unitType == coattailtypes.UnitTypeReceiver {
+		authorizationType = authentication.Receiver
+		operations = []authentication.AuthorizedOperation{authentication.Notify}
+	}
+
+	var authorized []string
+	for _, name := range names {
+		for _, operation := range operations {
+			if claims.IsAuthorized(authentication.AuthorizationRequest{
+				Type:      authorizationType,
+				Operation: operation,
+				Name:      name,
+			}) {
+				authorized = append(authorized, name)
+				break
+			}
+		}
+	}
+
+	return authorized
+}
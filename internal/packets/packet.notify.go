@@ -2,19 +2,44 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(NotifyPacket{})
+	registerPacket(NotifyPacket{})
 }
 
 type NotifyPacket struct {
 	Receiver string `json:"receiver"`
 	Data     interface{}
+
+	// Publisher identifies the instance that published the notification.
+	Publisher string `json:"publisher,omitempty"`
+	// Sequence numbers the notifications sent by the publisher to the
+	// receiver, starting at 1. Notifications that were not published, such
+	// as those sent with Notify, have no sequence number.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Skipped are the sequence numbers of the notifications that the
+	// publisher failed to send to the receiver, which the receiver doesn't
+	// wait for.
+	Skipped []uint64 `json:"skipped,omitempty"`
+}
+
+// orderedNotifier is implemented by the local peer to run notifications that
+// have sequence numbers.
+type orderedNotifier interface {
+	NotifyInOrder(ctx context.Context, name string, arg any, publisher string, seq uint64, skipped []uint64) error
+}
+
+func (n NotifyPacket) authorize(claims authentication.Claims) error {
+	return claims.Authorize(authentication.AuthorizationRequest{
+		Type:      authentication.Receiver,
+		Operation: authentication.Notify,
+		Name:      n.Receiver,
+	})
 }
 
 func (n NotifyPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
@@ -23,6 +48,14 @@ func (n NotifyPacket) Handle(ctx context.Context) (coattailtypes.Packet, error)
 		return nil, err
 	}
 
-	return nil, ctHost.LocalPeer.
-		Notify(ctx, n.Receiver, n.Data)
+	if notifier, ok := ctHost.LocalPeer.PeerAdapter.(orderedNotifier); ok && n.Sequence != 0 {
+		err = notifier.NotifyInOrder(ctx, n.Receiver, n.Data, n.Publisher, n.Sequence, n.Skipped)
+	} else {
+		err = ctHost.LocalPeer.Notify(ctx, n.Receiver, n.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return EmptyPacket{}, nil
 }
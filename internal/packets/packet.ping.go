@@ -0,0 +1,19 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(PingPacket{})
+}
+
+// PingPacket is sent periodically to keep a connection alive and to measure
+// the round-trip latency to the remote peer.
+type PingPacket struct{}
+
+func (h PingPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return PongPacket{}, nil
+}
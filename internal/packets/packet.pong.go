@@ -0,0 +1,18 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(PongPacket{})
+}
+
+// PongPacket is sent in response to a PingPacket.
+type PongPacket struct{}
+
+func (h PongPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(StreamCreditPacket{})
+}
+
+// StreamCreditPacket is sent by the receiver of a stream as it consumes the
+// results of the stream. It allows the sender of the stream to send Credits
+// more results.
+type StreamCreditPacket struct {
+	// RequestID is the ID of the packet that started the stream.
+	RequestID uint64 `json:"request_id"`
+	// Credits is the number of additional results that may be sent.
+	Credits int `json:"credits"`
+}
+
+// Handle is a no-op. Credits are granted by the Handler that receives the
+// packet since it owns the streams being sent.
+func (h StreamCreditPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
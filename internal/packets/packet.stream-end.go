@@ -0,0 +1,22 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(StreamEndPacket{})
+}
+
+// StreamEndPacket is sent once a streaming action has completed and all of
+// its results have been sent. A streaming action that fails is ended with
+// an ErrorPacket instead.
+type StreamEndPacket struct{}
+
+// Handle is a no-op. The end of a stream is delivered to the Stream waiting
+// for it by the Handler that receives the packet.
+func (h StreamEndPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
@@ -0,0 +1,23 @@
+package packets
+
+import (
+	"context"
+
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+func init() {
+	registerPacket(StreamItemPacket{})
+}
+
+// StreamItemPacket carries a single result of a streaming action. It is sent
+// in response to an ActionPacket of type ActionPacketTypeStream.
+type StreamItemPacket struct {
+	Data any `json:"data"`
+}
+
+// Handle is a no-op. Stream items are delivered to the Stream waiting for
+// them by the Handler that receives the packet.
+func (h StreamItemPacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
+	return nil, nil
+}
@@ -2,15 +2,15 @@ package packets
 
 import (
 	"context"
-	"encoding/gob"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailmodels"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
 func init() {
-	gob.Register(SubscribePacket{})
+	registerPacket(SubscribePacket{})
 }
 
 type SubscribePacket struct {
@@ -19,6 +19,14 @@ type SubscribePacket struct {
 	Receiver string `json:"receiver"`
 }
 
+func (h SubscribePacket) authorize(claims authentication.Claims) error {
+	return claims.Authorize(authentication.AuthorizationRequest{
+		Type:      authentication.Action,
+		Operation: authentication.Subscribe,
+		Name:      h.Action,
+	})
+}
+
 func (h SubscribePacket) Handle(ctx context.Context) (coattailtypes.Packet, error) {
 	ctHost, err := host.GetHost(ctx)
 	if err != nil {
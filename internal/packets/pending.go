@@ -0,0 +1,81 @@
+package packets
+
+import "sync"
+
+// pendingRequest is a request sent to the remote peer that is waiting for a
+// response. Both channels must be buffered so that the Handler never blocks
+// on a caller that has stopped waiting.
+type pendingRequest struct {
+	respChan chan any
+	errChan  chan error
+}
+
+// pendingRequests tracks the requests sent on a connection that are waiting
+// for a response. Packet IDs are only unique within a connection, so each
+// Handler has its own table.
+type pendingRequests struct {
+	mu       sync.Mutex
+	requests map[uint64]pendingRequest
+	err      error
+}
+
+// add starts waiting for a response to the request with the provided ID.
+// Returns the error that the table was closed with if it has been closed.
+func (p *pendingRequests) add(id uint64, request pendingRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.requests == nil {
+		p.requests = map[uint64]pendingRequest{}
+	}
+	p.requests[id] = request
+	return nil
+}
+
+// remove stops waiting for a response to the request with the provided ID
+// and returns the request, if it was still waiting.
+func (p *pendingRequests) remove(id uint64) (pendingRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	request, ok := p.requests[id]
+	delete(p.requests, id)
+	return request, ok
+}
+
+// deliver sends a response to the request that it responds to. Returns false
+// if no request is waiting for it.
+func (p *pendingRequests) deliver(respondingTo uint64, data any) bool {
+	request, ok := p.remove(respondingTo)
+	if !ok {
+		return false
+	}
+
+	request.respChan <- data
+	return true
+}
+
+// len returns the number of requests waiting for a response.
+func (p *pendingRequests) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.requests)
+}
+
+// close fails every pending request with err. Requests added after the table
+// has been closed fail immediately with the same error.
+func (p *pendingRequests) close(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+	for id, request := range p.requests {
+		delete(p.requests, id)
+		request.errChan <- err
+	}
+}
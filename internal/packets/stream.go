@@ -0,0 +1,260 @@
+package packets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// StreamWindow is the number of results that the sender of a stream may send
+// before it has to wait for the receiver to grant it more credits.
+const StreamWindow = 32
+
+var (
+	ErrStreamingUnsupported = errors.New("streaming is not supported by the remote peer")
+	ErrStreamClosed         = coattailtypes.ErrStreamClosed
+	ErrStreamWindowExceeded = errors.New("remote peer exceeded the stream window")
+)
+
+// streamingPacket is implemented by packets that may start a stream. When
+// streaming returns true, the packet is handled with a streamSender in its
+// context.
+type streamingPacket interface {
+	streaming() bool
+}
+
+/* ====== Receiver ====== */
+
+// Stream receives the results of a stream started with Handler.Stream. Stream
+// implements coattailtypes.ActionStream.
+type Stream struct {
+	handler *Handler
+	ctx     context.Context
+	id      uint64
+
+	items      chan any
+	done       chan struct{}
+	err        error
+	closed     atomic.Bool
+	finishOnce sync.Once
+	closeOnce  sync.Once
+	consumed   int
+}
+
+func newStream(ctx context.Context, handler *Handler) *Stream {
+	return &Stream{
+		handler: handler,
+		ctx:     ctx,
+		items:   make(chan any, StreamWindow),
+		done:    make(chan struct{}),
+	}
+}
+
+// Recv returns the next result of the stream. Returns io.EOF once the remote
+// peer has ended the stream and all of its results have been received, and
+// ErrStreamClosed once the stream has been closed. Recv must not be called
+// concurrently.
+func (s *Stream) Recv() (any, error) {
+	if s.closed.Load() {
+		return nil, ErrStreamClosed
+	}
+
+	select {
+	case item := <-s.items:
+		return s.consume(item), nil
+	case <-s.done:
+	case <-s.ctx.Done():
+		// A stream that has ended is reported as such even if the context
+		// is also done.
+		select {
+		case <-s.done:
+		default:
+			s.Close()
+			return nil, s.ctx.Err()
+		}
+	}
+
+	if s.closed.Load() {
+		return nil, ErrStreamClosed
+	}
+
+	// Results received before the stream ended are still returned.
+	select {
+	case item := <-s.items:
+		return s.consume(item), nil
+	default:
+	}
+	return nil, s.err
+}
+
+// Close stops receiving results and asks the remote peer to stop the stream
+// if it has not ended yet.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+
+		// The stream is only registered with the handler until the remote
+		// peer ends it.
+		if _, live := s.handler.streams.LoadAndDelete(s.id); live {
+			s.handler.abandon(s.id)
+		}
+		s.finish(ErrStreamClosed)
+	})
+
+	return nil
+}
+
+// consume grants the remote peer more credits once half of the stream window
+// has been consumed.
+func (s *Stream) consume(item any) any {
+	s.consumed++
+	if s.consumed < StreamWindow/2 {
+		return item
+	}
+
+	credits := s.consumed
+	s.consumed = 0
+
+	if _, live := s.handler.streams.Load(s.id); live {
+		err := s.handler.Send(StreamCreditPacket{
+			RequestID: s.id,
+			Credits:   credits,
+		})
+		if err != nil {
+			if logger, _ := logging.GetLogger(s.handler.Context()); logger != nil {
+				logger.Printf("failed to grant credits to stream %d: %s\n", s.id, err)
+			}
+		}
+	}
+
+	return item
+}
+
+// deliver is called by the Handler with each packet received in response to
+// the packet that started the stream, in the order they were received.
+func (s *Stream) deliver(data any) {
+	switch p := data.(type) {
+	case StreamItemPacket:
+		select {
+		case s.items <- p.Data:
+		default:
+			// The remote peer sent more results than it had credits for.
+			s.finish(ErrStreamWindowExceeded)
+			s.Close()
+		}
+		return
+	case StreamEndPacket:
+		s.finish(io.EOF)
+	case ErrorPacket:
+		s.finish(p.Err())
+	case AuthorizationDeniedPacket:
+		s.finish(p.Err())
+	case AuthenticationInvalidPacket:
+		s.finish(&coattailtypes.RemoteError{
+			Code:    coattailtypes.ErrorCodeUnauthenticated,
+			Message: p.Error,
+		})
+	default:
+		s.finish(fmt.Errorf("unexpected stream packet %T", data))
+	}
+
+	s.handler.streams.Delete(s.id)
+}
+
+func (s *Stream) finish(err error) {
+	s.finishOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+/* ====== Sender ====== */
+
+// streamSender sends the results of a stream to the remote peer, waiting for
+// credits from the remote peer whenever the stream window is used up.
+type streamSender struct {
+	handler   *Handler
+	requestID uint64
+
+	mu      sync.Mutex
+	credits int
+	closed  bool
+	signal  chan struct{}
+}
+
+func newStreamSender(handler *Handler, requestID uint64) *streamSender {
+	return &streamSender{
+		handler:   handler,
+		requestID: requestID,
+		credits:   StreamWindow,
+		signal:    make(chan struct{}, 1),
+	}
+}
+
+// getStreamSender returns the streamSender for the packet being handled with
+// ctx.
+func getStreamSender(ctx context.Context) (*streamSender, error) {
+	sender, ok := ctx.Value(keys.StreamKey).(*streamSender)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w", coattailtypes.ErrInvalidRequest, ErrStreamingUnsupported)
+	}
+
+	return sender, nil
+}
+
+// Send sends a result to the remote peer, blocking until the remote peer has
+// granted a credit for it.
+func (s *streamSender) Send(ctx context.Context, item any) error {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrConnectionClosed
+		}
+		if s.credits > 0 {
+			s.credits--
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.signal:
+		}
+	}
+
+	return s.handler.respond(response{
+		CallerID: s.requestID,
+		Packet:   StreamItemPacket{Data: item},
+	})
+}
+
+func (s *streamSender) grant(credits int) {
+	s.mu.Lock()
+	s.credits += credits
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *streamSender) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *streamSender) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
@@ -1,5 +1,14 @@
 package authentication
 
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
 // AuthorizedOperation is an operation that is authorized.
 type AuthorizedOperation int
 
@@ -10,6 +19,21 @@ const (
 	Notify
 )
 
+func (o AuthorizedOperation) String() string {
+	switch o {
+	case Run:
+		return "run"
+	case Publish:
+		return "publish"
+	case Subscribe:
+		return "subscribe"
+	case Notify:
+		return "notify"
+	}
+
+	return fmt.Sprintf("operation(%d)", int(o))
+}
+
 // AuthorizationType is the type of authorization.
 type AuthorizationType int
 
@@ -18,6 +42,17 @@ const (
 	Receiver
 )
 
+func (t AuthorizationType) String() string {
+	switch t {
+	case Action:
+		return "action"
+	case Receiver:
+		return "receiver"
+	}
+
+	return fmt.Sprintf("type(%d)", int(t))
+}
+
 // Authorization is a set of authorized operations.
 type Authorization struct {
 	// Type is the type of authorization, either action or receiver.
@@ -37,3 +72,22 @@ type AuthorizationRequest struct {
 	// Name is the name of the action or receiver.
 	Name string
 }
+
+func (r AuthorizationRequest) String() string {
+	return fmt.Sprintf("%s %s %s", r.Operation, r.Type, r.Name)
+}
+
+// UnauthorizedError is returned when a set of claims does not permit an
+// operation. It matches ErrUnauthorized when used with errors.Is.
+type UnauthorizedError struct {
+	// Reason describes the operation that was denied.
+	Reason string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnauthorized, e.Reason)
+}
+
+func (e *UnauthorizedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
@@ -1,25 +1,61 @@
 package authentication
 
 import (
+	"context"
 	"net"
 	"time"
 
+	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
 	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
 )
 
 // Claims is a set of claims that can be used to issue a token.
 type Claims struct {
+	// ID identifies the token so that it can be revoked. Tokens issued by a
+	// Service are given a random ID.
+	ID string `msgpack:"jti,omitempty"`
+	// KeyID identifies the key in the keyring that signed the token. Tokens
+	// without a key ID are verified with the legacy key.
+	KeyID string `msgpack:"kid,omitempty"`
+	// Algorithm is the algorithm that signed the token. Tokens without an
+	// algorithm are signed with HMAC-SHA256.
+	Algorithm string `msgpack:"alg,omitempty"`
+	// HolderKey is the Ed25519 public key of the holder of the token, whose
+	// private key is only known to the holder. Tokens with a holder key are
+	// proven with a signature of the holder key instead of an HMAC keyed
+	// with the token.
+	HolderKey         []byte `msgpack:"cnf,omitempty"`
 	AuthorizedNetwork net.IPNet
 	Permitted         int32
 	Authorizations    []Authorization
 	Expiry            time.Time
 }
 
+// ClaimsFromContext returns the claims of the remote peer that a packet is
+// being handled for.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(keys.ClaimsKey).(Claims)
+	return claims, ok
+}
+
+// ContextWithClaims returns a copy of ctx that carries the claims of the
+// remote peer that a packet is being handled for.
+func ContextWithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, keys.ClaimsKey, claims)
+}
+
 // Permissions returns the permissions of the claims.
 func (c Claims) Permissions() permission.Permissions {
 	return permission.GetPermissions(c.Permitted)
 }
 
+// IsScoped returns true if the claims are restricted to a set of
+// authorizations. Claims without any authorizations are not scoped and are
+// permitted to perform any operation on any action or receiver.
+func (c Claims) IsScoped() bool {
+	return len(c.Authorizations) > 0
+}
+
 // IsAuthorized checks if the claims are authorized for the provided request.
 func (c Claims) IsAuthorized(req AuthorizationRequest) bool {
 	for _, a := range c.Authorizations {
@@ -33,3 +69,25 @@ func (c Claims) IsAuthorized(req AuthorizationRequest) bool {
 	}
 	return false
 }
+
+// Authorize returns an UnauthorizedError if the claims are scoped and do not
+// authorize the provided request.
+func (c Claims) Authorize(req AuthorizationRequest) error {
+	if c.IsScoped() && !c.IsAuthorized(req) {
+		return &UnauthorizedError{Reason: req.String()}
+	}
+
+	return nil
+}
+
+// AuthorizePermission returns an UnauthorizedError if the claims do not grant
+// the provided permission.
+func (c Claims) AuthorizePermission(p permission.Permission) error {
+	if !c.Permissions().Has(p) {
+		return &UnauthorizedError{
+			Reason: "missing permission " + permission.GetPermissions(int32(p)).String(),
+		}
+	}
+
+	return nil
+}
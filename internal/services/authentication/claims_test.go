@@ -0,0 +1,62 @@
+package authentication_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
+)
+
+func TestClaimsAuthorize(t *testing.T) {
+	runFoo := authentication.AuthorizationRequest{
+		Type:      authentication.Action,
+		Operation: authentication.Run,
+		Name:      "Foo",
+	}
+
+	unscoped := authentication.Claims{}
+	if err := unscoped.Authorize(runFoo); err != nil {
+		t.Errorf("expected unscoped claims to be authorized, got %s", err)
+	}
+
+	scoped := authentication.Claims{
+		Authorizations: []authentication.Authorization{
+			{
+				Type:       authentication.Action,
+				Operations: []authentication.AuthorizedOperation{authentication.Run},
+				Name:       "Foo",
+			},
+		},
+	}
+
+	if err := scoped.Authorize(runFoo); err != nil {
+		t.Errorf("expected scoped claims to be authorized, got %s", err)
+	}
+
+	publishFoo := runFoo
+	publishFoo.Operation = authentication.Publish
+	if err := scoped.Authorize(publishFoo); !errors.Is(err, authentication.ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", authentication.ErrUnauthorized, err)
+	}
+
+	runBar := runFoo
+	runBar.Name = "Bar"
+	if err := scoped.Authorize(runBar); !errors.Is(err, authentication.ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", authentication.ErrUnauthorized, err)
+	}
+}
+
+func TestClaimsAuthorizePermission(t *testing.T) {
+	claims := authentication.Claims{
+		Permitted: permission.PermissionMask(permission.ReadActions),
+	}
+
+	if err := claims.AuthorizePermission(permission.ReadActions); err != nil {
+		t.Errorf("expected permission to be granted, got %s", err)
+	}
+
+	if err := claims.AuthorizePermission(permission.ReadReceivers); !errors.Is(err, authentication.ErrUnauthorized) {
+		t.Errorf("expected %s, got %v", authentication.ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,404 @@
+package authentication
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoPrimaryKey   = errors.New("keyring has no primary key")
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrKeyRetired     = errors.New("key retired")
+	ErrRetirePrimary  = errors.New("the primary key can't be retired")
+	ErrInvalidKeyring = errors.New("invalid keyring")
+	ErrUnknownKeyType = errors.New("unknown key type")
+	ErrVerifyOnlyKey  = errors.New("key can only verify tokens")
+	ErrKeyExists      = errors.New("key already exists")
+)
+
+const (
+	// KeyTypeHMAC is the type of keys that sign tokens with HMAC-SHA256.
+	// The same key signs and verifies tokens, so it must be kept secret by
+	// every service that verifies them.
+	KeyTypeHMAC = "hmac"
+	// KeyTypeEd25519 is the type of keys that sign tokens with Ed25519.
+	// Tokens are signed with the private key and verified with the public
+	// key, which can be shared with the services that accept the tokens.
+	KeyTypeEd25519 = "ed25519"
+)
+
+// KeySize is the size in bytes of the HMAC keys generated for a keyring.
+const KeySize = 2048
+
+// Key is a key used to sign and verify tokens.
+type Key struct {
+	// ID identifies the key in the tokens that it signs.
+	ID string `yaml:"id"`
+	// Type is the type of the key, KeyTypeHMAC or KeyTypeEd25519. Defaults
+	// to KeyTypeHMAC.
+	Type string `yaml:"type,omitempty"`
+	// Secret is the HMAC key or the Ed25519 private key. Ed25519 keys
+	// without a private key can only verify tokens.
+	Secret KeyData `yaml:"secret,omitempty"`
+	// PublicKey is the Ed25519 public key.
+	PublicKey KeyData `yaml:"public_key,omitempty"`
+	// Created is when the key was added to the keyring.
+	Created time.Time `yaml:"created"`
+	// Primary is true for the key that signs new tokens.
+	Primary bool `yaml:"primary,omitempty"`
+	// Legacy is true for a key imported from a secret key file, which also
+	// verifies the tokens that were issued without a key ID.
+	Legacy bool `yaml:"legacy,omitempty"`
+	// RetireAt is when a retired key stops verifying tokens. It is zero
+	// for keys that have not been retired.
+	RetireAt time.Time `yaml:"retire_at,omitempty"`
+}
+
+// KeyData is the material of a key, which is stored in base64.
+type KeyData []byte
+
+func (d KeyData) MarshalYAML() (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(d), nil
+}
+
+func (d *KeyData) UnmarshalYAML(value *yaml.Node) error {
+	data, err := base64.StdEncoding.DecodeString(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid key: %w", value.Line, err)
+	}
+
+	*d = data
+	return nil
+}
+
+// CanSign returns true if the key can sign tokens.
+func (k Key) CanSign() bool {
+	return len(k.Secret) > 0
+}
+
+// Retired returns true if the key has been retired.
+func (k Key) Retired() bool {
+	return !k.RetireAt.IsZero()
+}
+
+// Active returns true if the key verifies tokens at the provided time.
+func (k Key) Active(now time.Time) bool {
+	return !k.Retired() || now.Before(k.RetireAt)
+}
+
+// Keyring holds the keys used to sign and verify tokens. Tokens are signed
+// with the primary key and record its ID, so that the primary key can be
+// replaced while the tokens signed with the previous one remain valid until
+// it is retired.
+type Keyring struct {
+	Keys []Key `yaml:"keys"`
+}
+
+// NewKey generates a key of the provided type. HMAC keys have a random ID,
+// while the ID of an Ed25519 key is derived from its public key so that the
+// services that trust it know it by the same ID.
+func NewKey(keyType string) (Key, error) {
+	switch keyType {
+	case "", KeyTypeHMAC:
+		secret := make([]byte, KeySize)
+		if _, err := rand.Read(secret); err != nil {
+			return Key{}, fmt.Errorf("failed to generate key: %w", err)
+		}
+
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return Key{}, fmt.Errorf("failed to generate key id: %w", err)
+		}
+
+		return Key{
+			ID:      hex.EncodeToString(id),
+			Secret:  secret,
+			Created: time.Now().UTC(),
+		}, nil
+	case KeyTypeEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Key{}, fmt.Errorf("failed to generate key: %w", err)
+		}
+
+		return Key{
+			ID:        keyID(public),
+			Type:      KeyTypeEd25519,
+			Secret:    KeyData(private),
+			PublicKey: KeyData(public),
+			Created:   time.Now().UTC(),
+		}, nil
+	}
+
+	return Key{}, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+}
+
+// TrustedKey returns a key that verifies the tokens signed by another
+// issuer with the private key of the provided Ed25519 public key.
+func TrustedKey(publicKey []byte) (Key, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return Key{}, fmt.Errorf("%w: an ed25519 public key is %d bytes, got %d", ErrInvalidKeyring, ed25519.PublicKeySize, len(publicKey))
+	}
+
+	return Key{
+		ID:        keyID(publicKey),
+		Type:      KeyTypeEd25519,
+		PublicKey: publicKey,
+		Created:   time.Now().UTC(),
+	}, nil
+}
+
+// ParseKeyring parses a keyring. Returns an error wrapping ErrInvalidKeyring
+// if data is not a keyring with at least one key.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var keyring Keyring
+	if err := yaml.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyring, err)
+	}
+
+	if len(keyring.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrInvalidKeyring)
+	}
+	for _, key := range keyring.Keys {
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("%w: key %s: %w", ErrInvalidKeyring, key.ID, err)
+		}
+	}
+
+	return &keyring, nil
+}
+
+// LoadKeyring reads the keyring from the provided path.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return keyring, nil
+}
+
+// Save writes the keyring to the provided path. The file is replaced at
+// once so that a running host never reads a partially written keyring.
+func (k *Keyring) Save(path string) error {
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Primary returns the key that signs new tokens.
+func (k *Keyring) Primary() (Key, error) {
+	for _, key := range k.Keys {
+		if key.Primary && key.CanSign() {
+			return key, nil
+		}
+	}
+
+	return Key{}, ErrNoPrimaryKey
+}
+
+// Lookup returns the key with the provided ID that verifies tokens at the
+// provided time. Tokens without a key ID are verified with the legacy key.
+func (k *Keyring) Lookup(id string, now time.Time) (Key, error) {
+	for _, key := range k.Keys {
+		if key.ID == id || (id == "" && key.Legacy) {
+			if !key.Active(now) {
+				return Key{}, fmt.Errorf("%w: %s", ErrKeyRetired, key.ID)
+			}
+			return key, nil
+		}
+	}
+
+	return Key{}, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+}
+
+// Add adds a new key of the provided type to the keyring, which becomes the
+// primary key if primary is true or if the keyring has no primary key.
+func (k *Keyring) Add(keyType string, primary bool) (Key, error) {
+	key, err := NewKey(keyType)
+	if err != nil {
+		return Key{}, err
+	}
+
+	if _, err := k.Primary(); err != nil {
+		primary = true
+	}
+
+	k.Keys = append(k.Keys, key)
+	if primary {
+		if err := k.Promote(key.ID); err != nil {
+			return Key{}, err
+		}
+		key.Primary = true
+	}
+
+	return key, nil
+}
+
+// Trust adds the Ed25519 public key of another issuer to the keyring, so that
+// the tokens that it signs are accepted.
+func (k *Keyring) Trust(publicKey []byte) (Key, error) {
+	key, err := TrustedKey(publicKey)
+	if err != nil {
+		return Key{}, err
+	}
+	if k.index(key.ID) >= 0 {
+		return Key{}, fmt.Errorf("%w: %s", ErrKeyExists, key.ID)
+	}
+
+	k.Keys = append(k.Keys, key)
+	return key, nil
+}
+
+// PublicKeys returns the Ed25519 keys of the keyring that can sign tokens,
+// without their private keys, so that they can be published to the services
+// that verify the tokens.
+func (k *Keyring) PublicKeys(now time.Time) []Key {
+	var keys []Key
+	for _, key := range k.Keys {
+		if key.Type == KeyTypeEd25519 && key.CanSign() && key.Active(now) {
+			key.Secret = nil
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Promote makes the key with the provided ID the primary key. The previous
+// primary key keeps verifying tokens until it is retired. A retired key is
+// no longer retired once it is promoted.
+func (k *Keyring) Promote(id string) error {
+	index := k.index(id)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if !k.Keys[index].CanSign() {
+		return fmt.Errorf("%w: %s", ErrVerifyOnlyKey, id)
+	}
+
+	for i := range k.Keys {
+		k.Keys[i].Primary = i == index
+	}
+	k.Keys[index].RetireAt = time.Time{}
+
+	return nil
+}
+
+// Retire retires the key with the provided ID, which keeps verifying tokens
+// until the provided time. The primary key can't be retired.
+func (k *Keyring) Retire(id string, at time.Time) error {
+	index := k.index(id)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if k.Keys[index].Primary {
+		return ErrRetirePrimary
+	}
+
+	k.Keys[index].RetireAt = at.UTC()
+	return nil
+}
+
+// Prune removes the retired keys that no longer verify tokens at the
+// provided time. Returns the removed keys.
+func (k *Keyring) Prune(now time.Time) []Key {
+	var kept, removed []Key
+	for _, key := range k.Keys {
+		if key.Active(now) {
+			kept = append(kept, key)
+		} else {
+			removed = append(removed, key)
+		}
+	}
+	k.Keys = kept
+
+	return removed
+}
+
+func (k *Keyring) index(id string) int {
+	for i, key := range k.Keys {
+		if key.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// validate checks that the key has the material that its type requires.
+func (k Key) validate() error {
+	switch k.Type {
+	case "", KeyTypeHMAC:
+		if len(k.Secret) == 0 {
+			return errors.New("an hmac key requires a secret")
+		}
+	case KeyTypeEd25519:
+		if len(k.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("an ed25519 public key is %d bytes, got %d", ed25519.PublicKeySize, len(k.PublicKey))
+		}
+		if !k.CanSign() {
+			break
+		}
+		if len(k.Secret) != ed25519.PrivateKeySize {
+			return fmt.Errorf("an ed25519 private key is %d bytes, got %d", ed25519.PrivateKeySize, len(k.Secret))
+		}
+		if !bytes.Equal(ed25519.PrivateKey(k.Secret).Public().(ed25519.PublicKey), k.PublicKey) {
+			return errors.New("the public key does not match the private key")
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownKeyType, k.Type)
+	}
+
+	return nil
+}
+
+// keyID derives the ID of a key from its material.
+func keyID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// legacyKey returns the key read from a secret key file. Its ID is derived
+// from the key so that importing the same file always gives the same ID.
+func legacyKey(secret []byte) Key {
+	return Key{
+		ID:      keyID(secret),
+		Secret:  secret,
+		Created: time.Now().UTC(),
+		Legacy:  true,
+	}
+}
@@ -0,0 +1,216 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	keyringFile := filepath.Join(dir, "keyring.yaml")
+	secretKeyFile := filepath.Join(dir, "secret.key")
+
+	// A secret key from an earlier version is imported into the keyring.
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretKeyFile, secret, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := authentication.ContextWithService(context.Background(), authentication.ServiceConfig{
+		KeyringFile:   keyringFile,
+		SecretKeyFile: secretKeyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := authentication.GetService(ctx)
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	claims := authentication.Claims{
+		AuthorizedNetwork: *network,
+		Expiry:            time.Now().Add(time.Hour),
+	}
+	source := net.ParseIP("127.0.0.1")
+	authenticate := func(token *authentication.Token) error {
+		_, err := auth.Authenticate(ctx, token.String(), source)
+		return err
+	}
+
+	// Tokens signed with the secret key before it was imported have no key
+	// ID and remain valid.
+	legacy, err := authentication.CreateToken(ctx, authentication.Key{Secret: secret}, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticate(legacy); err != nil {
+		t.Fatalf("expected the legacy token to be valid, got %v", err)
+	}
+
+	old, err := auth.Issue(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, _ := auth.Keyring().Primary()
+	if old.KeyID != oldKey.ID {
+		t.Errorf("expected the token to record key %s, got %q", oldKey.ID, old.KeyID)
+	}
+
+	// A new primary key signs new tokens, while the tokens signed with the
+	// previous one remain valid until it is retired.
+	keyring, err := authentication.LoadKeyring(keyringFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := keyring.Add(authentication.KeyTypeHMAC, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Save(keyringFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ReloadKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := auth.Issue(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.KeyID != newKey.ID {
+		t.Errorf("expected the token to record key %s, got %q", newKey.ID, current.KeyID)
+	}
+	for _, token := range []*authentication.Token{legacy, old, current} {
+		if err := authenticate(token); err != nil {
+			t.Errorf("expected the token signed with key %q to be valid, got %v", token.KeyID, err)
+		}
+	}
+
+	if err := keyring.Retire(newKey.ID, time.Now()); !errors.Is(err, authentication.ErrRetirePrimary) {
+		t.Errorf("expected %v, got %v", authentication.ErrRetirePrimary, err)
+	}
+
+	// Once the cutoff of the retired key has passed, the tokens that it
+	// signed are rejected.
+	if err := keyring.Retire(oldKey.ID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Save(keyringFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ReloadKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []*authentication.Token{legacy, old} {
+		if err := authenticate(token); !errors.Is(err, authentication.ErrKeyRetired) {
+			t.Errorf("expected %v, got %v", authentication.ErrKeyRetired, err)
+		}
+	}
+	if err := authenticate(current); err != nil {
+		t.Errorf("expected the token to be valid, got %v", err)
+	}
+
+	if removed := keyring.Prune(time.Now()); len(removed) != 1 || removed[0].ID != oldKey.ID {
+		t.Errorf("expected key %s to be pruned, got %v", oldKey.ID, removed)
+	}
+}
+
+func TestEd25519Tokens(t *testing.T) {
+	dir := t.TempDir()
+	newService := func(name, keyType string) *authentication.Service {
+		ctx, err := authentication.ContextWithService(context.Background(), authentication.ServiceConfig{
+			KeyringFile: filepath.Join(dir, name+".yaml"),
+			KeyType:     keyType,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, _ := authentication.GetService(ctx)
+		return auth
+	}
+
+	// The issuer signs tokens with its private key and the service only
+	// holds the issuer's public key.
+	issuer := newService("issuer", authentication.KeyTypeEd25519)
+	service := newService("service", authentication.KeyTypeHMAC)
+
+	published := issuer.Keyring().PublicKeys(time.Now())
+	if len(published) != 1 || published[0].CanSign() {
+		t.Fatalf("expected the public key of the issuer without its private key, got %+v", published)
+	}
+
+	keyringFile := filepath.Join(dir, "service.yaml")
+	keyring, err := authentication.LoadKeyring(keyringFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := keyring.Trust(published[0].PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted.ID != published[0].ID {
+		t.Errorf("expected the trusted key to have ID %s, got %s", published[0].ID, trusted.ID)
+	}
+	if _, err := keyring.Trust(published[0].PublicKey); !errors.Is(err, authentication.ErrKeyExists) {
+		t.Errorf("expected %v, got %v", authentication.ErrKeyExists, err)
+	}
+	if err := keyring.Promote(trusted.ID); !errors.Is(err, authentication.ErrVerifyOnlyKey) {
+		t.Errorf("expected %v, got %v", authentication.ErrVerifyOnlyKey, err)
+	}
+	if err := keyring.Save(keyringFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ReloadKeyring(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	source := net.ParseIP("127.0.0.1")
+	token, err := issuer.Issue(context.Background(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Algorithm != authentication.AlgorithmEdDSA {
+		t.Errorf("expected the token to be signed with %s, got %q", authentication.AlgorithmEdDSA, token.Algorithm)
+	}
+
+	for name, auth := range map[string]*authentication.Service{"issuer": issuer, "service": service} {
+		if _, err := auth.Authenticate(context.Background(), token.String(), source); err != nil {
+			t.Errorf("expected the %s to accept the token, got %v", name, err)
+		}
+	}
+
+	// Tokens with altered claims, or signed with HMAC using the public key,
+	// are rejected.
+	altered := *token
+	altered.Permitted = 7
+	forged, err := authentication.NewToken(authentication.Claims{
+		KeyID:             trusted.ID,
+		AuthorizedNetwork: *network,
+		Permitted:         7,
+		Expiry:            time.Now().Add(time.Hour),
+	}, trusted.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []*authentication.Token{&altered, forged} {
+		if _, err := service.Authenticate(context.Background(), token.String(), source); !errors.Is(err, authentication.ErrInvalidToken) {
+			t.Errorf("expected %v, got %v", authentication.ErrInvalidToken, err)
+		}
+	}
+}
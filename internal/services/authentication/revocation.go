@@ -0,0 +1,87 @@
+package authentication
+
+import (
+	"context"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/database"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailmodels"
+	"gorm.io/gorm/clause"
+)
+
+// Revoke revokes the token with the provided ID so that it can no longer be
+// used to authenticate. The expiry of the token is used to forget the
+// revocation once the token has expired, and can be zero if it is unknown.
+func (s *Service) Revoke(ctx context.Context, id string, reason string, expiry time.Time) error {
+	if s.db == nil {
+		return ErrRevocationUnavailable
+	}
+
+	if err := RevokeToken(s.db, id, reason, expiry); err != nil {
+		return err
+	}
+
+	// The host is told about the revocation so that it can drop the
+	// connections that authenticated with the token.
+	select {
+	case s.revoked <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Revoked returns a channel that receives a value after tokens have been
+// revoked with Revoke.
+func (s *Service) Revoked() <-chan struct{} {
+	return s.revoked
+}
+
+// RevokedTokens returns the tokens that have been revoked.
+func (s *Service) RevokedTokens(ctx context.Context) ([]coattailmodels.RevokedToken, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+
+	var revoked []coattailmodels.RevokedToken
+	if err := s.db.WithContext(ctx).Find(&revoked).Error; err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// PruneRevokedTokens forgets the revoked tokens that have expired. Returns
+// the number of tokens that were forgotten.
+func (s *Service) PruneRevokedTokens(ctx context.Context) (int64, error) {
+	if s.db == nil {
+		return 0, nil
+	}
+
+	result := s.db.WithContext(ctx).Where("expiry > ? AND expiry < ?", time.Time{}, time.Now().UTC()).Delete(&coattailmodels.RevokedToken{})
+	return result.RowsAffected, result.Error
+}
+
+// RevokeToken stores the revocation of the token with the provided ID in db.
+// Revoking a token that has already been revoked updates its reason and
+// expiry.
+func RevokeToken(db *database.Database, id string, reason string, expiry time.Time) error {
+	// Times are stored in UTC so that they can be compared in queries.
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&coattailmodels.RevokedToken{
+		ID:        id,
+		Reason:    reason,
+		Expiry:    expiry.UTC(),
+		RevokedAt: time.Now().UTC(),
+	}).Error
+}
+
+// IsRevoked returns true if the token with the provided ID has been revoked
+// in db.
+func IsRevoked(db *database.Database, id string) (bool, error) {
+	var count int64
+	if err := db.Model(&coattailmodels.RevokedToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,88 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/database"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+func TestRevoke(t *testing.T) {
+	dir := t.TempDir()
+	ctx, err := database.ContextWithDatabase(context.Background(), database.DatabaseConfig{
+		Path: filepath.Join(dir, "data.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := database.GetDatabase(ctx)
+	defer db.Close()
+
+	ctx, err = authentication.ContextWithService(ctx, authentication.ServiceConfig{
+		KeyringFile: filepath.Join(dir, "keyring.yaml"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := authentication.GetService(ctx)
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	issue := func(expiry time.Time) *authentication.Token {
+		token, err := auth.Issue(ctx, authentication.Claims{
+			AuthorizedNetwork: *network,
+			Expiry:            expiry,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	token := issue(time.Now().Add(time.Hour))
+	other := issue(time.Now().Add(time.Hour))
+	if token.ID == "" || token.ID == other.ID {
+		t.Fatalf("expected tokens to be issued with unique ids, got %q and %q", token.ID, other.ID)
+	}
+
+	source := net.ParseIP("127.0.0.1")
+	if _, err := auth.Authenticate(ctx, token.String(), source); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.Revoke(ctx, token.RevocationID(), "leaked", token.Expiry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-auth.Revoked():
+	default:
+		t.Error("expected the revocation to be signalled")
+	}
+
+	if _, err := auth.Authenticate(ctx, token.String(), source); !errors.Is(err, authentication.ErrTokenRevoked) {
+		t.Errorf("expected %v, got %v", authentication.ErrTokenRevoked, err)
+	}
+	if _, err := auth.Authenticate(ctx, other.String(), source); err != nil {
+		t.Errorf("expected other tokens to authenticate, got %v", err)
+	}
+
+	// Revocations are forgotten once the token has expired.
+	if err := authentication.RevokeToken(db, "expired", "", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err := auth.PruneRevokedTokens(ctx); err != nil || pruned != 1 {
+		t.Errorf("expected 1 revocation to be forgotten, got %d (%v)", pruned, err)
+	}
+
+	revoked, err := auth.RevokedTokens(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].ID != token.ID || revoked[0].Reason != "leaked" {
+		t.Errorf("expected the token to stay revoked, got %+v", revoked)
+	}
+}
@@ -2,21 +2,17 @@ package authentication
 
 import (
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/nathan-fiscaletti/coattail-go/internal/database"
 	"github.com/nathan-fiscaletti/coattail-go/internal/keys"
 )
 
-const (
-	secretKeyFile = "secret.key"
-)
-
 var (
 	ErrAuthenticationNotFound = errors.New("authentication service not found in context")
 	ErrInvalidToken           = errors.New("invalid token")
@@ -24,27 +20,59 @@ var (
 	ErrInvalidSignature       = errors.New("invalid signature")
 	ErrInvalidPermissions     = errors.New("invalid permissions")
 	ErrTokenExpired           = errors.New("token expired")
+	ErrTokenRevoked           = errors.New("token revoked")
+	ErrRevocationUnavailable  = errors.New("tokens can't be revoked without a database")
+	ErrInvalidProof           = errors.New("invalid proof")
 )
 
 type Service struct {
-	secretKey []byte
+	// keyring holds the keys that sign and verify tokens. It is replaced
+	// when the keyring file is reloaded.
+	keyring     atomic.Pointer[Keyring]
+	keyringFile string
+
+	// db stores the tokens that have been revoked. Tokens can't be revoked
+	// if it is nil.
+	db      *database.Database
+	revoked chan struct{}
+}
+
+// ServiceConfig configures the authentication service.
+type ServiceConfig struct {
+	// KeyringFile is the path of the keyring that holds the keys used to
+	// sign and verify tokens. It is created if it does not exist.
+	KeyringFile string
+	// SecretKeyFile is the path of a secret key that is imported into the
+	// keyring when it is created, so that the tokens signed with it remain
+	// valid.
+	SecretKeyFile string
+	// KeyType is the type of the key that a new keyring is created with.
+	// Defaults to KeyTypeHMAC.
+	KeyType string
 }
 
-func newService() (*Service, error) {
-	service := &Service{}
+func newService(cfg ServiceConfig, db *database.Database) (*Service, error) {
+	service := &Service{
+		keyringFile: cfg.KeyringFile,
+		db:          db,
+		revoked:     make(chan struct{}, 1),
+	}
 
-	// Load or generate secret key
-	err := service.loadSecretKey()
+	// Load or create the keyring
+	keyring, err := loadOrCreateKeyring(cfg)
 	if err != nil {
 		return nil, err
 	}
+	service.keyring.Store(keyring)
 
 	return service, nil
 }
 
 // ContextWithService returns a context with the authentication service.
-func ContextWithService(ctx context.Context) (context.Context, error) {
-	auth, err := newService()
+// Revoked tokens are stored in the database of ctx.
+func ContextWithService(ctx context.Context, cfg ServiceConfig) (context.Context, error) {
+	db, _ := database.GetDatabase(ctx)
+	auth, err := newService(cfg, db)
 	if err != nil {
 		return nil, err
 	}
@@ -62,14 +90,48 @@ func GetService(ctx context.Context) (*Service, error) {
 	return auth, nil
 }
 
-// Issue issues a token with the provided claims.
+// Issue issues a token with the provided claims, signed with the primary
+// key of the keyring. The token is given a random ID if the claims don't
+// have one.
 func (s *Service) Issue(ctx context.Context, claims Claims) (*Token, error) {
-	return NewToken(claims, s.secretKey)
+	key, err := s.keyring.Load().Primary()
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateToken(ctx, key, claims)
 }
 
-// CreateToken creates a token with the provided claims and key.
-func CreateToken(ctx context.Context, key []byte, claims Claims) (*Token, error) {
-	return NewToken(claims, key)
+// Keyring returns the keyring of the service.
+func (s *Service) Keyring() *Keyring {
+	return s.keyring.Load()
+}
+
+// ReloadKeyring reads the keyring file again, so that keys added, promoted
+// or retired since it was loaded take effect. The current keyring is kept if
+// the file can't be read.
+func (s *Service) ReloadKeyring() error {
+	keyring, err := LoadKeyring(s.keyringFile)
+	if err != nil {
+		return err
+	}
+
+	s.keyring.Store(keyring)
+	return nil
+}
+
+// CreateToken creates a token with the provided claims, signed with the
+// provided key. The token is given a random ID if the claims don't have one.
+func CreateToken(ctx context.Context, key Key, claims Claims) (*Token, error) {
+	if claims.ID == "" {
+		id, err := NewTokenID()
+		if err != nil {
+			return nil, err
+		}
+		claims.ID = id
+	}
+
+	return SignToken(claims, key)
 }
 
 // AuthenticationResult is the result of authenticating a token.
@@ -82,15 +144,80 @@ type AuthenticationResult struct {
 
 // Authenticate authenticates a token.
 func (s *Service) Authenticate(ctx context.Context, tokenStr string, source net.IP) (*AuthenticationResult, error) {
+	token, err := s.VerifyToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.authenticate(token, source)
+}
+
+// VerifyToken parses a token and checks that it was signed with a key of the
+// keyring, and that it includes the secret of its holder key if it has one,
+// since the claims and signature of such tokens are sent with their proofs.
+// It does not check whether the token has expired or was revoked.
+func (s *Service) VerifyToken(tokenStr string) (*Token, error) {
 	token, err := NewTokenFromString(tokenStr)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := token.VerifySignature(s.secretKey); err != nil {
+	key, err := s.keyring.Load().Lookup(token.KeyID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if err := token.Verify(key); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := token.verifyHolder(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return token, nil
+}
+
+// AuthenticateProof authenticates the holder of a token from the proof that
+// it knows the token, computed with Prove over the provided challenge. Only
+// the claims of the token are sent by its holder, along with its signature
+// for tokens signed with an Ed25519 key, which must be proven with their
+// holder key. The signature of other tokens is computed from the claims.
+func (s *Service) AuthenticateProof(ctx context.Context, claims string, signature []byte, challenge []byte, proof []byte, source net.IP) (*AuthenticationResult, error) {
+	parsed, err := ParseClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.keyring.Load().Lookup(parsed.KeyID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	token := &Token{Claims: parsed, Signature: signature}
+	if key.Type != KeyTypeEd25519 {
+		if parsed.Algorithm != "" {
+			return nil, ErrInvalidToken
+		}
+		signed, err := NewToken(parsed, key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		token.Signature = signed.Signature
+	} else if err := token.Verify(key); err != nil {
 		return nil, ErrInvalidToken
 	}
 
+	if err := token.verifyProof(claims, challenge, proof); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return s.authenticate(token, source)
+}
+
+// authenticate checks that a token with a valid signature can be used by
+// source.
+func (s *Service) authenticate(token *Token, source net.IP) (*AuthenticationResult, error) {
 	now := time.Now()
 	if now.After(token.Expiry) {
 		return nil, ErrTokenExpired
@@ -100,67 +227,52 @@ func (s *Service) Authenticate(ctx context.Context, tokenStr string, source net.
 		return nil, ErrInvalidSource
 	}
 
+	if s.db != nil {
+		revoked, err := IsRevoked(s.db, token.RevocationID())
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
 	return &AuthenticationResult{
 		Authenticated: true,
 		Token:         token,
 	}, nil
 }
 
-func (s *Service) loadSecretKey() error {
-	// check if the `secret.key` file exists
-	if _, err := os.Stat(secretKeyFile); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-
-		// if the file doesn't exist, generate a new key
-		key, err := s.generateKey(2048)
-		if err != nil {
-			return err
-		}
+// loadOrCreateKeyring reads the keyring file, creating it if it does not
+// exist. A new keyring starts with the key in the secret key file, if there
+// is one, or with a new key of the configured type.
+func loadOrCreateKeyring(cfg ServiceConfig) (*Keyring, error) {
+	if _, err := os.Stat(cfg.KeyringFile); err == nil || !os.IsNotExist(err) {
+		return LoadKeyring(cfg.KeyringFile)
+	}
 
-		// write the key to a file
-		secretFile, err := os.Create(secretKeyFile)
-		if err != nil {
-			return err
+	keyring := &Keyring{}
+	if cfg.SecretKeyFile != "" {
+		secret, err := os.ReadFile(cfg.SecretKeyFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
 		}
-		defer secretFile.Close()
-
-		_, err = secretFile.Write(key)
-		if err != nil {
-			return err
+		if len(secret) > 0 {
+			key := legacyKey(secret)
+			key.Primary = true
+			keyring.Keys = append(keyring.Keys, key)
 		}
 	}
 
-	// Load the secret key
-	secretFile, err := os.Open(secretKeyFile)
-	if err != nil {
-		return err
-	}
-
-	s.secretKey, err = io.ReadAll(secretFile)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// GenerateHMACKey generates a random key of the specified length for use with HMAC
-func (s *Service) generateKey(length int) ([]byte, error) {
-	// Ensure the key length is valid
-	if length <= 0 {
-		return nil, fmt.Errorf("key length must be greater than 0")
+	if len(keyring.Keys) == 0 {
+		if _, err := keyring.Add(cfg.KeyType, true); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create a byte slice to hold the key
-	key := make([]byte, length)
-
-	// Fill the byte slice with secure random bytes
-	_, err := rand.Read(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate key: %w", err)
+	if err := keyring.Save(cfg.KeyringFile); err != nil {
+		return nil, fmt.Errorf("failed to save keyring: %w", err)
 	}
 
-	return key, nil
+	return keyring, nil
 }
@@ -0,0 +1,122 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+func TestAuthenticateProof(t *testing.T) {
+	dir := t.TempDir()
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	source := net.ParseIP("127.0.0.1")
+	claims := authentication.Claims{
+		AuthorizedNetwork: *network,
+		Expiry:            time.Now().Add(time.Hour),
+	}
+
+	for _, keyType := range []string{authentication.KeyTypeHMAC, authentication.KeyTypeEd25519} {
+		t.Run(keyType, func(t *testing.T) {
+			ctx, err := authentication.ContextWithService(context.Background(), authentication.ServiceConfig{
+				KeyringFile: filepath.Join(dir, keyType+".yaml"),
+				KeyType:     keyType,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, _ := authentication.GetService(ctx)
+
+			token, err := auth.Issue(ctx, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only tokens signed with an Ed25519 key are sent with their
+			// signature.
+			var signature []byte
+			if keyType == authentication.KeyTypeEd25519 {
+				signature = token.Signature
+			}
+			encoded, _ := authentication.SplitToken(token.String())
+			challenge := []byte("challenge of the first connection")
+			proof := token.Prove(challenge)
+
+			result, err := auth.AuthenticateProof(ctx, encoded, signature, challenge, proof, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Token.RevocationID() != token.RevocationID() {
+				t.Errorf("expected token %s, got %s", token.RevocationID(), result.Token.RevocationID())
+			}
+
+			// A proof can't be replayed on a connection with another
+			// challenge, nor made from what its holder sends.
+			second := []byte("challenge of the second connection")
+			sent := (&authentication.Token{Claims: token.Claims, Signature: signature}).PublicString()
+			h := hmac.New(sha256.New, []byte(sent))
+			h.Write(second)
+			for name, proof := range map[string][]byte{
+				"replayed": proof,
+				"guessed":  (&authentication.Token{Claims: token.Claims}).Prove(second),
+				"forged":   h.Sum(nil),
+			} {
+				_, err := auth.AuthenticateProof(ctx, encoded, signature, second, proof, source)
+				if !errors.Is(err, authentication.ErrInvalidProof) {
+					t.Errorf("expected the %s proof to be rejected with %v, got %v", name, authentication.ErrInvalidProof, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateBearerHolder(t *testing.T) {
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	source := net.ParseIP("127.0.0.1")
+	ctx, err := authentication.ContextWithService(context.Background(), authentication.ServiceConfig{
+		KeyringFile: filepath.Join(t.TempDir(), "keyring.yaml"),
+		KeyType:     authentication.KeyTypeEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := authentication.GetService(ctx)
+
+	claims := authentication.Claims{
+		AuthorizedNetwork: *network,
+		Expiry:            time.Now().Add(time.Hour),
+	}
+	token, err := auth.Issue(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.Issue(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.Authenticate(ctx, token.String(), source); err != nil {
+		t.Fatalf("expected the token to authenticate, got %v", err)
+	}
+
+	// The claims and signature sent with a proof can't be replayed as a
+	// bearer token, with or without the holder secret of another token.
+	replayed := token.PublicString()
+	_, otherSecret, _ := strings.Cut(strings.TrimPrefix(other.String(), other.PublicString()), ".")
+	for name, bearer := range map[string]string{
+		"replayed":     replayed,
+		"other secret": replayed + "." + otherSecret,
+	} {
+		_, err := auth.Authenticate(ctx, bearer, source)
+		if !errors.Is(err, authentication.ErrHolderSecretRequired) {
+			t.Errorf("expected the %s token to be rejected with %v, got %v", name, authentication.ErrHolderSecretRequired, err)
+		}
+	}
+}
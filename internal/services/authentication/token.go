@@ -1,10 +1,13 @@
 package authentication
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
@@ -13,15 +16,24 @@ import (
 )
 
 var (
-	ErrMalformedToken = errors.New("malformed token")
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrHolderKeyRequired    = errors.New("token is not bound to a holder key, issue a new token to authenticate with a proof")
+	ErrHolderSecretRequired = errors.New("token does not include the secret of its holder key")
 )
 
+// AlgorithmEdDSA is the algorithm of the tokens signed with an Ed25519 key.
+const AlgorithmEdDSA = "EdDSA"
+
 // Token is a token that can be used to authenticate a peer.
 type Token struct {
 	// Claims is the claims of the token.
 	Claims
 	// Signature is the signature of the token.
 	Signature []byte
+	// HolderSecret is the seed of the private key matching the holder key
+	// of the claims. It is only known to the holder of the token and is
+	// never sent to a server.
+	HolderSecret []byte
 }
 
 func (t *Token) String() string {
@@ -49,17 +61,73 @@ func NewToken(data Claims, key []byte) (*Token, error) {
 	}, nil
 }
 
-// NewTokenFromString creates a new token from the provided string.
+// SignToken creates a new token with the provided claims, signed with the
+// provided key. The token records the ID of the key and the algorithm that
+// signed it. Tokens signed with an Ed25519 key are bound to a new holder
+// key, since their signature is sent along with their proofs.
+func SignToken(data Claims, key Key) (*Token, error) {
+	if !key.CanSign() {
+		return nil, fmt.Errorf("%w: %s", ErrVerifyOnlyKey, key.ID)
+	}
+
+	data.KeyID = key.ID
+	if key.Type != KeyTypeEd25519 {
+		data.Algorithm = ""
+		return NewToken(data, key.Secret)
+	}
+
+	holderKey, holderSecret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate holder key: %w", err)
+	}
+
+	data.Algorithm = AlgorithmEdDSA
+	data.HolderKey = holderKey
+	payload, err := msgpack.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Claims:       data,
+		Signature:    ed25519.Sign(ed25519.PrivateKey(key.Secret), payload),
+		HolderSecret: holderSecret.Seed(),
+	}, nil
+}
+
+// NewTokenID returns a random token ID.
+func NewTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// RevocationID returns the ID used to revoke the token, which is its ID or,
+// for tokens issued without one, the SHA-256 hash of the token.
+func (t *Token) RevocationID() string {
+	if t.ID != "" {
+		return t.ID
+	}
+
+	sum := sha256.Sum256([]byte(t.PublicString()))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewTokenFromString creates a new token from the provided string, which
+// ends with the holder secret of the token if it has one.
 func NewTokenFromString(data string) (*Token, error) {
 	parts := strings.Split(data, ".")
 
-	if len(parts) != 2 {
+	if len(parts) != 2 && len(parts) != 3 {
 		return nil, ErrMalformedToken
 	}
 
-	claimsData, err := base64.StdEncoding.DecodeString(parts[0])
+	claims, err := ParseClaims(parts[0])
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode claims: %w", err)
+		return nil, err
 	}
 
 	signature, err := base64.StdEncoding.DecodeString(parts[1])
@@ -67,17 +135,123 @@ func NewTokenFromString(data string) (*Token, error) {
 		return nil, fmt.Errorf("failed to decode signature: %w", err)
 	}
 
+	token := &Token{
+		Claims:    claims,
+		Signature: signature,
+	}
+
+	if len(parts) == 3 {
+		token.HolderSecret, err = base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode holder secret: %w", err)
+		}
+		if len(token.HolderSecret) != ed25519.SeedSize {
+			return nil, ErrMalformedToken
+		}
+	}
+
+	return token, nil
+}
+
+// ParseClaims parses the claims of a token, which are the part of the token
+// string before the signature.
+func ParseClaims(data string) (Claims, error) {
+	claimsData, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to decode claims: %w", err)
+	}
+
 	// unmarshal the payload to TokenData
 	var claims Claims
 	err = msgpack.Unmarshal(claimsData, &claims)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
+		return Claims{}, fmt.Errorf("failed to unmarshal claims: %w", err)
 	}
 
-	return &Token{
-		Claims:    claims,
-		Signature: signature,
-	}, nil
+	return claims, nil
+}
+
+// SplitToken splits a token string into its claims and its signature.
+func SplitToken(token string) (claims string, signature string) {
+	claims, signature, _ = strings.Cut(token, ".")
+	signature, _, _ = strings.Cut(signature, ".")
+	return claims, signature
+}
+
+// proofContext separates the challenges signed by holder keys from any other
+// message signed with them.
+const proofContext = "coattail authentication proof\x00"
+
+// Prove returns the proof that the holder of a token knows it. Tokens with a
+// holder key are proven with an Ed25519 signature of the challenge by the
+// holder key, and other tokens with an HMAC-SHA256 of the challenge keyed
+// with the token string, whose signature is never sent. The proof is sent
+// instead of the token so that the secret of the token never leaves its
+// holder.
+func (t *Token) Prove(challenge []byte) []byte {
+	if len(t.HolderSecret) == ed25519.SeedSize {
+		return ed25519.Sign(ed25519.NewKeyFromSeed(t.HolderSecret), append([]byte(proofContext), challenge...))
+	}
+
+	return proveHMAC(t.PublicString(), challenge)
+}
+
+// proveHMAC returns the proof of a token without a holder key.
+func proveHMAC(token string, challenge []byte) []byte {
+	h := hmac.New(sha256.New, []byte(token))
+	h.Write(challenge)
+	return h.Sum(nil)
+}
+
+// verifyProof checks a proof returned by Prove for a token whose signature
+// has been verified. claims are the claims of the token as its holder sent
+// them.
+func (t *Token) verifyProof(claims string, challenge []byte, proof []byte) error {
+	if len(t.HolderKey) != 0 {
+		if len(t.HolderKey) != ed25519.PublicKeySize ||
+			!ed25519.Verify(ed25519.PublicKey(t.HolderKey), append([]byte(proofContext), challenge...), proof) {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+
+	// The signature of a token signed with an Ed25519 key is sent along with
+	// the proof, so the token string can't key it.
+	if t.Algorithm != "" {
+		return ErrHolderKeyRequired
+	}
+
+	if !hmac.Equal(proveHMAC(claims+"."+base64.StdEncoding.EncodeToString(t.Signature), challenge), proof) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// verifyHolder checks that a token with a holder key includes the matching
+// holder secret.
+func (t *Token) verifyHolder() error {
+	if len(t.HolderKey) == 0 {
+		return nil
+	}
+
+	if len(t.HolderSecret) != ed25519.SeedSize {
+		return ErrHolderSecretRequired
+	}
+
+	public := ed25519.NewKeyFromSeed(t.HolderSecret).Public().(ed25519.PublicKey)
+	if !public.Equal(ed25519.PublicKey(t.HolderKey)) {
+		return ErrHolderSecretRequired
+	}
+
+	return nil
+}
+
+// PublicString returns the token string without its holder secret, which is
+// the part of the token that is sent along with its proofs.
+func (t *Token) PublicString() string {
+	claims, _ := SplitToken(t.String())
+	return claims + "." + base64.StdEncoding.EncodeToString(t.Signature)
 }
 
 // MarshalString marshals the token into a string.
@@ -90,6 +264,10 @@ func (s *Token) MarshalString() (string, error) {
 	dataEncoded := base64.StdEncoding.EncodeToString(payload)
 	signatureEncoded := base64.StdEncoding.EncodeToString(s.Signature[:])
 
+	if len(s.HolderSecret) != 0 {
+		return dataEncoded + "." + signatureEncoded + "." + base64.StdEncoding.EncodeToString(s.HolderSecret), nil
+	}
+
 	return dataEncoded + "." + signatureEncoded, nil
 }
 
@@ -113,3 +291,28 @@ func (s *Token) VerifySignature(key []byte) error {
 
 	return nil
 }
+
+// Verify verifies the signature of the token with the provided key. The
+// token must have been signed with the algorithm of the key.
+func (s *Token) Verify(key Key) error {
+	if key.Type != KeyTypeEd25519 {
+		if s.Algorithm != "" {
+			return fmt.Errorf("%w: expected an hmac signature, got %s", ErrInvalidSignature, s.Algorithm)
+		}
+		return s.VerifySignature(key.Secret)
+	}
+
+	if s.Algorithm != AlgorithmEdDSA {
+		return fmt.Errorf("%w: expected an %s signature", ErrInvalidSignature, AlgorithmEdDSA)
+	}
+
+	payload, err := msgpack.Marshal(s.Claims)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), payload, s.Signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -19,6 +19,9 @@ const (
 	ReadActions Permission = 1 << iota
 	ReadReceivers
 	ReadPeers
+	// RevokeTokens permits revoking tokens through the api server. It is not
+	// part of All, so it must be granted explicitly.
+	RevokeTokens
 
 	All = ReadActions | ReadReceivers | ReadPeers
 )
@@ -86,6 +89,10 @@ func (s *permissions) String() string {
 		}
 	}
 
+	if s.Has(RevokeTokens) {
+		permissions = append(permissions, "RevokeTokens")
+	}
+
 	return strings.Join(permissions, ", ")
 }
 
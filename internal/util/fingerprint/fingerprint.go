@@ -0,0 +1,50 @@
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidFingerprint = errors.New("invalid fingerprint")
+)
+
+// Of returns the SHA-256 fingerprint of a DER encoded certificate, as
+// colon-separated upper-case hex.
+func Of(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// Parse decodes a SHA-256 fingerprint, given as hex in either case with or
+// without colons, optionally prefixed with "sha256:".
+func Parse(fingerprint string) ([]byte, error) {
+	value := strings.TrimSpace(fingerprint)
+	if len(value) > 7 && strings.EqualFold(value[:7], "sha256:") {
+		value = value[7:]
+	}
+
+	sum, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("%w: %q is not a sha-256 fingerprint", ErrInvalidFingerprint, fingerprint)
+	}
+
+	return sum, nil
+}
+
+// Matches returns true if the DER encoded certificate has the fingerprint
+// returned by Parse.
+func Matches(der []byte, fingerprint []byte) bool {
+	sum := sha256.Sum256(der)
+	return subtle.ConstantTimeCompare(sum[:], fingerprint) == 1
+}
@@ -0,0 +1,39 @@
+package fingerprint_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/fingerprint"
+)
+
+func TestFingerprint(t *testing.T) {
+	der := []byte("certificate")
+	fp := fingerprint.Of(der)
+
+	// Fingerprints are accepted in the forms that tools commonly print.
+	for _, value := range []string{
+		fp,
+		strings.ToLower(fp),
+		strings.ReplaceAll(fp, ":", ""),
+		"SHA256:" + fp,
+	} {
+		pin, err := fingerprint.Parse(value)
+		if err != nil {
+			t.Fatalf("expected %q to be parsed, got %v", value, err)
+		}
+		if !fingerprint.Matches(der, pin) {
+			t.Errorf("expected %q to match", value)
+		}
+		if fingerprint.Matches([]byte("other"), pin) {
+			t.Errorf("expected %q not to match another certificate", value)
+		}
+	}
+
+	for _, value := range []string{"", "AB:CD", "ZZ" + fp[2:]} {
+		if _, err := fingerprint.Parse(value); !errors.Is(err, fingerprint.ErrInvalidFingerprint) {
+			t.Errorf("expected %v for %q, got %v", fingerprint.ErrInvalidFingerprint, value, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter. The bucket holds up to burst
+// tokens and is refilled at rate tokens per second. Each event takes one
+// token from the bucket.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter that allows rate events per second with bursts
+// of up to burst events. If burst is less than 1, it defaults to rate rounded
+// up. Returns nil if rate is not positive, and a nil Limiter allows every
+// event.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket if one is available. If no token is
+// available, Allow returns false and the amount of time until one will be.
+func (l *Limiter) Allow() (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Registry holds a Limiter for each key, all with the same limits. It is
+// used to share a limit between everything with the same key, such as every
+// connection authenticated with the same token.
+type Registry struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    int
+	limiters map[string]*Limiter
+}
+
+// NewRegistry creates a Registry of limiters that allow rate events per
+// second with bursts of up to burst events. Returns nil if rate is not
+// positive, and a nil Registry returns nil limiters.
+func NewRegistry(rate float64, burst int) *Registry {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Registry{
+		rate:     rate,
+		burst:    burst,
+		limiters: map[string]*Limiter{},
+	}
+}
+
+// Get returns the Limiter for key, creating it if necessary.
+func (r *Registry) Get(key string) *Limiter {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limiter, ok := r.limiters[key]
+	if !ok {
+		limiter = NewLimiter(r.rate, r.burst)
+		r.limiters[key] = limiter
+	}
+
+	return limiter
+}
@@ -0,0 +1,49 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := ratelimit.NewLimiter(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow(); !ok {
+			t.Fatalf("expected event %d of the burst to be allowed", i)
+		}
+	}
+
+	ok, retryAfter := limiter.Allow()
+	if ok {
+		t.Fatalf("expected event to be limited once the burst is used up")
+	}
+
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("expected to retry within a second, got %s", retryAfter)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	limiter := ratelimit.NewLimiter(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if ok, _ := limiter.Allow(); !ok {
+			t.Fatalf("expected a nil limiter to allow every event")
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := ratelimit.NewRegistry(1, 1)
+
+	if registry.Get("a") != registry.Get("a") {
+		t.Errorf("expected the same limiter for the same key")
+	}
+
+	if registry.Get("a") == registry.Get("b") {
+		t.Errorf("expected different limiters for different keys")
+	}
+}
@@ -0,0 +1,31 @@
+package version
+
+import (
+	"runtime/debug"
+)
+
+const modulePath = "github.com/nathan-fiscaletti/coattail-go"
+
+// Framework returns the version of the Coattail framework that the running
+// binary was built with, or "(devel)" if it could not be determined.
+func Framework() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return "(devel)"
+			}
+			return dep.Version
+		}
+	}
+
+	return "(devel)"
+}
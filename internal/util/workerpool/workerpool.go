@@ -0,0 +1,193 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("queue full")
+	ErrClosed    = errors.New("worker pool closed")
+)
+
+// Stats describes the state of a Pool.
+type Stats struct {
+	// Workers is the number of workers in the pool.
+	Workers int
+	// Active is the number of tasks being run.
+	Active int64
+	// Queued is the number of tasks waiting for a worker.
+	Queued int
+	// QueueSize is the number of tasks that can wait for a worker.
+	QueueSize int
+	// Completed is the number of tasks that have been run.
+	Completed int64
+	// Rejected is the number of tasks that could not be queued.
+	Rejected int64
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d/%d workers active, %d/%d queued, %d completed, %d rejected",
+		s.Active, s.Workers, s.Queued, s.QueueSize, s.Completed, s.Rejected)
+}
+
+// Pool runs tasks on a fixed number of workers. Tasks wait in a queue of a
+// fixed size until a worker is available.
+type Pool struct {
+	workers   int
+	queue     chan func()
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+	active    atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+}
+
+// New creates a Pool with the provided number of workers and queue size.
+// Returns nil if workers is not positive, and a nil Pool runs every task in
+// a new goroutine.
+func New(workers int, queueSize int) *Pool {
+	if workers <= 0 {
+		return nil
+	}
+
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &Pool{
+		workers: workers,
+		queue:   make(chan func(), queueSize),
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *Pool) work() {
+	for {
+		select {
+		case <-p.done:
+			// Nothing can be queued once the pool is closed, so the workers
+			// exit once the queue is empty.
+			for {
+				select {
+				case task := <-p.queue:
+					p.run(task)
+				default:
+					return
+				}
+			}
+		case task := <-p.queue:
+			p.run(task)
+		}
+	}
+}
+
+func (p *Pool) run(task func()) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+	defer p.completed.Add(1)
+
+	task()
+}
+
+// Submit queues task to be run by a worker, waiting up to timeout for room
+// in the queue. Returns ErrQueueFull if the task could not be queued in time.
+func (p *Pool) Submit(task func(), timeout time.Duration) error {
+	if p == nil {
+		go task()
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	select {
+	case p.queue <- task:
+		return nil
+	default:
+	}
+
+	if timeout <= 0 {
+		p.rejected.Add(1)
+		return ErrQueueFull
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case p.queue <- task:
+		return nil
+	case <-timer.C:
+		p.rejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Run runs task on a worker and waits for it to complete, waiting up to
+// timeout for room in the queue. Returns ErrQueueFull if the task could not
+// be queued in time.
+func (p *Pool) Run(task func(), timeout time.Duration) error {
+	if p == nil {
+		task()
+		return nil
+	}
+
+	done := make(chan struct{})
+	err := p.Submit(func() {
+		defer close(done)
+		task()
+	}, timeout)
+	if err != nil {
+		return err
+	}
+
+	<-done
+	return nil
+}
+
+// Stats returns the current state of the pool.
+func (p *Pool) Stats() Stats {
+	if p == nil {
+		return Stats{}
+	}
+
+	return Stats{
+		Workers:   p.workers,
+		Active:    p.active.Load(),
+		Queued:    len(p.queue),
+		QueueSize: cap(p.queue),
+		Completed: p.completed.Load(),
+		Rejected:  p.rejected.Load(),
+	}
+}
+
+// Close stops accepting tasks. The workers stop once every task that was
+// already queued has been run.
+func (p *Pool) Close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+}
@@ -0,0 +1,74 @@
+package workerpool_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/workerpool"
+)
+
+func TestPoolQueueFull(t *testing.T) {
+	pool := workerpool.New(1, 1)
+	defer pool.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := pool.Submit(func() {
+		close(started)
+		<-release
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The worker is busy, so one task can wait in the queue.
+	if err := pool.Submit(func() {}, 0); err != nil {
+		t.Fatalf("expected the task to be queued, got %v", err)
+	}
+
+	if err := pool.Submit(func() {}, 10*time.Millisecond); !errors.Is(err, workerpool.ErrQueueFull) {
+		t.Errorf("expected %s, got %v", workerpool.ErrQueueFull, err)
+	}
+
+	stats := pool.Stats()
+	if stats.Active != 1 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats: %s", stats)
+	}
+
+	close(release)
+}
+
+func TestPoolCloseRunsQueuedTasks(t *testing.T) {
+	pool := workerpool.New(1, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		if err := pool.Submit(wg.Done, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool.Close()
+	wg.Wait()
+
+	if err := pool.Submit(func() {}, 0); !errors.Is(err, workerpool.ErrClosed) {
+		t.Errorf("expected %s, got %v", workerpool.ErrClosed, err)
+	}
+}
+
+func TestPoolRun(t *testing.T) {
+	pool := workerpool.New(2, 0)
+	defer pool.Close()
+
+	var ran bool
+	if err := pool.Run(func() { ran = true }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ran {
+		t.Errorf("expected the task to have run when Run returned")
+	}
+}
@@ -4,15 +4,12 @@ package coattail
 import (
 	"context"
 	"errors"
-	"net"
-	"reflect"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
-	"github.com/nathan-fiscaletti/coattail-go/internal/database"
 	"github.com/nathan-fiscaletti/coattail-go/internal/host"
-	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
-	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
-	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
 )
 
@@ -20,43 +17,55 @@ var (
 	ErrLocalPeerNotFound = errors.New("local peer not found")
 )
 
-// Run starts the local peer and runs the main function. This function will block forever.
+// Run starts the local peer and runs the main function. This function blocks
+// until the process receives SIGINT or SIGTERM, and then stops the host,
+// waiting up to the shutdown grace period for the requests that are being
+// handled. The configuration of the host is reloaded when the process
+// receives SIGHUP.
+//
+// The host configuration is read from the file given by the --config flag
+// or the COATTAIL_CONFIG environment variable, and from host-config.yaml in
+// the current working directory otherwise. Use New to configure the host
+// from code.
 func Run(app coattailtypes.App) error {
-	if app == nil {
-		app = &coattailtypes.DefaultApp{}
-	}
-
-	ctx, err := createContext(app)
+	opts, err := parseFlags(os.Args[1:])
 	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return err
 	}
 
-	h, err := host.GetHost(ctx)
+	h, err := New(app, opts...)
 	if err != nil {
 		return err
 	}
 
-	// Initialize the local peer in memory for the host.
-	if err := adapters.InitLocalPeer(h); err != nil {
+	if err := h.Start(context.Background()); err != nil {
 		return err
 	}
 
-	// Load the units for the Coattail instance.
-	if err := app.LoadUnits(ctx, h.LocalPeer); err != nil {
-		return err
-	}
-
-	// Start the host and notify
-	if err := h.Start(ctx, func(ctx context.Context, conn net.Conn, logPackets bool) {
-		go packets.NewHandler(ctx, conn, packets.InputRoleServer).HandlePackets(logPackets)
-	}); err != nil {
-		return err
+	// The configuration is reloaded when the process receives SIGHUP.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	// Block until the process is asked to stop.
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+wait:
+	for {
+		select {
+		case <-reload:
+			// Reload logs its result.
+			go h.Reload(context.Background())
+		case <-signals.Done():
+			break wait
+		}
 	}
-	app.OnStart(ctx, h.LocalPeer)
+	stop()
 
-	// Block forever
-	<-ctx.Done()
-	return ctx.Err()
+	return h.Stop(context.Background())
 }
 
 func LocalPeer(ctx context.Context) (*coattailtypes.Peer, error) {
@@ -71,35 +80,3 @@ func LocalPeer(ctx context.Context) (*coattailtypes.Peer, error) {
 
 	return h.LocalPeer, nil
 }
-
-func createContext(app coattailtypes.App) (context.Context, error) {
-	appName := reflect.TypeOf(app).String()
-	if named, ok := app.(coattailtypes.AppWithName); ok {
-		appName = named.Name()
-	}
-
-	ctx, err := logging.ContextWithLogger(context.Background(), appName)
-	if err != nil {
-		return nil, err
-	}
-
-	// Initialize the database
-	ctx, err = database.ContextWithDatabase(ctx, database.DatabaseConfig{
-		Path: "./data.db",
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	ctx, err = host.ContextWithHost(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	ctx, err = authentication.ContextWithService(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return ctx, nil
-}
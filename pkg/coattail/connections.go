@@ -0,0 +1,85 @@
+package coattail
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+)
+
+// connections tracks the connections accepted by the host so that they can
+// be shut down when the host is stopped.
+type connections struct {
+	mu       sync.Mutex
+	handlers map[*packets.Handler]struct{}
+	closed   bool
+}
+
+// add tracks a connection until it is closed. Returns false if the
+// connections have already been shut down.
+func (c *connections) add(handler *packets.Handler) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if c.handlers == nil {
+		c.handlers = map[*packets.Handler]struct{}{}
+	}
+	c.handlers[handler] = struct{}{}
+
+	go func() {
+		<-handler.Done()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.handlers, handler)
+	}()
+
+	return true
+}
+
+// shutdown shuts down every connection, waiting until ctx is done for the
+// requests that are being handled.
+func (c *connections) shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	handlers := make([]*packets.Handler, 0, len(c.handlers))
+	for handler := range c.handlers {
+		handlers = append(handlers, handler)
+	}
+	c.mu.Unlock()
+
+	errs := make([]error, len(handlers))
+	var wg sync.WaitGroup
+	for i, handler := range handlers {
+		wg.Add(1)
+		go func(i int, handler *packets.Handler) {
+			defer wg.Done()
+			errs[i] = handler.Shutdown(ctx)
+		}(i, handler)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// drop closes the connections that authenticated with one of the revoked
+// tokens, by revocation ID. Returns the number of connections closed.
+func (c *connections) drop(revoked map[string]bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for handler := range c.handlers {
+		if id := handler.TokenID(); id != "" && revoked[id] {
+			handler.Close()
+			dropped++
+		}
+	}
+
+	return dropped
+}
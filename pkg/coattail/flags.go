@@ -0,0 +1,73 @@
+package coattail
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+)
+
+// ConfigFileEnv is the environment variable that sets the path of the host
+// configuration read by Run. The --config flag takes precedence over it.
+const ConfigFileEnv = "COATTAIL_CONFIG"
+
+// parseFlags parses the command line arguments of Run into the options of
+// the host. Every field of the host configuration can be set with a flag
+// named after its path, such as --service.address.port, which takes
+// precedence over the configuration file and environment variables.
+func parseFlags(args []string) ([]Option, error) {
+	flags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(ConfigFileEnv), "path of the host configuration (env "+ConfigFileEnv+", default host-config.yaml)")
+	dataDir := flags.String("data-dir", "", "directory that the files of the host are kept in, overriding data_dir in the host configuration")
+
+	var overrides []Option
+	for _, field := range config.Fields() {
+		usage := fmt.Sprintf("sets %s in the host configuration (%s, env %s)", field.Path, field.Type, field.Env)
+		flags.Var(&fieldFlag{field: field, overrides: &overrides}, field.Path, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var opts []Option
+	if *configFile != "" {
+		opts = append(opts, WithHostConfigFile(*configFile))
+	}
+	if *dataDir != "" {
+		opts = append(opts, WithDataDir(*dataDir))
+	}
+	opts = append(opts, overrides...)
+
+	return opts, nil
+}
+
+// fieldFlag is the flag that sets a field of the host configuration.
+type fieldFlag struct {
+	field     config.Field
+	overrides *[]Option
+}
+
+func (f *fieldFlag) String() string {
+	return ""
+}
+
+// Set checks value when it is parsed so that the usage is printed for a
+// value of the wrong type.
+func (f *fieldFlag) Set(value string) error {
+	var scratch config.HostConfig
+	if err := scratch.Set(f.field.Path, value); err != nil {
+		return errors.Unwrap(err)
+	}
+
+	*f.overrides = append(*f.overrides, WithOverride(f.field.Path, value))
+	return nil
+}
+
+// IsBoolFlag lets boolean fields be set with a flag without a value.
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.field.Type == "bool"
+}
@@ -0,0 +1,425 @@
+package coattail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/internal/database"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/packets"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/ratelimit"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/workerpool"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+var (
+	ErrHostStarted = errors.New("host has already been started")
+)
+
+// DefaultShutdownGracePeriod is the default amount of time to wait for the
+// requests that are being handled when the host is stopped.
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// Host is a Coattail instance created with New. Several hosts can run in the
+// same process as long as they are configured with different addresses and
+// files.
+type Host struct {
+	app   coattailtypes.App
+	opts  options
+	files FilesConfig
+	peers []coattailtypes.PeerDetails
+
+	// The configuration is replaced when the host is reloaded, along with
+	// the rate limits shared by the connections authenticated with the same
+	// token when they change.
+	config          atomic.Pointer[HostConfig]
+	tokenRateLimits atomic.Pointer[ratelimit.Registry]
+	reloadMu        sync.Mutex
+
+	mu          sync.Mutex
+	ctx         context.Context
+	host        *host.Host
+	conns       *connections
+	hostWorkers *workerpool.Pool
+	stopping    chan struct{}
+	started     bool
+	stopped     bool
+}
+
+// New creates a Host that runs app. The configuration and peers of the host
+// are read when New is called, from host-config.yaml in the current working
+// directory and the files that it configures unless options say otherwise.
+// The host is not started until Start is called.
+//
+// The fields of a configuration read from a file are overridden by the
+// environment variables named after them, such as
+// COATTAIL_SERVICE_ADDRESS_PORT. The configuration is validated once every
+// override has been applied, and every problem found is returned.
+func New(app coattailtypes.App, opts ...Option) (*Host, error) {
+	if app == nil {
+		app = &coattailtypes.DefaultApp{}
+	}
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	files := o.resolveFiles(cfg)
+
+	peers := o.peers
+	if peers == nil {
+		peers, err = adapters.LoadPeers(files.Peers)
+		if err != nil {
+			return nil, fmt.Errorf("error loading peers: %w", err)
+		}
+	}
+
+	h := &Host{
+		app:   app,
+		opts:  o,
+		files: files,
+		peers: peers,
+	}
+	h.config.Store(cfg)
+
+	return h, nil
+}
+
+// Start starts the host: the units of the app are loaded, the service and
+// the api and web servers start listening, and OnStart is called. Start
+// returns once the host is running. A host can only be started once.
+func (h *Host) Start(ctx context.Context) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.started {
+		return ErrHostStarted
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.started = true
+
+	// The directories of the files that the host creates are created if
+	// they don't exist.
+	for _, file := range []string{h.files.Database, h.files.Keyring, h.files.Certificate, h.files.CertificateKey} {
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return err
+		}
+	}
+
+	cfg := h.config.Load()
+	h.host = host.NewHost(cfg)
+	h.host.CertFile = h.files.Certificate
+	h.host.KeyFile = h.files.CertificateKey
+
+	h.ctx, err = h.createContext()
+	if err != nil {
+		return err
+	}
+
+	// Release what has been started if the host fails to start.
+	defer func() {
+		if err != nil {
+			h.stopped = true
+			h.host.Stop(ctx)
+			if db, dbErr := database.GetDatabase(h.ctx); dbErr == nil {
+				db.Close()
+			}
+		}
+	}()
+
+	// Initialize the local peer in memory for the host.
+	if err := adapters.InitLocalPeer(h.host, h.app, h.peers); err != nil {
+		return err
+	}
+
+	// Load the units for the Coattail instance.
+	if err := h.app.LoadUnits(h.ctx, h.host.LocalPeer); err != nil {
+		return err
+	}
+
+	// The host workers are shared by every connection to the host.
+	workers := cfg.ServiceConfig.Workers
+	h.hostWorkers = packets.NewHostWorkerPool(workers.Host, workers.HostQueueSize)
+
+	// Token rate limits are shared by every connection to the host.
+	tokenRateLimit := cfg.ServiceConfig.RateLimit.Token
+	h.tokenRateLimits.Store(ratelimit.NewRegistry(tokenRateLimit.Rate, tokenRateLimit.Burst))
+
+	// The connections accepted by the host are shut down when it stops.
+	h.conns = &connections{}
+
+	// Start the host and notify
+	if err := h.host.Start(h.ctx, h.connectionHandler()); err != nil {
+		return err
+	}
+
+	// The local peer is reachable at the address that the service is
+	// listening on, which is chosen by the system when the port is 0.
+	h.host.LocalPeer.Address = h.host.Addr().String()
+
+	// The goroutines that run alongside the host stop when it stops.
+	h.stopping = make(chan struct{})
+	if cfg.Reload.Watch {
+		go h.watch(cfg.Reload.Interval, statFiles(h.watchedFiles()), h.stopping)
+	}
+	if auth, err := authentication.GetService(h.ctx); err == nil {
+		go h.dropRevoked(h.ctx, auth, cfg.ServiceConfig.RevocationCheckInterval, h.stopping)
+	}
+
+	h.app.OnStart(h.ctx, h.host.LocalPeer)
+	return nil
+}
+
+// connectionHandler returns the function that handles the connections
+// accepted by the host.
+func (h *Host) connectionHandler() host.ConnectionHandler {
+	return func(ctx context.Context, conn net.Conn, _ bool) {
+		// Connections use the configuration at the time they are accepted,
+		// so that the settings that are reloaded apply to new connections.
+		serviceConfig := h.config.Load().ServiceConfig
+		rateLimit := serviceConfig.RateLimit
+
+		handler, err := packets.NewHandler(ctx, conn, packets.InputRoleServer, packets.HandlerConfig{
+			Codecs:               serviceConfig.Codecs,
+			IdleTimeout:          serviceConfig.IdleTimeout,
+			KeepaliveInterval:    serviceConfig.KeepaliveInterval,
+			Compression:          serviceConfig.Compression,
+			CompressionThreshold: serviceConfig.CompressionThreshold,
+			MaxFrameSize:         serviceConfig.MaxFrameSize,
+			MaxPacketSize:        serviceConfig.MaxPacketSize,
+			RateLimiter:          ratelimit.NewLimiter(rateLimit.Connection.Rate, rateLimit.Connection.Burst),
+			TokenRateLimits:      h.tokenRateLimits.Load(),
+			MaxDecodeFailures:    serviceConfig.MaxDecodeFailures,
+			Workers:              serviceConfig.Workers.Connection,
+			QueueSize:            serviceConfig.Workers.ConnectionQueueSize,
+			QueueTimeout:         serviceConfig.Workers.QueueTimeout,
+			HostWorkers:          h.hostWorkers,
+			AllowBearerTokens:    serviceConfig.AllowBearerTokens,
+		})
+		if err != nil {
+			if logger, _ := logging.GetLogger(ctx); logger != nil {
+				logger.Printf("failed to create connection handler: %s\n", err)
+			}
+			conn.Close()
+			return
+		}
+
+		handler.HandlePackets(serviceConfig.LogPackets)
+		if !h.conns.add(handler) {
+			handler.Close()
+		}
+	}
+}
+
+// Stop stops accepting connections, says goodbye to the connected peers and
+// waits up to the shutdown grace period, or until ctx is done, for the
+// requests that they sent to be handled. OnStop is then called and the
+// database is closed. Stop does nothing if the host is not running.
+func (h *Host) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.started || h.stopped {
+		return nil
+	}
+	h.stopped = true
+
+	if logger, _ := logging.GetLogger(h.ctx); logger != nil {
+		logger.Printf("shutting down\n")
+	}
+
+	if h.stopping != nil {
+		close(h.stopping)
+	}
+
+	graceCtx, cancel := context.WithTimeout(ctx, h.gracePeriod())
+	defer cancel()
+
+	var errs []error
+	if err := h.host.Stop(graceCtx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := h.conns.shutdown(graceCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to wait for requests: %w", err))
+	}
+	h.hostWorkers.Close()
+
+	// The connections to remote peers are closed last, since the requests
+	// that were being handled may have used them.
+	if local, ok := h.host.LocalPeer.PeerAdapter.(*adapters.LocalPeerAdapter); ok {
+		local.Close()
+	}
+
+	h.app.OnStop(h.ctx, h.host.LocalPeer)
+
+	if db, err := database.GetDatabase(h.ctx); err == nil {
+		if err := db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database: %w", err))
+		}
+	}
+
+	if logger, _ := logging.GetLogger(h.ctx); logger != nil {
+		logger.Printf("stopped\n")
+	}
+
+	return errors.Join(errs...)
+}
+
+// gracePeriod returns the amount of time to wait for the requests that are
+// being handled when the host is stopped.
+func (h *Host) gracePeriod() time.Duration {
+	gracePeriod := h.config.Load().ServiceConfig.ShutdownGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultShutdownGracePeriod
+	}
+
+	return gracePeriod
+}
+
+// Context returns the context that the host passes to the app, which carries
+// the services used by the local peer. Returns nil if the host has not been
+// started.
+func (h *Host) Context() context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.ctx
+}
+
+// LocalPeer returns the local peer of the host, or nil if the host has not
+// been started.
+func (h *Host) LocalPeer() *coattailtypes.Peer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.host == nil {
+		return nil
+	}
+
+	return h.host.LocalPeer
+}
+
+// Addr returns the address that the service is listening on, or nil if the
+// host has not been started.
+func (h *Host) Addr() net.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.host == nil {
+		return nil
+	}
+
+	return h.host.Addr()
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the TLS certificate of the
+// service, or an empty string if the host has not been started.
+func (h *Host) Fingerprint() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.host == nil {
+		return ""
+	}
+
+	return h.host.Fingerprint()
+}
+
+// ApiAddr returns the address that the api server is listening on, or nil if
+// the api server is disabled or the host has not been started.
+func (h *Host) ApiAddr() net.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.host == nil {
+		return nil
+	}
+
+	return h.host.ApiAddr()
+}
+
+// WebAddr returns the address that the web server is listening on, or nil if
+// the web server is disabled or the host has not been started.
+func (h *Host) WebAddr() net.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.host == nil {
+		return nil
+	}
+
+	return h.host.WebAddr()
+}
+
+func (h *Host) createContext() (context.Context, error) {
+	appName := reflect.TypeOf(h.app).String()
+	if named, ok := h.app.(coattailtypes.AppWithName); ok {
+		appName = named.Name()
+	}
+
+	ctx, err := logging.ContextWithLogger(context.Background(), appName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize the database
+	ctx, err = database.ContextWithDatabase(ctx, database.DatabaseConfig{
+		Path: h.files.Database,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = host.ContextWithHost(ctx, h.host)
+
+	ctx, err = authentication.ContextWithService(ctx, authentication.ServiceConfig{
+		KeyringFile:   h.files.Keyring,
+		SecretKeyFile: h.files.SecretKey,
+		KeyType:       h.config.Load().ServiceConfig.TokenSigning,
+	})
+	if err != nil {
+		if db, dbErr := database.GetDatabase(ctx); dbErr == nil {
+			db.Close()
+		}
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// validateEncoding checks that the codecs and compression algorithms of the
+// service are supported.
+func validateEncoding(cfg *HostConfig) error {
+	var errs []error
+	for i, name := range cfg.ServiceConfig.Codecs {
+		if _, err := packets.GetCodec(name); err != nil {
+			errs = append(errs, &config.FieldError{Path: fmt.Sprintf("service.codecs[%d]", i), Err: err})
+		}
+	}
+	for i, name := range cfg.ServiceConfig.Compression {
+		if _, err := packets.GetCompressor(name); err != nil {
+			errs = append(errs, &config.FieldError{Path: fmt.Sprintf("service.compression[%d]", i), Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
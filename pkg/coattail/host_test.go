@@ -0,0 +1,642 @@
+package coattail_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/permission"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattail"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+type Echo struct{}
+
+func (Echo) Execute(ctx context.Context, arg *string) (string, error) {
+	return *arg, nil
+}
+
+type echoApp struct {
+	coattailtypes.DefaultApp
+	stopped bool
+}
+
+func (a *echoApp) LoadUnits(ctx context.Context, local *coattailtypes.Peer) error {
+	return local.RegisterAction(ctx, coattailtypes.NewAction[string, string](Echo{}))
+}
+
+func (a *echoApp) OnStop(ctx context.Context, local *coattailtypes.Peer) {
+	a.stopped = true
+}
+
+// newHost creates a host that listens on an ephemeral port and keeps its
+// files in a temporary directory.
+func newHost(t *testing.T, app coattailtypes.App, opts ...coattail.Option) *coattail.Host {
+	t.Helper()
+
+	opts = append([]coattail.Option{
+		coattail.WithHostConfig(coattail.HostConfig{
+			ServiceConfig: coattail.ServiceConfig{
+				Address: coattail.Address{Host: "127.0.0.1"},
+			},
+		}),
+		coattail.WithPeers(),
+		coattail.WithDataDir(filepath.Join(t.TempDir(), "data")),
+	}, opts...)
+
+	h, err := coattail.New(app, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestHost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	app := &echoApp{}
+	server := newHost(t, app)
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+
+	address := server.Addr().String()
+	if server.LocalPeer().Address != address {
+		t.Errorf("expected the local peer to have address %s, got %s", address, server.LocalPeer().Address)
+	}
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Permitted:         permission.PermissionMask(permission.All),
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newHost(t, nil, coattail.WithPeers(coattailtypes.PeerDetails{
+		Address:     address,
+		Token:       token.String(),
+		Fingerprint: server.Fingerprint(),
+	}))
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop(ctx)
+
+	peer, err := client.LocalPeer().GetPeer(client.Context(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := peer.Run(ctx, "Echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "hello" {
+		t.Errorf("expected %q, got %v", "hello", result)
+	}
+
+	// Errors are returned to the caller of RunAndPublish.
+	if err := peer.RunAndPublish(ctx, "Missing", "hello"); !errors.Is(err, coattailtypes.ErrNotFound) {
+		t.Errorf("expected %v, got %v", coattailtypes.ErrNotFound, err)
+	}
+	if err := peer.RunAndPublish(ctx, "Echo", "hello"); err != nil {
+		t.Errorf("expected RunAndPublish to succeed, got %v", err)
+	}
+
+	if err := client.Stop(ctx); err != nil {
+		t.Errorf("expected the client to stop, got %v", err)
+	}
+	if err := server.Stop(ctx); err != nil {
+		t.Errorf("expected the server to stop, got %v", err)
+	}
+	if !app.stopped {
+		t.Errorf("expected OnStop to be called")
+	}
+	if err := server.Start(ctx); err != coattail.ErrHostStarted {
+		t.Errorf("expected %s, got %v", coattail.ErrHostStarted, err)
+	}
+}
+
+type Shout struct{}
+
+func (Shout) Execute(ctx context.Context, arg *string) (string, error) {
+	return strings.ToUpper(*arg), nil
+}
+
+type shoutApp struct {
+	echoApp
+}
+
+func (a *shoutApp) LoadUnits(ctx context.Context, local *coattailtypes.Peer) error {
+	if err := a.echoApp.LoadUnits(ctx, local); err != nil {
+		return err
+	}
+	return local.RegisterAction(ctx, coattailtypes.NewAction[string, string](Shout{}))
+}
+
+func TestListUnitsScoped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := newHost(t, &shoutApp{})
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+	address := server.Addr().String()
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Permitted:         permission.PermissionMask(permission.All),
+		Authorizations: []authentication.Authorization{{
+			Type:       authentication.Action,
+			Operations: []authentication.AuthorizedOperation{authentication.Run},
+			Name:       "Echo",
+		}},
+		Expiry: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newHost(t, nil, coattail.WithPeers(coattailtypes.PeerDetails{
+		Address:     address,
+		Token:       token.String(),
+		Fingerprint: server.Fingerprint(),
+	}))
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop(ctx)
+
+	peer, err := client.LocalPeer().GetPeer(client.Context(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the actions that the token is scoped to are listed.
+	actions, err := peer.ListActions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0] != "Echo" {
+		t.Errorf("expected only Echo to be listed, got %v", actions)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	_, err := coattail.New(nil,
+		coattail.WithHostConfig(coattail.HostConfig{
+			ServiceConfig: coattail.ServiceConfig{
+				Codecs: []string{"gob", "xml"},
+			},
+		}),
+		coattail.WithPeers(),
+		coattail.WithOverride("service.address.port", "70000"),
+		coattail.WithOverride("service.compression", "zstd,brotli"),
+	)
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+
+	// Every problem is reported at once.
+	for _, expected := range []string{
+		"service.address.port: must be between 0 and 65535",
+		"service.codecs[1]: unknown codec xml",
+		"service.compression[1]: unknown compression brotli",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "host-config.yaml")
+	peersFile := filepath.Join(dir, "peers.yaml")
+	writeFile := func(path, data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(configFile, `
+service:
+  address:
+    host: 127.0.0.1
+reload:
+  watch: true
+  interval: 10ms
+`)
+
+	h, err := coattail.New(nil, coattail.WithHostConfigFile(configFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop(ctx)
+	address := h.Addr().String()
+
+	// Peers are reloaded when the peers file changes.
+	writeFile(peersFile, `
+peers:
+  - address: 127.0.0.1:5243
+    token: abc
+`)
+	for {
+		peers, err := h.LocalPeer().ListPeers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(peers) == 1 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("expected the peers to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Settings that require a restart keep their values.
+	writeFile(configFile, `
+service:
+  address:
+    host: 127.0.0.1
+    port: 5243
+`)
+	if err := h.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h.Addr().String() != address {
+		t.Errorf("expected the host to keep listening at %s, got %s", address, h.Addr())
+	}
+
+	// Nothing is applied from an invalid configuration.
+	writeFile(configFile, `
+service:
+  max_frame_size: -1
+`)
+	if err := h.Reload(ctx); err == nil {
+		t.Error("expected the configuration to be invalid")
+	}
+
+	if err := h.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reload(ctx); !errors.Is(err, coattail.ErrHostNotRunning) {
+		t.Errorf("expected %v, got %v", coattail.ErrHostNotRunning, err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := newHost(t, &echoApp{})
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+	address := server.Addr().String()
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Permitted:         permission.PermissionMask(permission.All),
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newHost(t, nil, coattail.WithPeers(coattailtypes.PeerDetails{
+		Address:        address,
+		Token:          token.String(),
+		Fingerprint:    server.Fingerprint(),
+		ConnectTimeout: time.Second,
+	}))
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop(ctx)
+
+	peer, err := client.LocalPeer().GetPeer(client.Context(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Run(ctx, "Echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := authentication.GetService(server.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Revoke(ctx, token.RevocationID(), "leaked", token.Expiry); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is dropped and can't be re-established with the
+	// revoked token.
+	pool := peer.PeerAdapter.(coattailtypes.PeerAdapterWithConnections)
+	for pool.PoolStats().Connected > 0 {
+		if ctx.Err() != nil {
+			t.Fatal("expected the connection to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = peer.Run(ctx, "Echo", "hello")
+	if !errors.Is(err, coattailtypes.ErrPeerUnavailable) || !strings.Contains(err.Error(), authentication.ErrTokenRevoked.Error()) {
+		t.Errorf("expected the peer to be unavailable because the token was revoked, got %v", err)
+	}
+}
+
+func TestRevokeTokenApi(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := newHost(t, &echoApp{}, coattail.WithHostConfig(coattail.HostConfig{
+		ServiceConfig: coattail.ServiceConfig{
+			Address:           coattail.Address{Host: "127.0.0.1"},
+			AllowBearerTokens: true,
+		},
+		ApiConfig: coattail.ApiConfig{
+			Enabled: true,
+			Address: coattail.Address{Host: "127.0.0.1"},
+		},
+	}))
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	issue := func(permitted ...permission.Permission) string {
+		t.Helper()
+
+		token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+			AuthorizedNetwork: *network,
+			Permitted:         permission.PermissionMask(permitted...),
+			Expiry:            time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.String()
+	}
+	admin := issue(permission.RevokeTokens)
+	user := issue(permission.All)
+
+	revoke := func(bearer, body string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, "http://"+server.ApiAddr().String()+"/tokens/revoke", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A token that was not signed by the host can't be revoked by value.
+	claims, _ := authentication.SplitToken(user)
+	forged := claims + ".Zm9yZ2Vk"
+
+	for _, test := range []struct {
+		name   string
+		bearer string
+		body   string
+		status int
+	}{
+		{"unauthenticated", "", `{"token": "` + user + `"}`, http.StatusUnauthorized},
+		{"not permitted", user, `{"token": "` + user + `"}`, http.StatusForbidden},
+		{"forged", admin, `{"token": "` + forged + `"}`, http.StatusBadRequest},
+		{"too large", admin, `{"reason": "` + strings.Repeat("a", 1<<20) + `"}`, http.StatusBadRequest},
+		{"revoked", admin, `{"token": "` + user + `"}`, http.StatusOK},
+		{"revoked bearer", user, `{"token": "` + admin + `"}`, http.StatusUnauthorized},
+	} {
+		if status := revoke(test.bearer, test.body); status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, status)
+		}
+	}
+}
+
+func TestRevokeTokenApiBearer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	for _, test := range []struct {
+		name              string
+		tokenSigning      string
+		allowBearerTokens bool
+		status            int
+	}{
+		// Tokens are sent in cleartext to the api.
+		{"bearer tokens not allowed", "hmac", false, http.StatusForbidden},
+		{"holder key", "ed25519", true, http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newHost(t, &echoApp{}, coattail.WithHostConfig(coattail.HostConfig{
+				ServiceConfig: coattail.ServiceConfig{
+					Address:           coattail.Address{Host: "127.0.0.1"},
+					TokenSigning:      test.tokenSigning,
+					AllowBearerTokens: test.allowBearerTokens,
+				},
+				ApiConfig: coattail.ApiConfig{
+					Enabled: true,
+					Address: coattail.Address{Host: "127.0.0.1"},
+				},
+			}))
+			if err := server.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Stop(ctx)
+
+			admin, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+				AuthorizedNetwork: *network,
+				Permitted:         permission.PermissionMask(permission.RevokeTokens),
+				Expiry:            time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "http://"+server.ApiAddr().String()+"/tokens/revoke", strings.NewReader(`{"id": "unknown"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+admin.String())
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestGeneratedCertificate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	server := newHost(t, &echoApp{}, coattail.WithDataDir(dir))
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+	address := server.Addr().String()
+
+	if info, err := os.Stat(filepath.Join(dir, "server.key")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the private key to only be readable by its owner, got %v", info)
+	}
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Permitted:         permission.PermissionMask(permission.All),
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is valid for the address of the server, so it can be
+	// trusted as a certificate authority instead of being pinned.
+	client := newHost(t, nil, coattail.WithPeers(coattailtypes.PeerDetails{
+		Address:        address,
+		Token:          token.String(),
+		CA:             filepath.Join(dir, "server.crt"),
+		ConnectTimeout: time.Second,
+	}))
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop(ctx)
+
+	peer, err := client.LocalPeer().GetPeer(client.Context(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Run(ctx, "Echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayedAuthentication(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	server := newHost(t, &echoApp{}, coattail.WithDataDir(dir))
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop(ctx)
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	token, err := server.LocalPeer().IssueToken(server.Context(), authentication.Claims{
+		AuthorizedNetwork: *network,
+		Permitted:         permission.PermissionMask(permission.All),
+		Expiry:            time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The relay terminates the TLS connection of the client and forwards
+	// what it receives to the server over its own TLS connection.
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	go func() {
+		for {
+			conn, err := relay.Accept()
+			if err != nil {
+				return
+			}
+
+			upstream, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				conn.Close()
+			}()
+		}
+	}()
+
+	address := relay.Addr().String()
+	client := newHost(t, nil, coattail.WithPeers(coattailtypes.PeerDetails{
+		Address:        address,
+		Token:          token.String(),
+		Fingerprint:    server.Fingerprint(),
+		ConnectTimeout: time.Second,
+	}))
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop(ctx)
+
+	peer, err := client.LocalPeer().GetPeer(client.Context(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The proof of the client is bound to its connection to the relay, so
+	// the server rejects it.
+	_, err = peer.Run(ctx, "Echo", "hello")
+	if !errors.Is(err, coattailtypes.ErrPeerUnavailable) || !strings.Contains(err.Error(), authentication.ErrInvalidProof.Error()) {
+		t.Errorf("expected the peer to be unavailable because the proof is invalid, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package coattail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailtypes"
+)
+
+// The types used to configure a Host. These are the types that
+// host-config.yaml is read into.
+type (
+	HostConfig      = config.HostConfig
+	ServiceConfig   = config.ServiceConfig
+	ApiConfig       = config.ApiConfig
+	WebConfig       = config.WebConfig
+	Address         = config.Address
+	RateLimitConfig = config.RateLimitConfig
+	RateLimit       = config.RateLimit
+	WorkersConfig   = config.WorkersConfig
+	FilesConfig     = config.FilesConfig
+)
+
+// Option configures a Host created with New.
+type Option func(*options)
+
+// options override the configuration of a host. The locations of files that
+// are not set are read from the configuration.
+type options struct {
+	hostConfig     *HostConfig
+	hostConfigFile string
+	dataDir        string
+	peers          []coattailtypes.PeerDetails
+	files          FilesConfig
+	overrides      []override
+}
+
+// override sets the field of the host configuration at path to value.
+type override struct {
+	path  string
+	value string
+}
+
+func defaultOptions() options {
+	return options{
+		hostConfigFile: config.DefaultHostConfigFile,
+	}
+}
+
+// resolveFiles returns the locations of the files of a host configured with
+// cfg, with the locations set by the options taking precedence.
+func (o options) resolveFiles(cfg *HostConfig) FilesConfig {
+	files := cfg.ResolveFiles()
+	if o.files.Database != "" {
+		files.Database = o.files.Database
+	}
+	if o.files.Keyring != "" {
+		files.Keyring = o.files.Keyring
+	}
+	if o.files.SecretKey != "" {
+		files.SecretKey = o.files.SecretKey
+	}
+	if o.files.Certificate != "" {
+		files.Certificate = o.files.Certificate
+	}
+	if o.files.CertificateKey != "" {
+		files.CertificateKey = o.files.CertificateKey
+	}
+	if o.files.Peers != "" {
+		files.Peers = o.files.Peers
+	}
+
+	return files
+}
+
+// loadConfig returns the configuration of the host, read from the
+// configuration file unless one was given, with the overrides applied.
+// Every problem found with the configuration is returned.
+func (o options) loadConfig() (*HostConfig, error) {
+	var (
+		cfg  *HostConfig
+		errs []error
+	)
+	if o.hostConfig != nil {
+		// The configuration is copied so that it is not changed by the
+		// overrides.
+		copied := *o.hostConfig
+		cfg = &copied
+	} else {
+		var err error
+		cfg, err = config.LoadHostConfig(o.hostConfigFile)
+		if err != nil {
+			return nil, err
+		}
+
+		errs = append(errs, cfg.ApplyEnv(os.LookupEnv))
+	}
+
+	for _, override := range o.overrides {
+		errs = append(errs, cfg.Set(override.path, override.value))
+	}
+	if o.dataDir != "" {
+		cfg.DataDir = o.dataDir
+	}
+
+	errs = append(errs, cfg.Validate(), validateEncoding(cfg))
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid host configuration:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+// WithHostConfig configures the host with cfg instead of reading
+// host-config.yaml. A port of 0 listens on a port chosen by the system, which
+// can be retrieved from the Host once it has been started.
+func WithHostConfig(cfg HostConfig) Option {
+	return func(o *options) {
+		o.hostConfig = &cfg
+	}
+}
+
+// WithHostConfigFile reads the configuration of the host from path instead
+// of host-config.yaml.
+func WithHostConfigFile(path string) Option {
+	return func(o *options) {
+		o.hostConfigFile = path
+	}
+}
+
+// WithDataDir keeps the files of the host in dir instead of the data
+// directory set in the configuration.
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.dataDir = dir
+	}
+}
+
+// WithOverride sets the field of the host configuration at path, such as
+// service.address.port, to value. Lists are separated by commas. Overrides
+// are applied after the environment variables, in the order they are given.
+func WithOverride(path, value string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, override{path: path, value: value})
+	}
+}
+
+// WithPeers configures the remote peers of the host instead of reading
+// peers.yaml.
+func WithPeers(peers ...coattailtypes.PeerDetails) Option {
+	return func(o *options) {
+		o.peers = append([]coattailtypes.PeerDetails{}, peers...)
+	}
+}
+
+// WithPeersFile reads the remote peers of the host from path instead of the
+// file set in the configuration.
+func WithPeersFile(path string) Option {
+	return func(o *options) {
+		o.files.Peers = path
+	}
+}
+
+// WithDatabaseFile stores the database of the host at path instead of the
+// file set in the configuration.
+func WithDatabaseFile(path string) Option {
+	return func(o *options) {
+		o.files.Database = path
+	}
+}
+
+// WithKeyringFile reads the keys used to sign and verify tokens from path
+// instead of the file set in the configuration. The keyring is created if
+// the file does not exist.
+func WithKeyringFile(path string) Option {
+	return func(o *options) {
+		o.files.Keyring = path
+	}
+}
+
+// WithSecretKeyFile reads the key that is imported into a new keyring from
+// path instead of the file set in the configuration.
+func WithSecretKeyFile(path string) Option {
+	return func(o *options) {
+		o.files.SecretKey = path
+	}
+}
+
+// WithCertificateFiles reads the TLS certificate of the service and its
+// private key from certFile and keyFile instead of the files set in the
+// configuration. A self-signed certificate is generated if either is
+// missing.
+func WithCertificateFiles(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.files.Certificate = certFile
+		o.files.CertificateKey = keyFile
+	}
+}
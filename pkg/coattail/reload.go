@@ -0,0 +1,204 @@
+package coattail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/adapters"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/nathan-fiscaletti/coattail-go/internal/util/ratelimit"
+)
+
+var (
+	ErrHostNotRunning = errors.New("host is not running")
+)
+
+// DefaultReloadInterval is the default interval at which the files watched
+// by a host are checked for changes.
+const DefaultReloadInterval = 2 * time.Second
+
+// Reload reads the configuration of the host, its peers, the keyring and the
+// TLS certificate of the service again, and applies them while the host keeps
+// running. Nothing is applied if the configuration is invalid.
+//
+// Peers can be added, removed or have their details, such as their token,
+// changed. The connections to the peers that were removed or changed are
+// closed once the calls in flight on them have completed, waiting up to the
+// shutdown grace period or until ctx is done. Changes to the other settings
+// apply to the connections accepted afterwards. Settings that only take
+// effect when the host is started, such as its addresses, keep their
+// current values until it is restarted. The result of the reload is logged.
+func (h *Host) Reload(ctx context.Context) error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	h.mu.Lock()
+	running := h.started && !h.stopped
+	hostCtx, server := h.ctx, h.host
+	h.mu.Unlock()
+
+	if !running {
+		return ErrHostNotRunning
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.gracePeriod())
+	defer cancel()
+
+	old := h.config.Load()
+	cfg, err := h.opts.loadConfig()
+	if err != nil {
+		if logger, _ := logging.GetLogger(hostCtx); logger != nil {
+			logger.Printf("failed to reload configuration: %s\n", err)
+		}
+		return err
+	}
+
+	ignored := keepStartupSettings(old, cfg)
+	changed := config.Diff(old, cfg)
+	files := h.opts.resolveFiles(cfg)
+
+	var errs []error
+	if err := server.ReloadCertificate(files.Certificate, files.CertificateKey); err != nil {
+		errs = append(errs, err)
+	}
+
+	if auth, err := authentication.GetService(hostCtx); err == nil {
+		if err := auth.ReloadKeyring(); err != nil {
+			errs = append(errs, fmt.Errorf("error loading keyring: %w", err))
+		}
+	}
+
+	tokenRateLimit := cfg.ServiceConfig.RateLimit.Token
+	if tokenRateLimit != old.ServiceConfig.RateLimit.Token {
+		h.tokenRateLimits.Store(ratelimit.NewRegistry(tokenRateLimit.Rate, tokenRateLimit.Burst))
+	}
+	h.config.Store(cfg)
+
+	// Peers that were configured with WithPeers are not reloaded.
+	var peersChange adapters.PeersChange
+	if h.opts.peers == nil {
+		peers, err := adapters.LoadPeers(files.Peers)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error loading peers: %w", err))
+		} else if local, ok := server.LocalPeer.PeerAdapter.(*adapters.LocalPeerAdapter); ok {
+			peersChange = local.UpdatePeers(ctx, peers)
+		}
+	}
+
+	if logger, _ := logging.GetLogger(hostCtx); logger != nil {
+		logger.Printf("reloaded configuration: %d settings changed %v, peers: %s\n", len(changed), changed, peersChange)
+		if len(ignored) > 0 {
+			logger.Printf("settings that require a restart were not changed: %v\n", ignored)
+		}
+		for _, err := range errs {
+			logger.Printf("failed to reload configuration: %s\n", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// keepStartupSettings restores the settings of cfg that only take effect
+// when the host is started to their values in old, and returns the paths of
+// the ones that had changed.
+func keepStartupSettings(old, cfg *HostConfig) []string {
+	loaded := *cfg
+
+	cfg.ServiceConfig.Address = old.ServiceConfig.Address
+	cfg.ServiceConfig.CertificateHosts = old.ServiceConfig.CertificateHosts
+	cfg.ServiceConfig.Workers.Host = old.ServiceConfig.Workers.Host
+	cfg.ServiceConfig.Workers.HostQueueSize = old.ServiceConfig.Workers.HostQueueSize
+	cfg.ServiceConfig.RevocationCheckInterval = old.ServiceConfig.RevocationCheckInterval
+	cfg.ServiceConfig.TokenSigning = old.ServiceConfig.TokenSigning
+	cfg.ApiConfig = old.ApiConfig
+	cfg.WebConfig = old.WebConfig
+	cfg.DataDir = old.DataDir
+	cfg.Files.Database = old.Files.Database
+	cfg.Files.Keyring = old.Files.Keyring
+	cfg.Files.SecretKey = old.Files.SecretKey
+	cfg.Reload = old.Reload
+
+	return config.Diff(&loaded, cfg)
+}
+
+// watch reloads the host when one of the files that it is configured with
+// changes from the states in seen, checking them at interval until stop is
+// closed.
+func (h *Host) watch(interval time.Duration, seen map[string]fileState, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		current := statFiles(h.watchedFiles())
+		if equalFileStates(seen, current) {
+			continue
+		}
+		seen = current
+
+		// Reload logs its result.
+		h.Reload(context.Background())
+	}
+}
+
+// watchedFiles returns the files that the host reloads when they change.
+func (h *Host) watchedFiles() []string {
+	files := h.opts.resolveFiles(h.config.Load())
+
+	watched := []string{files.Keyring, files.Certificate, files.CertificateKey}
+	if h.opts.hostConfig == nil {
+		watched = append(watched, h.opts.hostConfigFile)
+	}
+	if h.opts.peers == nil {
+		watched = append(watched, files.Peers)
+	}
+
+	return watched
+}
+
+// fileState is what is used to tell whether a file has changed. Files that
+// don't exist have the zero state.
+type fileState struct {
+	modTime int64
+	size    int64
+}
+
+func statFiles(paths []string) map[string]fileState {
+	states := map[string]fileState{}
+	for _, path := range paths {
+		var state fileState
+		if info, err := os.Stat(path); err == nil {
+			state = fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+		states[path] = state
+	}
+
+	return states
+}
+
+func equalFileStates(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, state := range a {
+		if other, ok := b[path]; !ok || other != state {
+			return false
+		}
+	}
+
+	return true
+}
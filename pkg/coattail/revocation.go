@@ -0,0 +1,63 @@
+package coattail
+
+import (
+	"context"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/logging"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+)
+
+// DefaultRevocationCheckInterval is the default interval at which the host
+// checks for tokens revoked by other processes.
+const DefaultRevocationCheckInterval = 10 * time.Second
+
+// dropRevoked closes the connections that authenticated with a token that
+// has been revoked, until stop is closed. Revocations are checked whenever
+// the host revokes a token, and at interval to find the tokens revoked by
+// other processes, such as coattail token revoke. The revocations of tokens
+// that have expired are forgotten.
+func (h *Host) dropRevoked(ctx context.Context, auth *authentication.Service, interval time.Duration, stop <-chan struct{}) {
+	if interval == 0 {
+		interval = DefaultRevocationCheckInterval
+	}
+
+	// A negative interval only checks the tokens revoked by the host.
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-auth.Revoked():
+		case <-tick:
+		case <-stop:
+			return
+		}
+
+		logger, _ := logging.GetLogger(ctx)
+		if _, err := auth.PruneRevokedTokens(ctx); err != nil && logger != nil {
+			logger.Printf("failed to forget expired revoked tokens: %s\n", err)
+		}
+
+		revokedTokens, err := auth.RevokedTokens(ctx)
+		if err != nil {
+			if logger != nil {
+				logger.Printf("failed to check revoked tokens: %s\n", err)
+			}
+			continue
+		}
+
+		revoked := map[string]bool{}
+		for _, token := range revokedTokens {
+			revoked[token.ID] = true
+		}
+
+		if dropped := h.conns.drop(revoked); dropped > 0 && logger != nil {
+			logger.Printf("closed %d connections authenticated with revoked tokens\n", dropped)
+		}
+	}
+}
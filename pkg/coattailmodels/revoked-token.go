@@ -0,0 +1,20 @@
+package coattailmodels
+
+import "time"
+
+// RevokedToken is a token that can no longer be used to authenticate.
+type RevokedToken struct {
+	// ID is the ID of the token that was revoked.
+	ID string `gorm:"primaryKey" json:"id"`
+
+	// Reason describes why the token was revoked.
+	Reason string `json:"reason,omitempty"`
+
+	// Expiry is when the token expires, after which it no longer needs to
+	// be revoked. It is zero if the expiry of the token is unknown, in which
+	// case the token stays revoked.
+	Expiry time.Time `json:"expiry,omitempty"`
+
+	// RevokedAt is when the token was revoked.
+	RevokedAt time.Time `json:"revoked_at"`
+}
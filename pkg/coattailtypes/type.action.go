@@ -2,6 +2,7 @@ package coattailtypes
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 )
 
@@ -23,8 +24,12 @@ type ActionUnit[
 func (a *ActionUnit[A, R]) Execute(ctx context.Context, args any) (any, error) {
 	var argument *A
 
-	if argsAny, ok := args.(A); ok {
-		argument = &argsAny
+	if args != nil {
+		argsValue, err := ConvertValue[A](args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+		}
+		argument = &argsValue
 	}
 
 	return a.action.Execute(ctx, argument)
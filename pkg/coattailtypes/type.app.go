@@ -8,6 +8,10 @@ type App interface {
 	// LoadUnits is called after the application has been started and should be
 	// used to load the units for the Coattail instance.
 	LoadUnits(ctx context.Context, local *Peer) error
+	// OnStop is called after the application has been stopped, once the
+	// requests that were being handled are done and before the database is
+	// closed.
+	OnStop(ctx context.Context, local *Peer)
 }
 
 type AppWithName interface {
@@ -24,3 +28,7 @@ func (a *DefaultApp) OnStart(ctx context.Context, local *Peer) {
 func (a *DefaultApp) LoadUnits(ctx context.Context, local *Peer) error {
 	return nil
 }
+
+func (a *DefaultApp) OnStop(ctx context.Context, local *Peer) {
+	// Not implemented by default.
+}
@@ -0,0 +1,102 @@
+package coattailtypes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrPeerUnavailable is returned when a call to a remote peer fails
+	// because no connection to the peer could be established in time.
+	ErrPeerUnavailable = errors.New("peer unavailable")
+)
+
+// ConnectionState is the state of the connection to a remote peer.
+type ConnectionState int
+
+const (
+	// ConnectionStateDisconnected means that there is no connection to the
+	// peer. A new connection will be attempted once the backoff has passed.
+	ConnectionStateDisconnected ConnectionState = iota
+	// ConnectionStateConnecting means that a connection to the peer is being
+	// established and authenticated.
+	ConnectionStateConnecting
+	// ConnectionStateConnected means that the connection to the peer is
+	// established and authenticated.
+	ConnectionStateConnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateDisconnected:
+		return "disconnected"
+	case ConnectionStateConnecting:
+		return "connecting"
+	case ConnectionStateConnected:
+		return "connected"
+	}
+
+	return "unknown"
+}
+
+// ConnectionStateChange describes a change in the state of the connection to
+// a remote peer.
+type ConnectionStateChange struct {
+	// Peer is the peer that the connection is to.
+	Peer PeerDetails
+	// State is the new state of the connection.
+	State ConnectionState
+	// Err is the reason that the connection was lost or could not be
+	// established, if any.
+	Err error
+	// RetryIn is the amount of time until the next connection attempt when
+	// the state is ConnectionStateDisconnected.
+	RetryIn time.Duration
+}
+
+// AppWithConnectionState can be implemented by an App to be notified when the
+// state of the connection to a remote peer changes.
+type AppWithConnectionState interface {
+	OnConnectionStateChange(ctx context.Context, change ConnectionStateChange)
+}
+
+// PoolStats describes the pool of connections to a remote peer.
+type PoolStats struct {
+	// Connections is the number of connections in the pool, whether they are
+	// connected or not.
+	Connections int `json:"connections"`
+	// Connected is the number of connections that are connected.
+	Connected int `json:"connected"`
+	// Idle is the number of connected connections with no calls in flight.
+	Idle int `json:"idle"`
+	// InFlight is the number of calls waiting for a response.
+	InFlight int `json:"in_flight"`
+	// MinConnections is the number of connections kept open.
+	MinConnections int `json:"min_connections"`
+	// MaxConnections is the maximum number of connections.
+	MaxConnections int `json:"max_connections"`
+	// Opened is the number of connections that have been established,
+	// including reconnections.
+	Opened uint64 `json:"opened"`
+	// Reaped is the number of connections that have been closed for being
+	// idle.
+	Reaped uint64 `json:"reaped"`
+}
+
+func (s PoolStats) String() string {
+	return fmt.Sprintf("%d/%d connections connected (%d-%d), %d idle, %d calls in flight, %d opened, %d reaped",
+		s.Connected, s.Connections, s.MinConnections, s.MaxConnections, s.Idle, s.InFlight, s.Opened, s.Reaped)
+}
+
+// PeerAdapterWithConnections is implemented by the adapters of remote peers,
+// which manage a pool of connections to the peer.
+type PeerAdapterWithConnections interface {
+	// ConnectionState returns the state of the connections to the peer. The
+	// peer is connected as long as any connection in its pool is connected.
+	ConnectionState() ConnectionState
+
+	// PoolStats returns statistics about the pool of connections to the peer.
+	PoolStats() PoolStats
+}
@@ -0,0 +1,184 @@
+package coattailtypes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an action, receiver or peer could not be
+	// found.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when the token used to connect to a peer
+	// does not authorize the requested operation.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnauthenticated is returned when a connection to a peer could not be
+	// authenticated.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrInvalidRequest is returned when a peer receives a request that it
+	// cannot process.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrApplication is returned when an action or receiver returns an error.
+	ErrApplication = errors.New("application error")
+	// ErrInternal is returned when a peer fails to process a request for a
+	// reason unrelated to the request itself.
+	ErrInternal = errors.New("internal error")
+	// ErrRateLimited is returned when a peer rejects a request because the
+	// connection or token used to send it has exceeded its rate limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrOverloaded is returned when a peer rejects a request because it is
+	// already handling as many requests as it can.
+	ErrOverloaded = errors.New("overloaded")
+	// ErrShuttingDown is returned when a peer rejects a request because it
+	// is shutting down.
+	ErrShuttingDown = errors.New("shutting down")
+)
+
+// ErrorCode identifies the category of an error returned by a remote peer.
+type ErrorCode int
+
+const (
+	ErrorCodeInternal ErrorCode = iota
+	ErrorCodeApplication
+	ErrorCodeNotFound
+	ErrorCodeUnauthorized
+	ErrorCodeUnauthenticated
+	ErrorCodeInvalidRequest
+	ErrorCodeRateLimited
+	ErrorCodeOverloaded
+	ErrorCodeShuttingDown
+)
+
+var errorCodeSentinels = map[ErrorCode]error{
+	ErrorCodeInternal:        ErrInternal,
+	ErrorCodeApplication:     ErrApplication,
+	ErrorCodeNotFound:        ErrNotFound,
+	ErrorCodeUnauthorized:    ErrUnauthorized,
+	ErrorCodeUnauthenticated: ErrUnauthenticated,
+	ErrorCodeInvalidRequest:  ErrInvalidRequest,
+	ErrorCodeRateLimited:     ErrRateLimited,
+	ErrorCodeOverloaded:      ErrOverloaded,
+	ErrorCodeShuttingDown:    ErrShuttingDown,
+}
+
+func (c ErrorCode) String() string {
+	if err, ok := errorCodeSentinels[c]; ok {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("error code %d", int(c))
+}
+
+// ErrorCodeOf returns the ErrorCode that best describes err.
+func ErrorCodeOf(err error) ErrorCode {
+	// Errors returned by units are always application errors, even if they
+	// wrap an error returned by another peer.
+	if errors.Is(err, ErrApplication) {
+		return ErrorCodeApplication
+	}
+
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Code
+	}
+
+	for _, code := range []ErrorCode{
+		ErrorCodeNotFound,
+		ErrorCodeUnauthorized,
+		ErrorCodeUnauthenticated,
+		ErrorCodeInvalidRequest,
+		ErrorCodeRateLimited,
+		ErrorCodeOverloaded,
+		ErrorCodeShuttingDown,
+	} {
+		if errors.Is(err, errorCodeSentinels[code]) {
+			return code
+		}
+	}
+
+	return ErrorCodeInternal
+}
+
+// ErrorWithDetails can be implemented by errors returned from actions and
+// receivers to attach additional details to the error that is sent to the
+// remote caller.
+type ErrorWithDetails interface {
+	ErrorDetails() map[string]string
+}
+
+// RemoteError is an error that was returned by a remote peer. It can be
+// matched against the sentinel errors in this package with errors.Is, for
+// example errors.Is(err, ErrNotFound).
+type RemoteError struct {
+	// Code is the category of the error.
+	Code ErrorCode
+	// Message is the error message reported by the remote peer.
+	Message string
+	// Details contains optional additional details about the error.
+	Details map[string]string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote peer: %s: %s", e.Code, e.Message)
+}
+
+func (e *RemoteError) Is(target error) bool {
+	sentinel, ok := errorCodeSentinels[e.Code]
+	return ok && sentinel == target
+}
+
+// ApplicationError wraps an error returned by an action or receiver.
+type ApplicationError struct {
+	Err error
+}
+
+func (e *ApplicationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ApplicationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ApplicationError) Is(target error) bool {
+	return target == ErrApplication
+}
+
+// ErrorDetails returns the details of the wrapped error, if any.
+func (e *ApplicationError) ErrorDetails() map[string]string {
+	var withDetails ErrorWithDetails
+	if errors.As(e.Err, &withDetails) {
+		return withDetails.ErrorDetails()
+	}
+
+	return nil
+}
+
+// RateLimitedError is returned when a request is rejected because a rate
+// limit has been exceeded. The remote caller receives it as a RemoteError
+// with the ErrorCodeRateLimited code and the details of the limit.
+type RateLimitedError struct {
+	// Limit is the name of the limit that was exceeded.
+	Limit string
+	// RetryAfter is the amount of time to wait before the request can be
+	// retried.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Limit, e.RetryAfter)
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ErrorDetails returns the name of the limit and the amount of time to wait
+// before retrying.
+func (e *RateLimitedError) ErrorDetails() map[string]string {
+	return map[string]string{
+		"limit":       e.Limit,
+		"retry_after": e.RetryAfter.String(),
+	}
+}
@@ -3,6 +3,7 @@ package coattailtypes
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
 	"github.com/nathan-fiscaletti/coattail-go/pkg/coattailmodels"
@@ -24,6 +25,95 @@ type PeerDetails struct {
 	// The token of the peer. This is a secret token that is used to authenticate
 	// the peer. For the local peer, this should be an empty string.
 	Token string `yaml:"token" json:"-"`
+
+	// The SHA-256 fingerprint of the TLS certificate of the peer, as printed
+	// by coattail cert fingerprint. The connection fails if the peer presents
+	// another certificate.
+	Fingerprint string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+
+	// The path of a PEM bundle of the certificate authorities that the TLS
+	// certificate of the peer is verified with, relative to the peers file.
+	// Defaults to the certificate authorities of the system unless a
+	// fingerprint is set.
+	CA string `yaml:"ca,omitempty" json:"ca,omitempty"`
+
+	// The name that the TLS certificate of the peer is verified for. Defaults
+	// to the host of the address of the peer.
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+
+	// Whether or not to skip the verification of the TLS certificate of the
+	// peer. The connection can then be intercepted, so this should only be
+	// used for testing.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+
+	// The wire codecs to offer the peer in order of preference. The peer will
+	// select the first codec that it supports. Supported codecs are gob,
+	// msgpack and json. Defaults to all supported codecs.
+	Codecs []string `yaml:"codecs,omitempty" json:"codecs,omitempty"`
+
+	// The amount of time the connection to the peer can go without receiving
+	// a packet before it is closed. A negative value disables the timeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+
+	// The interval at which heartbeats are sent to the peer to keep the
+	// connection alive. A negative value disables heartbeats.
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval,omitempty" json:"keepalive_interval,omitempty"`
+
+	// The compression algorithms to offer the peer in order of preference.
+	// The peer will select the first algorithm that it supports. Supported
+	// algorithms are zstd and gzip. Defaults to no compression.
+	Compression []string `yaml:"compression,omitempty" json:"compression,omitempty"`
+
+	// The size in bytes that a packet sent to the peer must reach before it
+	// is compressed. Defaults to 1024.
+	CompressionThreshold int `yaml:"compression_threshold,omitempty" json:"compression_threshold,omitempty"`
+
+	// The maximum size in bytes of a frame accepted from the peer. Larger
+	// packets are split across multiple frames. Defaults to 1 MiB.
+	MaxFrameSize int `yaml:"max_frame_size,omitempty" json:"max_frame_size,omitempty"`
+
+	// The maximum size in bytes of a packet accepted from the peer. Defaults
+	// to 64 MiB.
+	MaxPacketSize int `yaml:"max_packet_size,omitempty" json:"max_packet_size,omitempty"`
+
+	// The amount of time to wait before reconnecting to the peer after the
+	// connection is lost or a connection attempt fails. The wait doubles
+	// after each failed attempt, with jitter. Defaults to 250ms.
+	ReconnectBackoff time.Duration `yaml:"reconnect_backoff,omitempty" json:"reconnect_backoff,omitempty"`
+
+	// The maximum amount of time to wait between connection attempts.
+	// Defaults to 30s.
+	MaxReconnectBackoff time.Duration `yaml:"max_reconnect_backoff,omitempty" json:"max_reconnect_backoff,omitempty"`
+
+	// The amount of time that calls to the peer wait for a connection before
+	// they fail. Defaults to 10s.
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty" json:"connect_timeout,omitempty"`
+
+	// The pool of connections to the peer that calls are spread across.
+	Pool PoolConfig `yaml:"pool,omitempty" json:"pool,omitempty"`
+
+	// Whether or not the token can be sent to a peer that does not support
+	// challenge authentication. By default, the connection fails instead, so
+	// that the token never leaves this peer.
+	AllowBearerToken bool `yaml:"allow_bearer_token,omitempty" json:"allow_bearer_token,omitempty"`
+}
+
+// PoolConfig configures the pool of connections to a remote peer. Calls are
+// sent on the connection with the fewest calls in flight, and connections are
+// added to the pool when every connection is busy.
+type PoolConfig struct {
+	// The number of connections that are kept open to the peer. Defaults
+	// to 1.
+	MinConnections int `yaml:"min_connections,omitempty" json:"min_connections,omitempty"`
+
+	// The maximum number of connections that can be open to the peer.
+	// Defaults to 4.
+	MaxConnections int `yaml:"max_connections,omitempty" json:"max_connections,omitempty"`
+
+	// The amount of time that a connection can go unused before it is closed,
+	// as long as more than the minimum number of connections are open.
+	// Defaults to 5m. A negative value keeps connections open.
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
 }
 
 // Peer represents any coattail peer, whether local or remote.
@@ -71,6 +161,13 @@ type ActionManager interface {
 	// is the result of the action, or an error if the action failed.
 	RunAndPublish(ctx context.Context, name string, arg any) error
 
+	// RunStream runs an action on the peer and returns a stream of its
+	// results. The name of the action should be provided as the first
+	// argument. The second argument is the data that should be passed to the
+	// action. Actions that are not streaming actions produce a single result.
+	// The stream should be closed once it is no longer needed.
+	RunStream(ctx context.Context, name string, arg any) (ActionStream, error)
+
 	// ListActions returns a list of all actions that are available on the peer.
 	// The return value is a list of action names, or an error if the list could
 	// not be retrieved.
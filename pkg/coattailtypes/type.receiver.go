@@ -2,6 +2,7 @@ package coattailtypes
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 
 	"github.com/invopop/jsonschema"
@@ -15,16 +16,45 @@ type ReceiverWithInputSchema interface {
 	InputSchema() *jsonschema.Schema
 }
 
+// OrderedUnit is implemented by units that run the notifications from each
+// publisher one at a time, in the order that they were published.
+type OrderedUnit interface {
+	Unit
+	Ordered() bool
+}
+
+// ReceiverOption configures a receiver created with NewReceiver.
+type ReceiverOption func(*receiverOptions)
+
+type receiverOptions struct {
+	ordered bool
+}
+
+// WithOrderedDelivery makes the receiver run the notifications from each
+// publisher one at a time, in the order that they were published, instead of
+// running them as they arrive. A notification waits up to 10 seconds for the
+// notifications published before it, after which they are skipped.
+func WithOrderedDelivery() ReceiverOption {
+	return func(o *receiverOptions) {
+		o.ordered = true
+	}
+}
+
 type receiverUnit[A any] struct {
 	name     string
 	receiver Receiver[A]
+	options  receiverOptions
 }
 
 func (a *receiverUnit[A]) Execute(ctx context.Context, args any) (any, error) {
 	var argument *A
 
-	if argsAny, ok := args.(A); ok {
-		argument = &argsAny
+	if args != nil {
+		argsValue, err := ConvertValue[A](args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+		}
+		argument = &argsValue
 	}
 
 	err := a.receiver.Execute(ctx, argument)
@@ -47,7 +77,11 @@ func (a *receiverUnit[A]) OutputSchema() *jsonschema.Schema {
 	return nil
 }
 
-func NewReceiver[A any](receiver Receiver[A]) Unit {
+func (a *receiverUnit[A]) Ordered() bool {
+	return a.options.ordered
+}
+
+func NewReceiver[A any](receiver Receiver[A], options ...ReceiverOption) Unit {
 	// get name of action using reflection, considering that it might be a pointer
 	// if it is a pointer, we need to get the name of the underlying type
 	receiverType := reflect.TypeOf(receiver)
@@ -56,8 +90,13 @@ func NewReceiver[A any](receiver Receiver[A]) Unit {
 	}
 	name := receiverType.Name()
 
-	return &receiverUnit[A]{
+	unit := &receiverUnit[A]{
 		name:     name,
 		receiver: receiver,
 	}
+	for _, option := range options {
+		option(&unit.options)
+	}
+
+	return unit
 }
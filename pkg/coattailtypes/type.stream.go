@@ -0,0 +1,143 @@
+package coattailtypes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// ErrStreamClosed is returned by ActionStream.Recv once the stream has
+	// been closed.
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// StreamingAction is an action that produces a sequence of results rather
+// than a single result. Results are sent to the caller as they are produced
+// using the StreamWriter passed to Execute. The stream is complete once
+// Execute returns.
+type StreamingAction[
+	A any,
+	R any,
+] interface {
+	Execute(context.Context, *A, StreamWriter[R]) error
+}
+
+// StreamWriter sends results produced by a StreamingAction to the caller.
+// Send blocks while the caller is not keeping up with the results, and
+// returns an error if the caller has stopped receiving them.
+type StreamWriter[R any] interface {
+	Send(R) error
+}
+
+type streamWriterFunc[R any] func(R) error
+
+func (f streamWriterFunc[R]) Send(result R) error {
+	return f(result)
+}
+
+// StreamingUnit is a Unit that can send its results to the caller as they
+// are produced.
+type StreamingUnit interface {
+	Unit
+	ExecuteStream(ctx context.Context, args any, send func(any) error) error
+}
+
+type StreamingActionUnit[
+	A any,
+	R any,
+] struct {
+	name   string
+	action StreamingAction[A, R]
+}
+
+// Execute runs the action to completion and returns all of its results as
+// a []R.
+func (a *StreamingActionUnit[A, R]) Execute(ctx context.Context, args any) (any, error) {
+	results := []R{}
+
+	err := a.ExecuteStream(ctx, args, func(result any) error {
+		results = append(results, result.(R))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (a *StreamingActionUnit[A, R]) ExecuteStream(ctx context.Context, args any, send func(any) error) error {
+	var argument *A
+
+	if args != nil {
+		argsValue, err := ConvertValue[A](args)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+		}
+		argument = &argsValue
+	}
+
+	return a.action.Execute(ctx, argument, streamWriterFunc[R](func(result R) error {
+		return send(result)
+	}))
+}
+
+func (a *StreamingActionUnit[A, R]) Name() string {
+	return a.name
+}
+
+func NewStreamingAction[
+	A any,
+	R any,
+](action StreamingAction[A, R]) Unit {
+	actionType := reflect.TypeOf(action)
+	if actionType.Kind() == reflect.Ptr {
+		actionType = actionType.Elem()
+	}
+	name := actionType.Name()
+
+	return &StreamingActionUnit[A, R]{
+		name:   name,
+		action: action,
+	}
+}
+
+// ActionStream is a stream of results produced by an action.
+type ActionStream interface {
+	// Recv returns the next result produced by the action. Returns io.EOF
+	// once the action has completed and all of its results have been
+	// received, and ErrStreamClosed once Close has been called. Recv must
+	// not be called concurrently.
+	Recv() (any, error)
+
+	// Close stops receiving results and cancels the action if it is still
+	// running.
+	Close() error
+}
+
+// TypedActionStream is an ActionStream whose results are converted to R.
+type TypedActionStream[R any] struct {
+	ActionStream
+}
+
+// NewTypedActionStream wraps an ActionStream so that its results are
+// converted to R.
+func NewTypedActionStream[R any](stream ActionStream) *TypedActionStream[R] {
+	return &TypedActionStream[R]{
+		ActionStream: stream,
+	}
+}
+
+// Recv returns the next result produced by the action converted to R.
+// Returns io.EOF once the action has completed.
+func (s *TypedActionStream[R]) Recv() (R, error) {
+	result, err := s.ActionStream.Recv()
+	if err != nil {
+		var zero R
+		return zero, err
+	}
+
+	return ConvertValue[R](result)
+}
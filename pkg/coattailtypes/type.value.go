@@ -0,0 +1,37 @@
+package coattailtypes
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ConvertValue converts a value received from a peer to T. Codecs that don't
+// carry Go type information, such as json and msgpack, decode values into
+// generic maps and slices. These values are converted by re-encoding them as
+// JSON and decoding the result into T.
+func ConvertValue[T any](v any) (T, error) {
+	var out T
+
+	switch value := v.(type) {
+	case nil:
+		return out, nil
+	case T:
+		return value, nil
+	case *T:
+		if value != nil {
+			return *value, nil
+		}
+		return out, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out, fmt.Errorf("failed to convert %T to %T: %w", v, out, err)
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("failed to convert %T to %T: %w", v, out, err)
+	}
+
+	return out, nil
+}
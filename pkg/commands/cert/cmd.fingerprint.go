@@ -0,0 +1,25 @@
+package cert
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewFingerprintCommand() *cobra.Command {
+	var certFile string
+
+	cmd := &cobra.Command{
+		Use:   "fingerprint [-c <certificate>]",
+		Short: "Print the SHA-256 fingerprint that peers pin to trust the certificate",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			api.CertificateFingerprint(certFile)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&certFile, "certificate", "c", config.DefaultCertificateFile, "Path to the certificate of the host")
+
+	return cmd
+}
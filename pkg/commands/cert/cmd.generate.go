@@ -0,0 +1,28 @@
+package cert
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewGenerateCommand() *cobra.Command {
+	var certFile string
+	var keyFile string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "generate [-c <certificate>] [-k <key>] [--force] [host...]",
+		Short: "Generate a self-signed certificate for the provided host names and IP addresses",
+		Run: func(cmd *cobra.Command, args []string) {
+			api.GenerateCertificate(certFile, keyFile, args, force)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&certFile, "certificate", "c", config.DefaultCertificateFile, "Path to write the certificate to")
+	cmd.Flags().StringVarP(&keyFile, "key", "k", config.DefaultCertificateKeyFile, "Path to write the private key to")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing certificate and key")
+
+	return cmd
+}
@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/pkg/commands/cert"
+	"github.com/spf13/cobra"
+)
+
+func NewCertCmd() *cobra.Command {
+	certCmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Manage the TLS certificate of the host",
+	}
+
+	certCmd.AddCommand(cert.NewFingerprintCommand())
+	certCmd.AddCommand(cert.NewGenerateCommand())
+
+	return certCmd
+}
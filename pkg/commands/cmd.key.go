@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/pkg/commands/key"
+	"github.com/spf13/cobra"
+)
+
+func NewKeyCmd() *cobra.Command {
+	keyCmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage the keys used to sign tokens",
+	}
+
+	keyCmd.AddCommand(key.NewAddCommand())
+	keyCmd.AddCommand(key.NewPromoteCommand())
+	keyCmd.AddCommand(key.NewRetireCommand())
+	keyCmd.AddCommand(key.NewListCommand())
+	keyCmd.AddCommand(key.NewTrustCommand())
+	keyCmd.AddCommand(key.NewPublicCommand())
+
+	return keyCmd
+}
@@ -12,6 +12,7 @@ func NewTokenCmd() *cobra.Command {
 	}
 
 	tokenCmd.AddCommand(token.NewCreateCommand())
+	tokenCmd.AddCommand(token.NewRevokeCommand())
 
 	return tokenCmd
 }
@@ -0,0 +1,30 @@
+package key
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/nathan-fiscaletti/coattail-go/internal/services/authentication"
+	"github.com/spf13/cobra"
+)
+
+func NewAddCommand() *cobra.Command {
+	var keyringFile string
+	var keyType string
+	var primary bool
+
+	cmd := &cobra.Command{
+		Use:   "add [-k <keyring>] [-t <type>] [--primary]",
+		Short: "Add a new key to the keyring",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			api.AddKey(keyringFile, keyType, primary)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&keyringFile, "keyring", "k", config.DefaultKeyringFile, "Path to the keyring of the host")
+	cmd.Flags().StringVarP(&keyType, "type", "t", authentication.KeyTypeHMAC, "Type of the key, hmac or ed25519")
+	cmd.Flags().BoolVar(&primary, "primary", false, "Sign new tokens with the key")
+
+	return cmd
+}
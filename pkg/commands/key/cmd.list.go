@@ -0,0 +1,25 @@
+package key
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewListCommand() *cobra.Command {
+	var keyringFile string
+
+	cmd := &cobra.Command{
+		Use:   "list [-k <keyring>]",
+		Short: "List the keys of the keyring",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			api.ListKeys(keyringFile)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&keyringFile, "keyring", "k", config.DefaultKeyringFile, "Path to the keyring of the host")
+
+	return cmd
+}
@@ -0,0 +1,25 @@
+package key
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewPromoteCommand() *cobra.Command {
+	var keyringFile string
+
+	cmd := &cobra.Command{
+		Use:   "promote [-k <keyring>] <id>",
+		Short: "Sign new tokens with a key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			api.PromoteKey(keyringFile, args[0])
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&keyringFile, "keyring", "k", config.DefaultKeyringFile, "Path to the keyring of the host")
+
+	return cmd
+}
@@ -0,0 +1,25 @@
+package key
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewPublicCommand() *cobra.Command {
+	var keyringFile string
+
+	cmd := &cobra.Command{
+		Use:   "public [-k <keyring>]",
+		Short: "Print the ed25519 public keys of the keyring",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			api.PublicKeys(keyringFile)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&keyringFile, "keyring", "k", config.DefaultKeyringFile, "Path to the keyring of the host")
+
+	return cmd
+}
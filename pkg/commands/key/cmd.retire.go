@@ -0,0 +1,43 @@
+package key
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewRetireCommand() *cobra.Command {
+	var keyringFile string
+	var grace time.Duration
+	var until string
+
+	cmd := &cobra.Command{
+		Use:   "retire [-k <keyring>] [--for <duration> | --until <time>] <id>",
+		Short: "Retire a key, which verifies tokens until a cutoff",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cutoff := time.Now().Add(grace)
+			if until != "" {
+				var err error
+				cutoff, err = time.Parse(time.RFC3339, until)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: failed to parse cutoff: %s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			api.RetireKey(keyringFile, args[0], cutoff)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&keyringFile, "keyring", "k", config.DefaultKeyringFile, "Path to the keyring of the host")
+	cmd.Flags().DurationVar(&grace, "for", 24*time.Hour, "How long the key keeps verifying tokens")
+	cmd.Flags().StringVar(&until, "until", "", "Time until which the key keeps verifying tokens, in RFC3339 (overrides --for)")
+
+	return cmd
+}
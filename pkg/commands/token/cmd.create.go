@@ -25,9 +25,9 @@ func NewCreateCommand() *cobra.Command {
 	allPerms := int(permission.PermissionMask(permission.All))
 
 	// Adding flags
-	cmd.Flags().StringVarP(&keyfile, "keyfile", "k", "", "Path to the key file (required)")
+	cmd.Flags().StringVarP(&keyfile, "keyfile", "k", "", "Path to the keyring or key file (required)")
 	cmd.Flags().StringVarP(&network, "network", "n", "0.0.0.0/0", "Specify the authorized network with CIDR notation")
-	cmd.Flags().IntVarP(&perm, "perm", "p", allPerms, fmt.Sprintf("Permission level as an integer [0-%d]", allPerms))
+	cmd.Flags().IntVarP(&perm, "perm", "p", allPerms, fmt.Sprintf("Permission level as an integer [0-%d], plus %d to allow revoking tokens through the api", allPerms, permission.RevokeTokens))
 	cmd.Flags().StringVarP(&expiry, "expiry", "e", "", "Expiry time for the token (default 24 hours from now)")
 
 	// Mark `keyfile` as required
@@ -0,0 +1,27 @@
+package token
+
+import (
+	"github.com/nathan-fiscaletti/coattail-go/internal/api"
+	"github.com/nathan-fiscaletti/coattail-go/internal/host/config"
+	"github.com/spf13/cobra"
+)
+
+func NewRevokeCommand() *cobra.Command {
+	var databaseFile string
+	var reason string
+
+	cmd := &cobra.Command{
+		Use:   "revoke [-d <database>] [-r <reason>] <token|id>",
+		Short: "Revoke a token",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			api.RevokeToken(databaseFile, args[0], reason)
+		},
+	}
+
+	// Adding flags
+	cmd.Flags().StringVarP(&databaseFile, "database", "d", config.DefaultDatabaseFile, "Path to the database of the host")
+	cmd.Flags().StringVarP(&reason, "reason", "r", "", "Reason the token is revoked")
+
+	return cmd
+}